@@ -0,0 +1,133 @@
+package main
+
+// The betting structures a table can be played under.
+const (
+	noLimit    = "no_limit"
+	potLimit   = "pot_limit"
+	fixedLimit = "fixed_limit"
+)
+
+// bettingStructure limits how much a player may raise by. The rules every
+// structure shares, like calling in full unless all in, are enforced by
+// Pot.betInvalid.
+type bettingStructure interface {
+	// name is the structure's name in hand histories.
+	name() string
+	// raiseLimits returns the least and most player may raise by. A
+	// player may raise by less than the least only by going all in. max
+	// is 0 if player may not raise at all.
+	raiseLimits(g *Game, player *Player) (min, max money)
+}
+
+var bettingStructures = map[string]bettingStructure{
+	noLimit:    noLimitBetting{},
+	potLimit:   potLimitBetting{},
+	fixedLimit: fixedLimitBetting{},
+}
+
+// noLimitBetting lets a player raise by anything from the last raise, or
+// the big blind if there hasn't been one, up to everything they have.
+type noLimitBetting struct{}
+
+func (noLimitBetting) name() string { return "No Limit" }
+
+func (noLimitBetting) raiseLimits(g *Game, player *Player) (min, max money) {
+	return smallestRaise(g), player.wealth
+}
+
+// potLimitBetting lets a player raise by anything from the smallest no
+// limit raise up to the size of the pot once they have called.
+type potLimitBetting struct{}
+
+func (potLimitBetting) name() string { return "Pot Limit" }
+
+func (potLimitBetting) raiseLimits(g *Game, player *Player) (min, max money) {
+	return smallestRaise(g), g.pot.totalInPot() + toCall(g, player)
+}
+
+// fixedLimitBetting has every bet and raise be of one size: the big blind
+// before the flop and on it, and twice the big blind on the turn and
+// river. Once a street has had RaiseCap bets and raises, counting the big
+// blind as the first bet before the flop, players may only call. In stud,
+// the first bet after the bring-in completes it to the big blind.
+type fixedLimitBetting struct{}
+
+func (fixedLimitBetting) name() string { return "Limit" }
+
+func (fixedLimitBetting) raiseLimits(g *Game, player *Player) (min, max money) {
+	if g.pot.raises >= g.rules.RaiseCap {
+		return 0, 0
+	}
+	if g.variant().stud() && g.round == 0 && g.pot.raises == 0 && g.pot.totalToCall < g.rules.BigBlind {
+		complete := g.rules.BigBlind - g.pot.totalToCall
+		return complete, complete
+	}
+	size := g.rules.BigBlind
+	if g.round >= 2 {
+		size *= 2
+	}
+	return size, size
+}
+
+// smallestRaise returns the least a no limit or pot limit player may raise by.
+func smallestRaise(g *Game) money {
+	if g.pot.minRaise > g.rules.BigBlind {
+		return g.pot.minRaise
+	}
+	return g.rules.BigBlind
+}
+
+// toCall returns what player must put in to call, ignoring whether they can afford it.
+func toCall(g *Game, player *Player) money {
+	soFar := g.pot.totalPlayerBetThisRound(player.guid)
+	if soFar >= g.pot.totalToCall {
+		return 0
+	}
+	return g.pot.totalToCall - soFar
+}
+
+// bettingNamed returns the betting structure called name. Games logged
+// before tables had a choice are no limit.
+func bettingNamed(name string) bettingStructure {
+	if b, ok := bettingStructures[name]; ok {
+		return b
+	}
+	return noLimitBetting{}
+}
+
+func (g *Game) betting() bettingStructure {
+	return bettingNamed(g.rules.Betting)
+}
+
+// betInvalid returns true if player can't bet bet, under the rules every
+// structure shares or under the table's betting structure.
+func (g *Game) betInvalid(player *Player, bet money) bool {
+	if g.pot.betInvalid(player, bet) {
+		return true
+	}
+	call := toCall(g, player)
+	if bet <= call {
+		return false
+	}
+	raise := bet - call
+	min, max := g.betting().raiseLimits(g, player)
+	return raise > max || (raise < min && bet < player.wealth)
+}
+
+// betLimits returns the least and most player may bet to raise. If player
+// can't raise, both are what it would take them to call.
+func (g *Game) betLimits(player *Player) (min, max money) {
+	call := toCall(g, player)
+	minRaise, maxRaise := g.betting().raiseLimits(g, player)
+	min, max = call+minRaise, call+maxRaise
+	if maxRaise == 0 {
+		min = call
+	}
+	if min > player.wealth {
+		min = player.wealth
+	}
+	if max > player.wealth {
+		max = player.wealth
+	}
+	return min, max
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Card is a playing card: its rank, from deuce (2) to ace (14), in the
+// high bits and its suit in the low two. The zero Card is no card at all.
+// In JSON, and anywhere else it's written out, a card is its two-character
+// name, e.g. "AS" or "TD".
+type Card uint8
+
+// SUITS are the suits' initials, in the order Card numbers them.
+const SUITS = "CDHS"
+
+func newCard(rank int, suit int) Card {
+	return Card(rank<<2 | suit)
+}
+
+// rank returns the card's rank, from 2 to 14 for an ace.
+func (c Card) rank() int {
+	return int(c >> 2)
+}
+
+// suit returns the card's suit, as an index into SUITS.
+func (c Card) suit() int {
+	return int(c & 3)
+}
+
+func (c Card) String() string {
+	if c.rank() < 2 || c.rank() > 14 {
+		return "??"
+	}
+	return RANKS[c.rank():c.rank()+1] + SUITS[c.suit():c.suit()+1]
+}
+
+// parseCard returns the card called name, e.g. "AS".
+func parseCard(name string) (Card, error) {
+	if len(name) != 2 {
+		return 0, errors.New("A card is its rank and suit, like AS.")
+	}
+	rank := strings.IndexByte(RANKS, name[0])
+	suit := strings.IndexByte(SUITS, name[1])
+	if rank < 2 || suit < 0 {
+		return 0, errors.New("Unknown card " + name + ".")
+	}
+	return newCard(rank, suit), nil
+}
+
+// mustParseCards returns the cards named in s, separated by spaces, and
+// panics if any of them isn't a card.
+func mustParseCards(s string) []Card {
+	cards := make([]Card, 0)
+	for _, name := range strings.Fields(s) {
+		card, err := parseCard(name)
+		if err != nil {
+			panic(err)
+		}
+		cards = append(cards, card)
+	}
+	return cards
+}
+
+func (c Card) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Card) UnmarshalText(text []byte) (err error) {
+	*c, err = parseCard(string(text))
+	return err
+}
+
+// joinCards returns the names of cards separated by spaces.
+func joinCards(cards []Card) string {
+	names := make([]string, len(cards))
+	for i, card := range cards {
+		names[i] = card.String()
+	}
+	return strings.Join(names, " ")
+}
+
+// sameCards returns true if a and b are the same cards, in any order.
+func sameCards(a, b []Card) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	var count [64]int
+	for i := range a {
+		count[a[i]]++
+		count[b[i]]--
+	}
+	for _, n := range count {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// generateCards returns the 52 cards of a standard deck in the order
+// shuffles permute them: spades, clubs, diamonds, then hearts, each from
+// deuce to ace.
+func generateCards() (deck [52]Card) {
+	i := 0
+	for _, suit := range "SCDH" {
+		for rank := 2; rank <= 14; rank++ {
+			deck[i] = newCard(rank, strings.IndexRune(SUITS, suit))
+			i++
+		}
+	}
+	return deck
+}
+
+// place is where a card has been dealt to.
+type place uint8
+
+const (
+	undealt place = iota
+	// hole cards are dealt to a player, in stud face up or down
+	hole
+	flop
+	turn
+	river
+)
+
+// location is where in a hand a card has been dealt: to the board, or to
+// a player. A stud card also has the street it was dealt on, counting
+// third street as 0, and whether it was dealt face up. A board card of a
+// hand run twice has the run it was dealt to, counting the first as 0.
+type location struct {
+	place  place
+	player guid
+	street int
+	up     bool
+	run    int
+}
+
+// boardPlaces are where the board cards revealed on each round are dealt.
+var boardPlaces = []place{undealt, flop, turn, river}
+
+// dealtCard is a card and where it was dealt.
+type dealtCard struct {
+	card Card
+	location
+}
+
+// Deck is the cards dealt in a hand, in the order they were dealt.
+type Deck []dealtCard
+
+// deal deals card to l.
+func (d *Deck) deal(card Card, l location) {
+	*d = append(*d, dealtCard{card, l})
+}
+
+// at returns the cards dealt to l, in the order they were dealt.
+func (d Deck) at(l location) []Card {
+	cards := make([]Card, 0)
+	for _, c := range d {
+		if c.location == l {
+			cards = append(cards, c.card)
+		}
+	}
+	return cards
+}
+
+// board returns the cards dealt to p, one of flop, turn or river.
+func (d Deck) board(p place) []Card {
+	return d.at(location{place: p})
+}
+
+// runBoard returns the board of the given run of the hand: the cards dealt
+// to it, and those dealt before the hand was run twice, which every run
+// shares.
+func (d Deck) runBoard(run int) []Card {
+	board := make([]Card, 0)
+	for _, p := range boardPlaces[1:] {
+		cards := d.at(location{place: p, run: run})
+		if len(cards) == 0 {
+			cards = d.board(p)
+		}
+		board = append(board, cards...)
+	}
+	return board
+}
+
+// holeCards returns every card dealt to player, up or down.
+func (d Deck) holeCards(player guid) []Card {
+	cards := make([]Card, 0)
+	for _, c := range d {
+		if c.place == hole && c.player == player {
+			cards = append(cards, c.card)
+		}
+	}
+	return cards
+}
+
+func (d Deck) String() string {
+	s := "[\n"
+	for _, c := range d {
+		s += "  " + c.card.String() + " " + c.location.String() + "\n"
+	}
+	return s + "]"
+}
+
+func (l location) String() string {
+	switch l.place {
+	case hole:
+		if l.up {
+			return fmt.Sprintf("%v:%v:UP", l.player, l.street)
+		}
+		return fmt.Sprintf("%v:%v", l.player, l.street)
+	case flop:
+		return fmt.Sprintf("FLOP:%v", l.run)
+	case turn:
+		return fmt.Sprintf("TURN:%v", l.run)
+	case river:
+		return fmt.Sprintf("RIVER:%v", l.run)
+	}
+	return "UNDEALT"
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCardJSON(t *testing.T) {
+	cards := mustParseCards("AS TD 2C 9H")
+	b, err := json.Marshal(PublicCards{Hole: cards, Upcards: map[guid][]Card{"player-1": cards[:1]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"hole":["AS","TD","2C","9H"],"flop":null,"turn":null,"river":null,"upcards":{"player-1":["AS"]}}`
+	if string(b) != expected {
+		t.Errorf("cards marshal to %s, expected %s", b, expected)
+	}
+	var pc PublicCards
+	if err := json.Unmarshal(b, &pc); err != nil || !sameCards(pc.Hole, cards) {
+		t.Errorf("got %v (%v) unmarshalling %s, expected %v", pc.Hole, err, b, cards)
+	}
+	if err := json.Unmarshal([]byte(`["AS","1D"]`), &cards); err == nil {
+		t.Errorf("unmarshalled 1D as a card")
+	}
+}
+
+func TestParseCard(t *testing.T) {
+	for _, name := range []string{"2C", "9D", "TH", "AS"} {
+		card, err := parseCard(name)
+		if err != nil || card.String() != name {
+			t.Errorf("parsed %v as %v (%v)", name, card, err)
+		}
+	}
+	for _, name := range []string{"", "A", "AX", "1S", "ASS"} {
+		if card, err := parseCard(name); err == nil {
+			t.Errorf("parsed %q as %v, expected an error", name, card)
+		}
+	}
+	deck := generateCards()
+	if deck[0].String() != "2S" || deck[51].String() != "AH" {
+		t.Errorf("deck runs from %v to %v, expected 2S to AH", deck[0], deck[51])
+	}
+}
+
+func TestDeckLocations(t *testing.T) {
+	var d Deck
+	d.deal(mustParseCards("AS")[0], location{place: hole, player: "player-1"})
+	d.deal(mustParseCards("KS")[0], location{place: hole, player: "player-1", street: 1, up: true})
+	d.deal(mustParseCards("QS")[0], location{place: flop})
+	d.deal(mustParseCards("JS")[0], location{place: flop})
+	if cards := d.holeCards("player-1"); joinCards(cards) != "AS KS" {
+		t.Errorf("player-1 was dealt %v, expected AS KS", cards)
+	}
+	if cards := d.at(location{place: hole, player: "player-1"}); joinCards(cards) != "AS" {
+		t.Errorf("player-1 was dealt %v down on third street, expected AS", cards)
+	}
+	if cards := d.board(flop); joinCards(cards) != "QS JS" {
+		t.Errorf("the flop is %v, expected QS JS", cards)
+	}
+	if cards := d.board(river); len(cards) != 0 {
+		t.Errorf("the river is %v before it's dealt", cards)
+	}
+}
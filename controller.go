@@ -2,14 +2,19 @@ package main
 
 import (
 	"fmt"
-	"sort"
+	"log"
+	"path/filepath"
 	"sync"
 	"time"
 )
 
 type GameController struct {
-	Games map[guid]*Game
-	auth  authenticator
+	Games       map[guid]*Game
+	Tournaments map[guid]*MultiTable
+	auth        authenticator
+	logDir      string
+	// ledger keeps players' bankrolls, if the server keeps them.
+	ledger *Ledger
 }
 
 type PublicPlayer struct {
@@ -19,23 +24,42 @@ type PublicPlayer struct {
 	Wealth     money  `json:"wealth"`
 	InFor      money  `json:"bet_so_far"`
 	SmallBlind bool   `json:"small_blind"`
+	Straddle   bool   `json:"straddle"`
 }
 
 type PublicTable []*PublicPlayer
 
 type Turn struct {
-	Player      guid   `json:"playerID"`
-	PlayerBet   money  `json:"bet_so_far"`
-	BetToPlayer money  `json:"bet_to_player"`
-	MinRaise    money  `json:"minimum_raise"`
-	Expiry      string `json:"expiry"`
+	Player      guid  `json:"playerID"`
+	PlayerBet   money `json:"bet_so_far"`
+	BetToPlayer money `json:"bet_to_player"`
+	MinRaise    money `json:"minimum_raise"`
+	MinBet      money `json:"min_bet"`
+	MaxBet      money `json:"max_bet"`
+	// Expiry is when the player runs out of time, and TimeBank is the
+	// seconds of time bank they have left to use after that.
+	Expiry   string `json:"expiry"`
+	TimeBank int    `json:"time_bank"`
 }
 
 type PublicCards struct {
-	Hole  []string `json:"hole"`
-	Flop  []string `json:"flop"`
-	Turn  []string `json:"turn"`
-	River []string `json:"river"`
+	Hole  []Card `json:"hole"`
+	Flop  []Card `json:"flop"`
+	Turn  []Card `json:"turn"`
+	River []Card `json:"river"`
+	// Upcards are the cards each player still in a stud hand is showing.
+	Upcards map[guid][]Card `json:"upcards,omitempty"`
+	// SecondBoard is the board of the second run, if the hand is being
+	// run twice.
+	SecondBoard []Card `json:"second_board,omitempty"`
+}
+
+// AllInShowdown is shown once nobody still in a hand can bet: every
+// player's hole cards, and their equity with the board dealt so far.
+type AllInShowdown struct {
+	Hole     map[guid][]Card `json:"hole"`
+	Equities map[guid]Equity `json:"equities,omitempty"`
+	RunTwice bool            `json:"run_twice"`
 }
 
 type PublicPots []*PublicPot
@@ -46,18 +70,65 @@ type PublicPot struct {
 }
 
 type PublicGame struct {
-	GameID          string       `json:"gameID"`
-	Table           PublicTable  `json:"table"`
-	Turn            *Turn        `json:"turn"`
-	Cards           *PublicCards `json:"cards"`
-	Pots            *PublicPots  `json:"pots"`
-	LastHandWinners []Playerhand `json:"last_winners"`
+	GameID          string            `json:"gameID"`
+	Shuffle         string            `json:"shuffle"`
+	Variant         string            `json:"variant"`
+	Rules           Rules             `json:"rules"`
+	Commitment      string            `json:"commitment"`
+	Table           PublicTable       `json:"table"`
+	Turn            *Turn             `json:"turn"`
+	Cards           *PublicCards      `json:"cards"`
+	Pots            *PublicPots       `json:"pots"`
+	LastHandWinners []Playerhand      `json:"last_winners"`
+	LastShowdown    []ShownHand       `json:"last_showdown"`
+	Tournament      *TournamentStatus `json:"tournament,omitempty"`
+	AllIn           *AllInShowdown    `json:"all_in,omitempty"`
+	// PreAction is the pre-action the player viewing the game has queued,
+	// if any. It is only shown to that player.
+	PreAction *PreAction `json:"pre_action,omitempty"`
+}
+
+// PreAction is an action a player queues up to be taken for them when
+// their turn comes: "fold", "check_fold" to check if they can and fold if
+// not, "call" to call as long as it takes no more than Amount, or
+// "call_any" to call whatever it takes. It is dropped once taken, or once
+// the bet facing the player changes.
+type PreAction struct {
+	Action string `json:"action"`
+	Amount money  `json:"amount,omitempty"`
+}
+
+func (p PreAction) valid() bool {
+	switch p.Action {
+	case "fold", "check_fold", "call", "call_any":
+		return true
+	}
+	return false
+}
+
+// ShownHand is a hand shown at the end of the last hand, either at
+// showdown, in the order the players showed, or by a player who won
+// without one. Mucked hands are shown without their cards.
+type ShownHand struct {
+	PlayerID guid   `json:"playerID"`
+	Hole     []Card `json:"hole,omitempty"`
+	Hand     Hand   `json:"hand,omitempty"`
+	Low      Hand   `json:"low,omitempty"`
+	Mucked   bool   `json:"mucked,omitempty"`
 }
 
 type authenticator map[guid]guid
 
+// TIMEOUT is the default number of seconds a player has to act.
 const TIMEOUT = 100
 
+// TIME_BANK is the default number of seconds in a player's time bank, and
+// TIME_BANK_REFILL the default number given back after each hand.
+const (
+	TIME_BANK        = 30
+	TIME_BANK_REFILL = 5
+)
+
 func (gc *GameController) getGames() []*Game {
 	gs := make([]*Game, 0)
 	for _, g := range gc.Games {
@@ -67,15 +138,30 @@ func (gc *GameController) getGames() []*Game {
 }
 
 func (gc *GameController) getGame(game guid) PublicGame {
-	return *gc.Games[game].controller.public
+	return gc.Games[game].controller.publicGame()
+}
+
+func (gc *GameController) makeGame(seed int64, shuffle string, rules Rules) *PublicGame {
+	g := NewGame(gc, seed, rules)
+	g.shuffle = shuffle
+	gc.startGame(g)
+	pg := g.controller.publicGame()
+	return &pg
 }
 
-func (gc *GameController) makeGame() *PublicGame {
-	g := NewGame(gc)
+// startGame logs g, if games are being logged, and starts playing it.
+func (gc *GameController) startGame(g *Game) {
+	if gc.logDir != "" {
+		l, err := createEventLog(filepath.Join(gc.logDir, string(g.gameID)+".log"), g)
+		if err != nil {
+			log.Printf("Could not create log for game %v; it will not be replayable: %v\n", g.gameID, err)
+		}
+		g.controller.log = l
+	}
 	gc.Games[g.gameID] = g
+	// the public view is brought up to date with how g was set up
+	g.controller.refresh(g)
 	go g.run()
-	pg := MakePublicGame(g)
-	return pg
 }
 
 func MakeTurn() *Turn {
@@ -88,10 +174,21 @@ func MakeTurn() *Turn {
 func MakePublicGame(g *Game) *PublicGame {
 	pg := new(PublicGame)
 	pg.GameID = string(g.gameID)
+	pg.Shuffle = g.shuffle
+	pg.Variant = g.rules.Variant
+	pg.Rules = g.rules
+	if g.history != nil {
+		pg.Commitment = g.history.Commitment
+	}
 	pg.Table = make(PublicTable, 0)
 	for _, player := range g.table {
 		pg.Table = append(pg.Table, MakePublicPlayer(g, player))
 	}
+	for _, player := range g.sittingOut {
+		pp := MakePublicPlayer(g, player)
+		pp.State = "sitting_out"
+		pg.Table = append(pg.Table, pp)
+	}
 	pg.Turn = MakeTurn()
 	pg.Turn.BetToPlayer = g.pot.totalToCall
 	pg.Turn.MinRaise = g.pot.minRaise
@@ -99,6 +196,17 @@ func MakePublicGame(g *Game) *PublicGame {
 	pg.Turn.Expiry = "" // ??
 	pg.Cards = MakePublicCards(g)
 	pg.Pots = MakePublicPots(g)
+	if g.tournament != nil {
+		pg.Tournament = g.tournament.status()
+	}
+	if g.allIn {
+		pg.AllIn = &AllInShowdown{Hole: make(map[guid][]Card), Equities: g.equities, RunTwice: g.runTwice}
+		for _, player := range g.table {
+			if player.state != folded {
+				pg.AllIn.Hole[player.guid] = g.deck.holeCards(player.guid)
+			}
+		}
+	}
 	return pg
 }
 
@@ -106,36 +214,38 @@ func (gc *GameController) getGamePrivate(game, player guid) (pg PublicGame) {
 	g := gc.Games[game]
 	pg = gc.getGame(game)
 	pg.Cards = MakePublicCards(g)
-	pg.Cards.Hole = g.deck.Get(string(player))
+	pg.Cards.Hole = g.holeCards(player)
+	pg.PreAction = g.controller.preAction(player)
 	return pg
 }
 
 func MakePublicCards(g *Game) (pc *PublicCards) {
 	pc = new(PublicCards)
+	if g.variant().stud() {
+		pc.Upcards = make(map[guid][]Card)
+		for _, p := range g.table {
+			if p.state != folded && g.history != nil {
+				pc.Upcards[p.guid] = append(make([]Card, 0), g.history.Upcards[p.guid]...)
+			}
+		}
+		return pc
+	}
 	switch g.round {
 	case 3: // river
-		pc.River = g.deck.Get("RIVER")
+		pc.River = g.deck.board(river)
 		fallthrough
 	case 2: // turn
-		pc.Turn = g.deck.Get("TURN")
+		pc.Turn = g.deck.board(turn)
 		fallthrough
 	case 1: // flop
-		pc.Flop = g.deck.Get("FLOP")
+		pc.Flop = g.deck.board(flop)
 		fallthrough
 	default: // pre-flop
 	}
-	return pc
-}
-
-func (deck Deck) Get(wanted string) (cards []string) {
-	cards = make([]string, 0)
-	for card, location := range deck {
-		if location == wanted {
-			cards = append(cards, card)
-		}
+	if g.runTwice && g.history != nil {
+		pc.SecondBoard = append(make([]Card, 0), g.history.SecondBoard...)
 	}
-	sort.Strings(cards)
-	return cards
+	return pc
 }
 
 func MakePublicPlayer(g *Game, p *Player) (pp *PublicPlayer) {
@@ -153,9 +263,16 @@ func MakePublicPlayer(g *Game, p *Player) (pp *PublicPlayer) {
 	default:
 		panic("unknown state")
 	}
-	if g.table[0] == p {
+	// a player leaving shows as leaving once they are out of the hand
+	if p.state == folded && g.controller != nil && g.controller.leaving(p.guid) {
+		pp.State = "leaving"
+	}
+	if len(g.table) > 0 && g.table[0] == p {
 		pp.SmallBlind = true
 	}
+	if g.straddler == p {
+		pp.Straddle = true
+	}
 	return pp
 }
 
@@ -166,7 +283,7 @@ func MakePublicPots(g *Game) (pp *PublicPots) {
 			*pp = append(*pp, new(PublicPot))
 		}
 		pot := (*pp)[bet.potNumber]
-		if !contains(pot.Players, bet.player) {
+		if !bet.dead && !contains(pot.Players, bet.player) {
 			pot.Players = append(pot.Players, bet.player)
 		}
 		pot.Size += bet.value
@@ -187,19 +304,58 @@ func contains(haystack []guid, needle guid) bool {
 func NewGameController() (gc *GameController) {
 	gc = new(GameController)
 	gc.Games = make(map[guid]*Game)
+	gc.Tournaments = make(map[guid]*MultiTable)
 	return gc
 }
 
 type controller struct {
-	toGame  chan Act
-	public  *PublicGame
-	waiting []*Player
+	// acts is where the act of the player whose turn it is goes. Each turn
+	// has its own, and it is nil while no one's act is awaited.
+	acts      chan Act
+	public    *PublicGame
+	waiting   []*Player
+	listeners map[chan struct{}]bool
+	sockets   map[guid]chan *ActError
+	hands     []*HandHistory
+	log       *eventLog
+	replay    *replayer
+	ledger    *Ledger
+	// seats are the players with a seat at the table, true for those
+	// sitting out. The game keeps them up to date as its tables change, so
+	// requests check them instead of the tables.
+	seats map[guid]bool
+	// entriesClosed is set once a tournament has started.
+	entriesClosed bool
+	// straddlers are the players who want to straddle whenever they can.
+	straddlers map[guid]bool
+	// runItTwice are the players who agree to run the board twice when
+	// they are all in.
+	runItTwice map[guid]bool
+	// muckers are the players who muck hands that can't win at showdown.
+	muckers map[guid]bool
+	// leavers are the players who will leave once the current hand is over.
+	leavers map[guid]bool
+	// sitOuts are the players who will sit out from the next hand, if true,
+	// or sit back in, if false.
+	sitOuts map[guid]bool
+	// preActions are the pre-actions players have queued, and facing is
+	// what each player had to call when they were last checked.
+	preActions map[guid]PreAction
+	facing     map[guid]money
+	// banks are what is left of players' time banks.
+	banks map[guid]time.Duration
 	sync.Mutex
 }
 
 type Playerhand struct {
 	PlayerID guid
 	Hand     Hand
+	// Low is the player's eight-or-better low, in a hi-lo game.
+	Low Hand `json:",omitempty"`
+	// Run is 1 for a hand on the second board of a hand run twice.
+	Run int `json:",omitempty"`
+	// Mucked is set if the player mucked their hand instead of showing it.
+	Mucked bool `json:",omitempty"`
 }
 
 func (c *controller) recordWinners(winners []*Player) {
@@ -207,10 +363,157 @@ func (c *controller) recordWinners(winners []*Player) {
 	for _, player := range winners {
 		playerhands = append(playerhands, Playerhand{PlayerID: player.guid, Hand: player.bestHand})
 	}
+	c.Lock()
 	c.public.LastHandWinners = playerhands
+	c.Unlock()
+	c.broadcast()
+}
+
+// recordLowWinners adds the winners of the low half of a hi-lo pot to the
+// last hand's winners.
+func (c *controller) recordLowWinners(winners []*Player) {
+	c.Lock()
+	for _, player := range winners {
+		c.public.LastHandWinners = append(c.public.LastHandWinners, Playerhand{PlayerID: player.guid, Low: player.bestLow})
+	}
+	c.Unlock()
+	c.broadcast()
+}
+
+// recordHand keeps the history of a finished hand, forgetting the oldest
+// once HISTORY_LENGTH hands are kept.
+func (c *controller) recordHand(h *HandHistory) {
+	c.Lock()
+	defer c.Unlock()
+	c.hands = append(c.hands, h)
+	if len(c.hands) > HISTORY_LENGTH {
+		c.hands = c.hands[len(c.hands)-HISTORY_LENGTH:]
+	}
+	c.public.LastShowdown = shownHands(h)
+}
+
+// shownHands returns the hands shown at the end of h.
+func shownHands(h *HandHistory) []ShownHand {
+	shown := make([]ShownHand, 0)
+	for _, ph := range h.Showdown {
+		if ph.Run > 0 {
+			continue
+		}
+		if ph.Mucked {
+			shown = append(shown, ShownHand{PlayerID: ph.PlayerID, Mucked: true})
+		} else {
+			shown = append(shown, ShownHand{PlayerID: ph.PlayerID, Hole: h.Hole[ph.PlayerID], Hand: ph.Hand, Low: ph.Low})
+		}
+	}
+	for _, player := range h.Shown {
+		shown = append(shown, ShownHand{PlayerID: player, Hole: h.Hole[player]})
+	}
+	return shown
+}
+
+// show shows player's hand from the last hand, if they won it without a
+// showdown.
+func (c *controller) show(player guid) error {
+	c.Lock()
+	if len(c.hands) == 0 {
+		c.Unlock()
+		return fmt.Errorf("controller: no hand has been played yet")
+	}
+	last := len(c.hands) - 1
+	h := c.hands[last]
+	if _, won := h.winnings()[player]; !won || len(h.Showdown) > 0 {
+		c.Unlock()
+		return fmt.Errorf("controller: player %v didn't win the last hand without a showdown", player)
+	}
+	if !h.shown(player) {
+		c.hands[last] = h.withShown(player)
+		c.public.LastShowdown = shownHands(c.hands[last])
+		c.record(LogEvent{Hand: h.Number, Type: "show", Player: player})
+	}
+	c.Unlock()
+	c.broadcast()
+	return nil
+}
+
+// replayShows shows the hands shown in shows, in the hands this game
+// remembers.
+func (c *controller) replayShows(shows []LogEvent) {
+	c.Lock()
+	defer c.Unlock()
+	for _, e := range shows {
+		for i, h := range c.hands {
+			if h.Number == e.Hand && !h.shown(e.Player) {
+				c.hands[i] = h.withShown(e.Player)
+			}
+		}
+	}
+}
+
+// handHistories returns the histories of the hands this game remembers,
+// oldest first.
+func (c *controller) handHistories() []*HandHistory {
+	c.Lock()
+	defer c.Unlock()
+	hands := make([]*HandHistory, len(c.hands))
+	copy(hands, c.hands)
+	return hands
+}
+
+// dealt publishes the state of a freshly dealt hand.
+func (c *controller) dealt(g *Game) {
+	c.refresh(g)
+	c.broadcast()
+}
+
+// publish rebuilds the public view of the game and tells every listener.
+func (c *controller) publish(g *Game) {
+	c.refresh(g)
+	c.broadcast()
+}
+
+// refresh rebuilds the public view of the game, keeping the winners of the last hand.
+func (c *controller) refresh(g *Game) {
+	pg := MakePublicGame(g)
+	c.Lock()
+	defer c.Unlock()
+	pg.LastHandWinners = c.public.LastHandWinners
+	pg.LastShowdown = c.public.LastShowdown
+	c.public = pg
+}
+
+// listen returns a channel that receives a value whenever the public view
+// of the game changes. Notifications are coalesced, so a slow listener
+// only ever sees the latest state.
+func (c *controller) listen() chan struct{} {
+	c.Lock()
+	defer c.Unlock()
+	ch := make(chan struct{}, 1)
+	c.listeners[ch] = true
+	return ch
+}
+
+func (c *controller) unlisten(ch chan struct{}) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.listeners, ch)
+}
+
+// broadcast notifies every listener that the public view has changed.
+func (c *controller) broadcast() {
+	c.Lock()
+	defer c.Unlock()
+	for ch := range c.listeners {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
 }
 
 func (c *controller) getNewPlayers(g *Game, openSeats int) (players []*Player) {
+	if c.replaying() {
+		return c.replay.joins(g.hands + 1)
+	}
 	c.Lock()
 	defer c.Unlock()
 	x := openSeats
@@ -220,22 +523,475 @@ func (c *controller) getNewPlayers(g *Game, openSeats int) (players []*Player) {
 	if len(c.waiting) < openSeats {
 		x = len(c.waiting)
 	}
-	players = c.waiting[:x]
+	waiting := c.waiting[:x]
 	c.waiting = c.waiting[x:len(c.waiting)]
+	for _, p := range waiting {
+		err := c.buyIn(g, p)
+		if err != nil {
+			// funds are checked on joining, but the bankroll may have been
+			// spent since
+			log.Printf("Player %v could not buy in to game %v: %v\n", p.guid, g.gameID, err)
+			c.refuseSeat(p.guid, err)
+			continue
+		}
+		c.record(LogEvent{Hand: g.hands + 1, Type: "join", Player: p.guid, Stack: p.wealth})
+		players = append(players, p)
+	}
 	return players
 }
 
+// banked returns true if money moves through the ledger as g is played:
+// if the server keeps bankrolls, g is being played live, and it isn't a
+// table of a multi-table tournament, whose buy-ins and prizes the
+// tournament looks after.
+func (c *controller) banked(g *Game) bool {
+	return c.ledger != nil && !c.replaying() && (g.tournament == nil || g.tournament.multiTable == "")
+}
+
+// buyIn pays for p's stack from their bankroll as they take their seat.
+// In a tournament, buy-ins make up the prize pool.
+func (c *controller) buyIn(g *Game, p *Player) error {
+	if !c.banked(g) {
+		return nil
+	}
+	memo := "buy-in"
+	if g.tournament != nil {
+		memo = "tournament buy-in"
+	}
+	return c.ledger.buyIn(p.guid, gameAccount(g.gameID), p.wealth, memo)
+}
+
+// cashOut returns the stack of p, leaving a cash game, to their bankroll.
+func (c *controller) cashOut(g *Game, p *Player) {
+	if !c.banked(g) || g.tournament != nil {
+		return
+	}
+	err := c.ledger.cashOut(gameAccount(g.gameID), p.guid, p.wealth, "cash out")
+	if err != nil {
+		log.Printf("Could not cash out player %v from game %v: %v\n", p.guid, g.gameID, err)
+	}
+}
+
+// payPrize pays the prize for a tournament standing out of the prize
+// pool. First place, paid last, takes whatever is left of it.
+func (c *controller) payPrize(g *Game, s Standing) {
+	if !c.banked(g) || s.Payout == 0 {
+		return
+	}
+	account := gameAccount(g.gameID)
+	prize := money(g.tournament.entrants) * g.rules.BuyIn * money(s.Payout) / 100
+	if s.Place == 1 {
+		prize = c.ledger.balance(account)
+	}
+	err := c.ledger.cashOut(account, s.Player, prize, fmt.Sprintf("tournament prize for place %d", s.Place))
+	if err != nil {
+		log.Printf("Could not pay player %v their prize from game %v: %v\n", s.Player, g.gameID, err)
+	}
+}
+
+// transferPlayer queues p to join the table, as it is moved from another
+// table of a multi-table tournament.
+func (c *controller) transferPlayer(p *Player) {
+	c.Lock()
+	defer c.Unlock()
+	c.waiting = append(c.waiting, p)
+}
+
+// takeWaiting empties the queue of players waiting to be seated, and
+// returns them.
+func (c *controller) takeWaiting() []*Player {
+	c.Lock()
+	defer c.Unlock()
+	waiting := c.waiting
+	c.waiting = make([]*Player, 0)
+	return waiting
+}
+
+// moveOut takes p from the table, to be seated at another table of a
+// multi-table tournament.
+func (c *controller) moveOut(g *Game, p *Player) {
+	c.record(LogEvent{Hand: g.hands, Type: "leave", Player: p.guid})
+	c.removePlayerFromGame(g, p.guid)
+}
+
+// replayMoves takes from the table the players the log moved out after
+// the current hand.
+func (c *controller) replayMoves(g *Game) {
+	for _, player := range c.replay.leaves(g.hands) {
+		c.removePlayerFromGame(g, player)
+	}
+}
+
+// setStraddle records whether player wants to straddle whenever they are
+// after the big blind.
+func (c *controller) setStraddle(player guid, straddle bool) {
+	c.Lock()
+	defer c.Unlock()
+	if straddle {
+		c.straddlers[player] = true
+	} else {
+		delete(c.straddlers, player)
+	}
+}
+
+// straddles returns true if player straddles the current hand. Straddles
+// are logged, since players change their minds between hands.
+func (c *controller) straddles(g *Game, player guid) bool {
+	if c.replaying() {
+		return c.replay.straddle(g.hands, player)
+	}
+	c.Lock()
+	straddle := c.straddlers[player]
+	c.Unlock()
+	if straddle {
+		c.record(LogEvent{Hand: g.hands, Type: "straddle", Player: player})
+	}
+	return straddle
+}
+
+// setRunItTwice records whether player agrees to run the board twice
+// when they are all in.
+func (c *controller) setRunItTwice(player guid, runItTwice bool) {
+	c.Lock()
+	defer c.Unlock()
+	if runItTwice {
+		c.runItTwice[player] = true
+	} else {
+		delete(c.runItTwice, player)
+	}
+}
+
+// runsItTwice returns true if player agrees to run the board of the
+// current hand twice. Like straddles, agreements are logged.
+func (c *controller) runsItTwice(g *Game, player guid) bool {
+	if c.replaying() {
+		return c.replay.runItTwice(g.hands, player)
+	}
+	c.Lock()
+	agreed := c.runItTwice[player]
+	c.Unlock()
+	if agreed {
+		c.record(LogEvent{Hand: g.hands, Type: "run_it_twice", Player: player})
+	}
+	return agreed
+}
+
+// setMuck records whether player mucks hands that can't win at showdown.
+func (c *controller) setMuck(player guid, muck bool) {
+	c.Lock()
+	defer c.Unlock()
+	if muck {
+		c.muckers[player] = true
+	} else {
+		delete(c.muckers, player)
+	}
+}
+
+// mucks returns true if player mucks their losing hand at the showdown of
+// the current hand. Like straddles, mucks are logged.
+func (c *controller) mucks(g *Game, player guid) bool {
+	if c.replaying() {
+		return c.replay.muck(g.hands, player)
+	}
+	c.Lock()
+	muck := c.muckers[player]
+	c.Unlock()
+	if muck {
+		c.record(LogEvent{Hand: g.hands, Type: "muck", Player: player})
+	}
+	return muck
+}
+
+// timeBank returns how much of player's time bank they have left. Every
+// player's bank starts full.
+func (c *controller) timeBank(g *Game, player guid) time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	bank, ok := c.banks[player]
+	if !ok {
+		return g.rules.timeBank()
+	}
+	return bank
+}
+
+// refillTimeBanks tops up the time bank of every player at the table after
+// a hand, up to a full bank.
+func (c *controller) refillTimeBanks(g *Game) {
+	for _, p := range g.table {
+		bank := c.timeBank(g, p.guid) + g.rules.timeBankRefill()
+		if bank > g.rules.timeBank() {
+			bank = g.rules.timeBank()
+		}
+		c.Lock()
+		c.banks[p.guid] = bank
+		c.Unlock()
+	}
+}
+
+// timedOut sits out a player in a cash game who ran out of time, from the
+// next hand on. Tournament players keep playing, checking or folding
+// whenever they run out of time.
+func (c *controller) timedOut(g *Game, player guid) {
+	if g.tournament != nil || c.replaying() {
+		return
+	}
+	c.setSitOut(player, true)
+}
+
+// setPreAction queues p to be taken for player when their turn comes. It
+// can't be queued while it is already their turn.
+func (c *controller) setPreAction(player guid, p PreAction) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.public.Turn.Player == player {
+		return fmt.Errorf("controller: it is already player %v's turn", player)
+	}
+	c.preActions[player] = p
+	return nil
+}
+
+// clearPreAction drops any pre-action player has queued.
+func (c *controller) clearPreAction(player guid) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.preActions, player)
+}
+
+// clearPreActions drops every queued pre-action, as the hand they were
+// queued for is over.
+func (c *controller) clearPreActions() {
+	c.Lock()
+	defer c.Unlock()
+	c.preActions = make(map[guid]PreAction)
+	c.facing = make(map[guid]money)
+}
+
+// dropChangedPreActions drops the pre-action of every player who has a
+// different amount to call than when they were last checked, since the
+// bet facing them has changed. The game checks at the start of each
+// betting round and after every bet.
+func (c *controller) dropChangedPreActions(g *Game) {
+	c.Lock()
+	defer c.Unlock()
+	for _, p := range g.table {
+		toCall := g.pot.totalToCall - g.pot.totalPlayerBetThisRound(p.guid)
+		if last, ok := c.facing[p.guid]; ok && last != toCall {
+			delete(c.preActions, p.guid)
+		}
+		c.facing[p.guid] = toCall
+	}
+}
+
+// preAction returns the pre-action player has queued, or nil.
+func (c *controller) preAction(player guid) *PreAction {
+	c.Lock()
+	defer c.Unlock()
+	p, ok := c.preActions[player]
+	if !ok {
+		return nil
+	}
+	return &p
+}
+
+// publicGame returns a copy of the public view of the game. The turn is
+// copied too, since it changes in place while the game waits on players.
+func (c *controller) publicGame() PublicGame {
+	c.Lock()
+	defer c.Unlock()
+	pg := *c.public
+	turn := *c.public.Turn
+	pg.Turn = &turn
+	return pg
+}
+
+// turn returns whose turn it is, and what they may bet.
+func (c *controller) turn() Turn {
+	c.Lock()
+	defer c.Unlock()
+	return *c.public.Turn
+}
+
+// takePreAction returns the act player's queued pre-action makes now it is
+// their turn, if they queued one and it still applies. The pre-action is
+// dropped either way.
+func (c *controller) takePreAction(g *Game, player guid) (action int, amount money, ok bool) {
+	c.Lock()
+	p, queued := c.preActions[player]
+	delete(c.preActions, player)
+	c.Unlock()
+	players := g.table.getPlayers([]guid{player})
+	if !queued || len(players) == 0 {
+		return 0, 0, false
+	}
+	toCall := g.pot.totalToCall - g.pot.totalPlayerBetThisRound(player)
+	switch p.Action {
+	case "fold":
+		return fold, 0, true
+	case "check_fold":
+		if toCall > 0 {
+			return fold, 0, true
+		}
+	case "call":
+		if toCall > p.Amount {
+			return 0, 0, false
+		}
+	}
+	if toCall > players[0].wealth {
+		toCall = players[0].wealth
+	}
+	return call, toCall, true
+}
+
+// requestLeave has player leave the game. A player still waiting for a
+// seat leaves straight away; a seated player leaves once the current hand
+// is over.
+func (c *controller) requestLeave(player guid) {
+	c.Lock()
+	defer c.Unlock()
+	for i, p := range c.waiting {
+		if p.guid == player {
+			c.waiting = append(c.waiting[:i:i], c.waiting[i+1:]...)
+			return
+		}
+	}
+	c.leavers[player] = true
+	delete(c.sitOuts, player)
+}
+
+// leaving returns true if player has asked to leave.
+func (c *controller) leaving(player guid) bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.leavers[player]
+}
+
+// leaves returns the players leaving after the hand just played. Leaves
+// are logged, like moves to other tables.
+func (c *controller) leaves(g *Game) []guid {
+	if c.replaying() {
+		return c.replay.leaves(g.hands)
+	}
+	c.Lock()
+	defer c.Unlock()
+	players := make([]guid, 0)
+	for player := range c.leavers {
+		if g.table.contains(player) || g.sittingOut.contains(player) {
+			c.record(LogEvent{Hand: g.hands, Type: "leave", Player: player})
+			players = append(players, player)
+		}
+		delete(c.leavers, player)
+	}
+	return players
+}
+
+// setSitOut records whether player sits out from the next hand on, or
+// sits back in.
+func (c *controller) setSitOut(player guid, sitOut bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.sitOuts[player] = sitOut
+}
+
+// sitOutChanges returns the players sitting out from the next hand, and
+// those sitting back in for it. Changes are logged.
+func (c *controller) sitOutChanges(g *Game) (out, in []guid) {
+	if c.replaying() {
+		return c.replay.sits(g.hands+1, "sit_out"), c.replay.sits(g.hands+1, "sit_in")
+	}
+	c.Lock()
+	defer c.Unlock()
+	out, in = make([]guid, 0), make([]guid, 0)
+	for player, sitOut := range c.sitOuts {
+		if sitOut && g.table.contains(player) {
+			out = append(out, player)
+		} else if !sitOut && g.sittingOut.contains(player) {
+			in = append(in, player)
+		}
+		delete(c.sitOuts, player)
+	}
+	for _, player := range out {
+		c.record(LogEvent{Hand: g.hands + 1, Type: "sit_out", Player: player})
+	}
+	for _, player := range in {
+		c.record(LogEvent{Hand: g.hands + 1, Type: "sit_in", Player: player})
+	}
+	return out, in
+}
+
+// closeEntries stops any more players joining a tournament.
+func (c *controller) closeEntries() {
+	c.Lock()
+	defer c.Unlock()
+	c.entriesClosed = true
+}
+
+// blindLevel returns the tournament level the current hand is played at:
+// computed, or when replaying, the level the log recorded. Changes of
+// level are logged, since levels that go by the clock can't be worked out
+// again later.
+func (c *controller) blindLevel(g *Game, current, computed int) int {
+	if c.replaying() {
+		return c.replay.level(g.hands, current)
+	}
+	if computed != current {
+		c.record(LogEvent{Hand: g.hands, Type: "level", Level: computed})
+	}
+	return computed
+}
+
+// replaying returns true while the game is being played back from its
+// log. A game resumed from its log goes live, and shows the hands shown
+// in it, once the log runs out.
+func (c *controller) replaying() bool {
+	if c.replay == nil {
+		return false
+	}
+	if !c.replay.resume || len(c.replay.events) > 0 {
+		return true
+	}
+	r := c.replay
+	c.replay = nil
+	c.replayShows(r.shows)
+	r.finish(nil)
+	return false
+}
+
+// halted returns true if the game is being replayed and the replay has
+// stopped, in which case the game must stop too.
+func (c *controller) halted() bool {
+	return c.replay != nil && c.replay.halted
+}
+
+// record appends e to the game's log, if it is being logged.
+func (c *controller) record(e LogEvent) {
+	if c.log == nil {
+		return
+	}
+	_, err := c.log.record(e)
+	if err != nil {
+		log.Printf("Could not log %v event for player %v: %v\n", e.Type, e.Player, err)
+	}
+}
+
 func (c *controller) enqueuePlayer(g *Game, p *Player) error {
 	c.Lock()
 	defer c.Unlock()
 	for _, player := range c.waiting {
 		if player.guid == p.guid {
-			return fmt.Errorf("controller: player %v is already queued to join table")
+			return fmt.Errorf("controller: player %v is already queued to join table", p.guid)
 		}
 	}
-	for _, player := range g.table {
-		if player.guid == p.guid {
-			return fmt.Errorf("controller: player %v is already sitting at the table", p.guid)
+	if g.tournament != nil && (c.entriesClosed || len(c.waiting)+len(c.seats) >= g.rules.MaxSeats) {
+		return errTournamentFull
+	}
+	if _, ok := c.seats[p.guid]; ok {
+		return fmt.Errorf("controller: player %v is already sitting at the table", p.guid)
+	}
+	// the buy-in is only taken once the player is seated
+	if c.ledger != nil {
+		err := c.ledger.canCover(p.guid, p.wealth)
+		if err != nil {
+			return err
 		}
 	}
 	c.waiting = append(c.waiting, p)
@@ -248,57 +1004,242 @@ type Act struct {
 	BetAmount money
 }
 
+// ActError explains why a player's act was refused. Code is meant for
+// programs; Message is meant for people.
+type ActError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	notYourTurn = "not_your_turn"
+	invalidBet  = "invalid_bet"
+	badRequest  = "bad_request"
+	buyInFailed = "buy_in_failed"
+)
+
+func (e *ActError) Error() string {
+	return e.Message
+}
+
 func (c *controller) getPlayerBet(g *Game, wanted guid) (int, money, error) {
-	pg := MakePublicGame(g)
-	pg.LastHandWinners = c.public.LastHandWinners
-	c.public = pg
-	c.public.Turn.Player = wanted
+	c.refresh(g)
+	if !c.replaying() {
+		if action, amount, ok := c.takePreAction(g, wanted); ok {
+			c.record(LogEvent{Hand: g.hands, Type: "act", Player: wanted, Action: action, BetAmount: amount})
+			return action, amount, nil
+		}
+	}
+	clock, bank := g.rules.timeout(), c.timeBank(g, wanted)
+	acts := make(chan Act, 1)
+	c.Lock()
 	c.public.Turn.PlayerBet = g.pot.totalPlayerBetThisRound(wanted)
-	c.public.Turn.Expiry = time.Now().Add(TIMEOUT * time.Second).String()
-	timeout := time.After(TIMEOUT * time.Second)
+	if p := g.table.getPlayers([]guid{wanted}); len(p) > 0 {
+		c.public.Turn.MinRaise, _ = g.betting().raiseLimits(g, p[0])
+		c.public.Turn.MinBet, c.public.Turn.MaxBet = g.betLimits(p[0])
+	}
+	c.public.Turn.Expiry = time.Now().Add(clock).String()
+	c.public.Turn.TimeBank = int(bank / time.Second)
+	c.public.Turn.Player = wanted
+	if !c.replaying() {
+		c.acts = acts
+	}
+	c.Unlock()
+	c.broadcast()
+	if c.replaying() {
+		return c.replay.act(g.hands, wanted)
+	}
+	asked := time.Now()
+	timeout := time.NewTimer(clock)
+	defer timeout.Stop()
+	banking := false
 	for {
 		select {
-		case <-timeout:
+		case <-timeout.C:
+			c.Lock()
+			if c.acts != acts {
+				// the act came in as time ran out, and is waiting
+				c.Unlock()
+				continue
+			}
+			if !banking && bank > 0 {
+				// the clock has run out, so the time bank starts running
+				banking = true
+				timeout.Reset(bank)
+				c.public.Turn.Expiry = time.Now().Add(bank).String()
+				c.public.Turn.TimeBank = 0
+				c.Unlock()
+				c.broadcast()
+				continue
+			}
+			c.acts = nil
+			c.banks[wanted] = 0
+			c.public.Turn.Player = ""
+			c.Unlock()
+			c.record(LogEvent{Hand: g.hands, Type: "timeout", Player: wanted})
 			return 0, 0, fmt.Errorf("controller: timed out waiting for bet from player %v", wanted)
-		case a := <-c.toGame:
+		case a := <-acts:
+			if banking {
+				c.Lock()
+				c.banks[wanted] = bank - (time.Since(asked) - clock)
+				if c.banks[wanted] < 0 {
+					c.banks[wanted] = 0
+				}
+				c.Unlock()
+			}
+			c.record(LogEvent{Hand: g.hands, Type: "act", Player: wanted, Action: a.Action, BetAmount: a.BetAmount})
 			return a.Action, a.BetAmount, nil
 		}
 	}
-
-	panic("Unreachable")
 }
 
 func (c *controller) registerPlayerAct(a Act) error {
-	if a.Player != c.public.Turn.Player {
-		return fmt.Errorf("controller: not this player's turn: %v", a.Player)
+	c.Lock()
+	defer c.Unlock()
+	if c.acts == nil || a.Player != c.public.Turn.Player {
+		return &ActError{Code: notYourTurn, Message: fmt.Sprintf("controller: not this player's turn: %v", a.Player)}
 	}
-	c.toGame <- a
+	// the act is handed to the turn it was made on, which ends with it, so
+	// an act sent twice isn't taken as the next player's. Each turn's
+	// channel has room for its one act, so this never blocks.
+	c.acts <- a
+	c.acts = nil
+	c.public.Turn.Player = ""
 	return nil
 }
 
-func NewPlayer(id guid) (p *Player) {
+// NewPlayer is a constructor for a Player who sits down with buyIn chips.
+func NewPlayer(id guid, buyIn money) (p *Player) {
 	p = new(Player)
 	p.guid = id
-	p.wealth = BUY_IN
+	p.wealth = buyIn
 	return p
 }
 
 func NewController(g *Game) *controller {
 	c := new(controller)
-	c.toGame = make(chan Act)
 	c.public = MakePublicGame(g)
 	c.waiting = make([]*Player, 0)
+	c.seats = make(map[guid]bool)
+	c.listeners = make(map[chan struct{}]bool)
+	c.sockets = make(map[guid]chan *ActError)
+	c.straddlers = make(map[guid]bool)
+	c.runItTwice = make(map[guid]bool)
+	c.muckers = make(map[guid]bool)
+	c.leavers = make(map[guid]bool)
+	c.sitOuts = make(map[guid]bool)
+	c.banks = make(map[guid]time.Duration)
+	c.preActions = make(map[guid]PreAction)
+	c.facing = make(map[guid]money)
+	c.hands = make([]*HandHistory, 0)
 	return c
 }
 
-func (c *controller) registerInvalidBet(g *Game, player guid, bet money) { return }
-func (c *controller) removePlayerFromGame(g *Game, player guid) {
-	replacementPlayers := make([]*Player, 0)
-	for i := 0; i < len(g.table); i++ {
-		if g.table[i].guid != player {
-			replacementPlayers = append(replacementPlayers, g.table[i])
+// registerInvalidBet tells a player connected by socket that their bet was
+// refused. Players without a socket learn of it from the game state.
+func (c *controller) registerInvalidBet(g *Game, player guid, bet money) {
+	c.Lock()
+	defer c.Unlock()
+	rejections, ok := c.sockets[player]
+	if !ok {
+		return
+	}
+	select {
+	case rejections <- &ActError{Code: invalidBet, Message: fmt.Sprintf("controller: invalid bet of %v from player %v; player has been folded", bet, player)}:
+	default:
+	}
+}
+
+// refuseSeat tells a player connected by socket that they couldn't buy in
+// to take their seat. It must be called with c locked.
+func (c *controller) refuseSeat(player guid, err error) {
+	rejections, ok := c.sockets[player]
+	if !ok {
+		return
+	}
+	select {
+	case rejections <- &ActError{Code: buyInFailed, Message: fmt.Sprintf("controller: player %v could not buy in, and has not been seated: %v", player, err)}:
+	default:
+	}
+}
+
+// connectSocket registers the socket for a player's seat. A seat may
+// only have one socket at a time.
+func (c *controller) connectSocket(player guid) (chan *ActError, error) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.sockets[player]; ok {
+		return nil, fmt.Errorf("controller: player %v already has a socket connected", player)
+	}
+	rejections := make(chan *ActError, 1)
+	c.sockets[player] = rejections
+	return rejections, nil
+}
+
+func (c *controller) disconnectSocket(player guid) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.sockets, player)
+}
+
+// isWaiting returns true if the player is queued to join the table.
+func (c *controller) isWaiting(player guid) bool {
+	c.Lock()
+	defer c.Unlock()
+	for _, p := range c.waiting {
+		if p.guid == player {
+			return true
 		}
 	}
-	g.table = replacementPlayers
-	return
+	return false
+}
+
+// isSeated returns true if the player is playing at the table.
+func (c *controller) isSeated(player guid) bool {
+	c.Lock()
+	defer c.Unlock()
+	sittingOut, ok := c.seats[player]
+	return ok && !sittingOut
+}
+
+// hasSeat returns true if the player is playing at the table or sitting
+// out from it.
+func (c *controller) hasSeat(player guid) bool {
+	c.Lock()
+	defer c.Unlock()
+	_, ok := c.seats[player]
+	return ok
+}
+
+// hasJoined returns true if the player has a seat at the table, whether
+// playing or sitting out, or is queued for one.
+func (c *controller) hasJoined(player guid) bool {
+	return c.hasSeat(player) || c.isWaiting(player)
+}
+
+// updateSeats records who has a seat at g, and who of them is sitting out.
+// The game calls it whenever it changes its tables.
+func (c *controller) updateSeats(g *Game) {
+	seats := make(map[guid]bool)
+	for _, p := range g.table {
+		seats[p.guid] = false
+	}
+	for _, p := range g.sittingOut {
+		seats[p.guid] = true
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.seats = seats
+}
+
+// removePlayerFromGame takes player from their seat, whether they are
+// playing or sitting out, and returns their chips.
+func (c *controller) removePlayerFromGame(g *Game, player guid) {
+	ids := []guid{player}
+	for _, p := range append(g.table.getPlayers(ids), g.sittingOut.getPlayers(ids)...) {
+		c.cashOut(g, p)
+	}
+	g.table = g.table.without(player)
+	g.sittingOut = g.sittingOut.without(player)
+	c.updateSeats(g)
 }
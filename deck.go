@@ -2,29 +2,22 @@ package main
 
 import (
 	"fmt"
-	"sort"
 )
 
-type Deck map[string]string
-
-//generateAllHands returns all possible five-card hands from the
-//five table cards and two hole cards.
-func generateAllHands(deck Deck, playerID guid) []Hand {
-	allCards := make([]string, 0)
-	for card, location := range deck {
-		if location == string(playerID) || location == "FLOP" || location == "TURN" || location == "RIVER" {
-			allCards = append(allCards, card)
-		}
-	}
-	if len(allCards) != 7 {
-		panicMsg := fmt.Sprintf("Should have 7 cards. Have %v", allCards)
+//dealtCards returns the player's hole cards and the board of the given
+//run, which must be as many as the variant v deals.
+func dealtCards(deck Deck, playerID guid, v variant, run int) (hole, board []Card) {
+	hole = deck.holeCards(playerID)
+	board = deck.runBoard(run)
+	if len(hole) != v.holeCards() || len(board) != v.boardCards() {
+		panicMsg := fmt.Sprintf("Should have %v hole cards and %v on the board. Have %v and %v", v.holeCards(), v.boardCards(), hole, board)
 		panic(panicMsg)
 	}
-	return nChooseK(allCards, 5)
+	return hole, board
 }
 
-//nChooseK returns all k-length combinations of a slice of strings.
-func nChooseK(allCards []string, k int) []Hand {
+//nChooseK returns all k-length combinations of a slice of cards.
+func nChooseK(allCards []Card, k int) []Hand {
 	allHands := make([]Hand, 0)
 	if k == 0 {
 		return make([]Hand, 1)
@@ -40,22 +33,3 @@ func nChooseK(allCards []string, k int) []Hand {
 
 	return allHands
 }
-
-func (d Deck) String() string {
-	ordered := make([]string, 0)
-	for card, location := range d {
-		if len(location) > 5 {
-			location = string(location[:5])
-		}
-		ordered = append(ordered, location+":"+card)
-	}
-	sort.Strings(ordered)
-	s := "map[\n"
-	for _, card := range ordered {
-		s += "  "
-		s += card
-		s += "\n"
-	}
-	s += "]"
-	return s
-}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// EXHAUSTIVE_BOARDS is the most boards CalculateEquity will deal out every
+// one of. With more to deal, it samples MONTE_CARLO_BOARDS of them instead.
+const (
+	EXHAUSTIVE_BOARDS  = 100000
+	MONTE_CARLO_BOARDS = 20000
+)
+
+// ALL_IN_BOARDS is the most boards dealt to show equities in an all-in
+// hand, every one of them if there are no more, since the hand waits for
+// them: that is every board from the flop on, and a sample before it.
+const ALL_IN_BOARDS = 1000
+
+// equityBudget is how many boards an equity calculation deals: every one
+// that could come if there are no more than exhaustive, and otherwise
+// sampled of them at random.
+type equityBudget struct {
+	exhaustive, sampled int
+}
+
+var (
+	requestBudget = equityBudget{exhaustive: EXHAUSTIVE_BOARDS, sampled: MONTE_CARLO_BOARDS}
+	allInBudget   = equityBudget{exhaustive: ALL_IN_BOARDS, sampled: ALL_IN_BOARDS}
+)
+
+// EquityRequest asks for the equity of each player's hole cards against
+// the others', with the board dealt so far.
+type EquityRequest struct {
+	Variant string   `json:"variant"`
+	Players [][]Card `json:"players"`
+	Board   []Card   `json:"board"`
+}
+
+// Equity is a player's chances with the rest of the board to come. Win is
+// the share of boards they win the whole pot on, and Tie the share they
+// win part of it on, whether by tying or, in hi-lo games, by winning one
+// half. Equity is the share of the pot they win on average.
+type Equity struct {
+	Win    float64 `json:"win"`
+	Tie    float64 `json:"tie"`
+	Equity float64 `json:"equity"`
+}
+
+// EquityResult is each player's Equity, in the order they were asked for.
+// Exhaustive is true if every possible board was dealt, and false if
+// Boards of them were sampled at random.
+type EquityResult struct {
+	Variant    string   `json:"variant"`
+	Players    []Equity `json:"players"`
+	Exhaustive bool     `json:"exhaustive"`
+	Boards     int      `json:"boards"`
+}
+
+// CalculateEquity returns each player's equity, dealing every board that
+// could come if there are few enough, and otherwise sampling boards with
+// random.
+func CalculateEquity(req EquityRequest, random *rand.Rand) (EquityResult, error) {
+	return calculateEquity(req, random, requestBudget)
+}
+
+// calculateEquity is CalculateEquity dealing the boards budget allows.
+func calculateEquity(req EquityRequest, random *rand.Rand, budget equityBudget) (EquityResult, error) {
+	if req.Variant == "" {
+		req.Variant = holdem
+	}
+	v, ok := variants[req.Variant]
+	if !ok || v.stud() {
+		return EquityResult{}, errors.New("Variant must be one played with a board: holdem, omaha, omaha8 or shortdeck.")
+	}
+	if len(req.Players) < 2 || len(req.Players) > v.maxSeats() {
+		return EquityResult{}, fmt.Errorf("Equity needs between 2 and %d players.", v.maxSeats())
+	}
+	switch len(req.Board) {
+	case 0, 3, 4, 5:
+	default:
+		return EquityResult{}, errors.New("The board must have 0, 3, 4 or 5 cards.")
+	}
+	for _, hole := range req.Players {
+		if len(hole) != v.holeCards() {
+			return EquityResult{}, fmt.Errorf("Each player must have %d hole cards.", v.holeCards())
+		}
+	}
+	undealt := make(map[Card]bool)
+	for _, card := range v.deck() {
+		undealt[card] = true
+	}
+	for _, cards := range append(append([][]Card{}, req.Players...), req.Board) {
+		for _, card := range cards {
+			if !undealt[card] {
+				return EquityResult{}, fmt.Errorf("%v is dealt twice, or isn't in the deck.", card)
+			}
+			undealt[card] = false
+		}
+	}
+	// the stub is the cards left to deal the rest of the board from
+	stub := make([]Card, 0)
+	for _, card := range v.deck() {
+		if undealt[card] {
+			stub = append(stub, card)
+		}
+	}
+
+	e := equityCalculation{v: v, req: req, shares: make([]float64, len(req.Players)),
+		wins: make([]int, len(req.Players)), ties: make([]int, len(req.Players))}
+	toCome := v.boardCards() - len(req.Board)
+	result := EquityResult{Variant: req.Variant, Boards: combinations(len(stub), toCome)}
+	if result.Boards <= budget.exhaustive {
+		result.Exhaustive = true
+		for _, rest := range nChooseK(stub, toCome) {
+			e.score(rest)
+		}
+	} else {
+		result.Boards = budget.sampled
+		for i := 0; i < budget.sampled; i++ {
+			// shuffle just the cards to come to the front of the stub
+			for j := 0; j < toCome; j++ {
+				k := j + random.Intn(len(stub)-j)
+				stub[j], stub[k] = stub[k], stub[j]
+			}
+			e.score(stub[:toCome])
+		}
+	}
+	for i := range req.Players {
+		result.Players = append(result.Players, Equity{
+			Win:    float64(e.wins[i]) / float64(result.Boards),
+			Tie:    float64(e.ties[i]) / float64(result.Boards),
+			Equity: e.shares[i] / float64(result.Boards),
+		})
+	}
+	return result, nil
+}
+
+// equityCalculation adds up how each player does on the boards dealt.
+type equityCalculation struct {
+	v      variant
+	req    EquityRequest
+	shares []float64
+	wins   []int
+	ties   []int
+}
+
+// score deals the rest of the board and adds each player's share of the
+// pot on it.
+func (e *equityCalculation) score(rest []Card) {
+	board := append(append(make([]Card, 0, e.v.boardCards()), e.req.Board...), rest...)
+	highs := make([]int, 0)
+	var best handValue
+	lows := make([]int, 0)
+	var bestLow []int
+	for i, hole := range e.req.Players {
+		value := e.v.value(hole, board)
+		switch {
+		case value > best:
+			best, highs = value, []int{i}
+		case value == best:
+			highs = append(highs, i)
+		}
+		if !e.v.hiLo() {
+			continue
+		}
+		if low := findLowHands(e.v.hands(hole, board)); len(low) > 0 {
+			ranks, _ := low[0].lowRank()
+			switch {
+			case bestLow == nil || less(ranks, bestLow):
+				bestLow, lows = ranks, []int{i}
+			case isEq(ranks, bestLow):
+				lows = append(lows, i)
+			}
+		}
+	}
+	share := make([]float64, len(e.req.Players))
+	if len(lows) == 0 {
+		for _, i := range highs {
+			share[i] += 1 / float64(len(highs))
+		}
+	} else {
+		for _, i := range highs {
+			share[i] += 0.5 / float64(len(highs))
+		}
+		for _, i := range lows {
+			share[i] += 0.5 / float64(len(lows))
+		}
+	}
+	for i, s := range share {
+		e.shares[i] += s
+		switch {
+		case s == 1:
+			e.wins[i]++
+		case s > 0:
+			e.ties[i]++
+		}
+	}
+}
+
+// combinations returns how many ways there are to choose k of n things.
+func combinations(n, k int) int {
+	c := 1
+	for i := 0; i < k; i++ {
+		c = c * (n - i) / (i + 1)
+	}
+	return c
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func equityRequest(variant string, board string, players ...string) EquityRequest {
+	req := EquityRequest{Variant: variant, Board: mustParseCards(board)}
+	for _, hole := range players {
+		req.Players = append(req.Players, mustParseCards(hole))
+	}
+	return req
+}
+
+func TestCalculateEquity(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        EquityRequest
+		exhaustive bool
+		boards     int
+		equity     []float64
+		tolerance  float64
+	}{
+		{"aces against kings", equityRequest(holdem, "", "AS AH", "KS KH"), false, MONTE_CARLO_BOARDS, []float64{0.82, 0.18}, 0.02},
+		// 9 hearts, 3 tens, 3 aces and 3 kings
+		{"flush and straight draw", equityRequest(holdem, "QH JH 3S 4D", "AH KH", "2C 2D"), true, 44, []float64{18.0 / 44, 26.0 / 44}, 1e-9},
+		{"board plays", equityRequest(holdem, "AS KS QS JS TS", "2C 3D", "4C 5D", "6C 7D"), true, 1, []float64{1.0 / 3, 1.0 / 3, 1.0 / 3}, 1e-9},
+		// the low is split, and the nut flush takes the high
+		{"hi-lo", equityRequest(omahaHiLo, "2H 3H 8H KC QD", "AH 4H 9C 9D", "AC 4D JS TS"), true, 1, []float64{0.75, 0.25}, 1e-9},
+		{"short deck", equityRequest(shortDeck, "AS 6D 7C", "8H 9H", "AC AD"), true, combinations(36-7, 2), nil, 0},
+	}
+	for _, tt := range tests {
+		result, err := CalculateEquity(tt.req, rand.New(rand.NewSource(1)))
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		if result.Exhaustive != tt.exhaustive || result.Boards != tt.boards {
+			t.Errorf("%v: got exhaustive %v over %v boards, expected %v over %v", tt.name, result.Exhaustive, result.Boards, tt.exhaustive, tt.boards)
+		}
+		total := 0.0
+		for i, e := range result.Players {
+			total += e.Equity
+			if e.Win+e.Tie > 1+1e-9 || e.Equity > e.Win+e.Tie+1e-9 || e.Equity < e.Win-1e-9 {
+				t.Errorf("%v: player %v has inconsistent %+v", tt.name, i, e)
+			}
+			if tt.equity != nil && math.Abs(e.Equity-tt.equity[i]) > tt.tolerance {
+				t.Errorf("%v: player %v has equity %v, expected %v", tt.name, i, e.Equity, tt.equity[i])
+			}
+		}
+		if math.Abs(total-1) > 1e-9 {
+			t.Errorf("%v: equities add up to %v", tt.name, total)
+		}
+	}
+}
+
+func TestCalculateEquityErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		req  EquityRequest
+	}{
+		{"one player", equityRequest(holdem, "", "AS AH")},
+		{"card dealt twice", equityRequest(holdem, "AS 2C 3C", "AS AH", "KS KH")},
+		{"two card board", equityRequest(holdem, "2C 3C", "AS AH", "KS KH")},
+		{"wrong hole cards", equityRequest(omaha, "", "AS AH", "KS KH")},
+		{"stud", equityRequest(stud, "", "AS AH", "KS KH")},
+		{"card missing from the short deck", equityRequest(shortDeck, "", "AS 2H", "KS KH")},
+	}
+	for _, tt := range tests {
+		if _, err := CalculateEquity(tt.req, rand.New(rand.NewSource(1))); err == nil {
+			t.Errorf("%v: calculated equity, expected an error", tt.name)
+		}
+	}
+}
+
+func TestAllInEquityBudget(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        EquityRequest
+		exhaustive bool
+		boards     int
+	}{
+		{"preflop", equityRequest(holdem, "", "AS AH", "KS KH"), false, ALL_IN_BOARDS},
+		{"preflop omaha", equityRequest(omahaHiLo, "", "AS AH 2C 3C", "KS KH 4D 5D", "QS QH 6D 7D"), false, ALL_IN_BOARDS},
+		{"flop", equityRequest(holdem, "2C 3C 4C", "AS AH", "KS KH", "QS QH"), true, combinations(52-9, 2)},
+		{"turn", equityRequest(holdem, "2C 3C 4C 5D", "AS AH", "KS KH"), true, 52 - 8},
+	}
+	for _, tt := range tests {
+		result, err := calculateEquity(tt.req, rand.New(rand.NewSource(1)), allInBudget)
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		if result.Exhaustive != tt.exhaustive || result.Boards != tt.boards || result.Boards > ALL_IN_BOARDS {
+			t.Errorf("%v: got exhaustive %v over %v boards, expected %v over %v", tt.name, result.Exhaustive, result.Boards, tt.exhaustive, tt.boards)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import "math/bits"
+
+// handValue ranks a hand under a handRanking as a single number: the
+// better of two hands has the greater value, and hands that tie have the
+// same value.
+type handValue uint32
+
+// evalCard is a card encoded for the lookup evaluator, as in Cactus Kev's:
+//
+//	xxxbbbbb bbbbbbbb cdhsrrrr xxpppppp
+//
+// b has a bit set for the card's rank, cdhs a bit for its suit, r is its
+// rank from deuce (0) to ace (12) and p is its rank's prime.
+type evalCard uint32
+
+var rankPrimes = [13]uint32{2, 3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41}
+
+// newEvalCard encodes a card for the lookup evaluator.
+func newEvalCard(card Card) evalCard {
+	r, s := card.rank()-2, card.suit()
+	if r < 0 || r > 12 {
+		panic("can't find card")
+	}
+	return evalCard(1<<(16+uint(r)) | 1<<(12+uint(s)) | uint32(r)<<8 | rankPrimes[r])
+}
+
+// evalCards encodes cards for the lookup evaluator.
+func evalCards(cards []Card) []evalCard {
+	encoded := make([]evalCard, len(cards))
+	for i, card := range cards {
+		encoded[i] = newEvalCard(card)
+	}
+	return encoded
+}
+
+// evalTables are a handRanking's lookup tables. Five cards of five ranks
+// are looked up by their rank bits, in flushes if they share a suit and
+// unique5 if they don't. Any other five cards are looked up by the product
+// of their ranks' primes, which is the same for every hand of those ranks.
+//
+// Five to seven cards hold at most one flush, and nothing that beats one
+// but a straight flush, so if five of them share a suit they are looked up
+// in bestFlushes by that suit's rank bits. Otherwise their value depends
+// only on how many there are of each rank, and they are looked up in
+// ranks[n] by the quinary hash of those counts.
+type evalTables struct {
+	flushes     []handValue
+	unique5     []handValue
+	products    map[uint32]handValue
+	bestFlushes []handValue
+	ranks       [8][]handValue
+}
+
+// rankCounts is how many of each rank, from deuce (0) to ace (12), are
+// among some cards.
+type rankCounts [13]int
+
+// quinaryWays[n][k] is the number of ways k cards can be spread over n
+// ranks with no more than four of any rank.
+var quinaryWays = func() (ways [14][8]int) {
+	ways[0][0] = 1
+	for n := 1; n < len(ways); n++ {
+		for k := range ways[n] {
+			for c := 0; c <= 4 && c <= k; c++ {
+				ways[n][k] += ways[n-1][k-c]
+			}
+		}
+	}
+	return ways
+}()
+
+// hash numbers the counts of k cards' ranks from 0, without gaps, in the
+// order of their counts from deuce up.
+func (counts *rankCounts) hash(k int) (h int) {
+	for r, c := range counts {
+		for j := 0; j < c; j++ {
+			h += quinaryWays[12-r][k-j]
+		}
+		k -= c
+	}
+	return h
+}
+
+// forEachCounts calls f with every way of spreading k cards over the ranks
+// from r up, with no more than four of any rank, given counts below r.
+func forEachCounts(counts *rankCounts, r, k int, f func(*rankCounts)) {
+	if r == len(counts) {
+		if k == 0 {
+			f(counts)
+		}
+		return
+	}
+	for c := 0; c <= 4 && c <= k; c++ {
+		counts[r] = c
+		forEachCounts(counts, r+1, k-c, f)
+	}
+	counts[r] = 0
+}
+
+// newEvalTables builds ranking's lookup tables by ranking, with handRank,
+// one hand of each set of ranks, and one flush of each set of five ranks.
+func newEvalTables(ranking handRanking) *evalTables {
+	t := &evalTables{
+		flushes:  make([]handValue, 1<<13),
+		unique5:  make([]handValue, 1<<13),
+		products: make(map[uint32]handValue),
+	}
+	cards := make(Hand, 5)
+	var r [5]int
+	for r[0] = 0; r[0] < 13; r[0]++ {
+		for r[1] = r[0]; r[1] < 13; r[1]++ {
+			for r[2] = r[1]; r[2] < 13; r[2]++ {
+				for r[3] = r[2]; r[3] < 13; r[3]++ {
+					for r[4] = r[3]; r[4] < 13; r[4]++ {
+						if r[0] == r[4] {
+							// five of a kind
+							continue
+						}
+						var bits uint32
+						product := uint32(1)
+						unique := true
+						for i, n := range r {
+							// the k'th card of a rank gets the k'th suit
+							k := 0
+							for j := 0; j < i; j++ {
+								if r[j] == n {
+									k++
+								}
+							}
+							unique = unique && k == 0
+							cards[i] = newCard(n+2, k)
+							bits |= 1 << uint(n)
+							product *= rankPrimes[n]
+						}
+						if !unique {
+							t.products[product] = cards.slowValue(ranking)
+							continue
+						}
+						t.flushes[bits] = cards.slowValue(ranking)
+						cards[4] = newCard(r[4]+2, 1)
+						t.unique5[bits] = cards.slowValue(ranking)
+					}
+				}
+			}
+		}
+	}
+
+	// the best flush of more than five cards drops one of them
+	t.bestFlushes = make([]handValue, 1<<13)
+	for suited := uint32(0); suited < 1<<13; suited++ {
+		switch n := bits.OnesCount32(suited); {
+		case n == 5:
+			t.bestFlushes[suited] = t.flushes[suited]
+		case n > 5:
+			for rest := suited; rest != 0; rest &= rest - 1 {
+				if v := t.bestFlushes[suited&^(rest&-rest)]; v > t.bestFlushes[suited] {
+					t.bestFlushes[suited] = v
+				}
+			}
+		}
+	}
+
+	// and so does the best hand of more than five cards without one
+	for k := 5; k < len(t.ranks); k++ {
+		t.ranks[k] = make([]handValue, quinaryWays[13][k])
+		forEachCounts(&rankCounts{}, 0, k, func(counts *rankCounts) {
+			h := counts.hash(k)
+			if k == 5 {
+				t.ranks[k][h] = t.rankValue5(counts)
+				return
+			}
+			for r, c := range counts {
+				if c == 0 {
+					continue
+				}
+				counts[r]--
+				if v := t.ranks[k-1][counts.hash(k-1)]; v > t.ranks[k][h] {
+					t.ranks[k][h] = v
+				}
+				counts[r]++
+			}
+		})
+	}
+	return t
+}
+
+// rankValue5 returns the value of five cards of the ranks counted that
+// don't make a flush.
+func (t *evalTables) rankValue5(counts *rankCounts) handValue {
+	var rankBits uint32
+	product := uint32(1)
+	unique := true
+	for r, c := range counts {
+		for j := 0; j < c; j++ {
+			rankBits |= 1 << uint(r)
+			product *= rankPrimes[r]
+		}
+		unique = unique && c < 2
+	}
+	if unique {
+		return t.unique5[rankBits]
+	}
+	return t.products[product]
+}
+
+// evaluate5 returns the value of five cards.
+func (t *evalTables) evaluate5(c0, c1, c2, c3, c4 evalCard) handValue {
+	bits := (c0 | c1 | c2 | c3 | c4) >> 16
+	if c0&c1&c2&c3&c4&0xF000 != 0 {
+		return t.flushes[bits]
+	}
+	if v := t.unique5[bits]; v != 0 {
+		return v
+	}
+	return t.products[uint32(c0&0xFF)*uint32(c1&0xFF)*uint32(c2&0xFF)*uint32(c3&0xFF)*uint32(c4&0xFF)]
+}
+
+// evaluate returns the value of the best five of cards, of which there
+// must be five to seven.
+func (t *evalTables) evaluate(cards []evalCard) handValue {
+	var suited [4]uint32
+	var counts rankCounts
+	for _, c := range cards {
+		suited[bits.TrailingZeros32(uint32(c>>12)&0xF)] |= uint32(c >> 16)
+		counts[c>>8&0xF]++
+	}
+	for _, s := range suited {
+		if bits.OnesCount32(s) >= 5 {
+			return t.bestFlushes[s]
+		}
+	}
+	return t.ranks[len(cards)][counts.hash(len(cards))]
+}
+
+// value returns the value of five-card hand h under ranking.
+func (h Hand) value(ranking handRanking) handValue {
+	return ranking.tables.evaluate5(newEvalCard(h[0]), newEvalCard(h[1]), newEvalCard(h[2]), newEvalCard(h[3]), newEvalCard(h[4]))
+}
+
+// madeHand returns the five of cards that make a hand of value v, the
+// value of the best five of them under ranking, highest ranked first.
+func (ranking handRanking) madeHand(cards []Card, v handValue) Hand {
+	kind := ranking.order[v>>20]
+	ranks := make([]int, 0, 5)
+	for i := 0; i < 5; i++ {
+		if n := int(v>>uint(16-4*i)) & 0xF; n != 0 {
+			ranks = append(ranks, n)
+		}
+	}
+	if isEq(ranks, ranking.low) {
+		ranks = append([]int{}, ranking.wheel...)
+	}
+	counts := [5]int{1, 1, 1, 1, 1}
+	switch kind {
+	case fourOfAKind:
+		counts = [5]int{4, 1}
+	case fullHouse:
+		counts = [5]int{3, 2}
+	case threeOfAKind:
+		counts[0] = 3
+	case twoPair:
+		counts[0], counts[1] = 2, 2
+	case onePair:
+		counts[0] = 2
+	}
+	suit := -1
+	if kind == flush || kind == straightFlush {
+		var suits [4]int
+		for _, card := range cards {
+			if suits[card.suit()]++; suits[card.suit()] == 5 {
+				suit = card.suit()
+			}
+		}
+	}
+
+	h := make(Hand, 0, 5)
+	for i, n := range ranks {
+		for _, card := range cards {
+			if counts[i] > 0 && card.rank() == n && (suit < 0 || card.suit() == suit) {
+				h = append(h, card)
+				counts[i]--
+			}
+		}
+	}
+	return h
+}
+
+// slowValue returns the value of h under ranking from handRank, which the
+// lookup tables are built from: the kind of hand's place in the ranking,
+// then each of its card rankings in turn.
+func (h Hand) slowValue(ranking handRanking) handValue {
+	handRank, countRanks := h.handRank(ranking)
+	v := handValue(handRank) << 20
+	for i, n := range countRanks {
+		v |= handValue(n) << uint(16-4*i)
+	}
+	return v
+}
+
+func init() {
+	standardRanking.tables = newEvalTables(standardRanking)
+	shortDeckRanking.tables = newEvalTables(shortDeckRanking)
+}
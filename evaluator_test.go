@@ -0,0 +1,234 @@
+package main
+
+import (
+	"math/bits"
+	"math/rand"
+	"testing"
+)
+
+// forEachHand calls f with every five-card hand dealt from deck.
+func forEachHand(deck []Card, f func(h Hand)) {
+	h := make(Hand, 5)
+	n := len(deck)
+	for a := 0; a < n-4; a++ {
+		for b := a + 1; b < n-3; b++ {
+			for c := b + 1; c < n-2; c++ {
+				for d := c + 1; d < n-1; d++ {
+					for e := d + 1; e < n; e++ {
+						h[0], h[1], h[2], h[3], h[4] = deck[a], deck[b], deck[c], deck[d], deck[e]
+						f(h)
+					}
+				}
+			}
+		}
+	}
+}
+
+func testEvaluatorEquivalence(t *testing.T, name string, deck []Card, ranking handRanking) {
+	count := 0
+	forEachHand(deck, func(h Hand) {
+		count++
+		if fast, slow := h.value(ranking), h.slowValue(ranking); fast != slow {
+			t.Fatalf("%v: looked up %v as %x, handRank makes it %x", name, h, fast, slow)
+		}
+	})
+	if expected := combinations(len(deck), 5); count != expected {
+		t.Errorf("%v: checked %v hands, expected %v", name, count, expected)
+	}
+}
+
+func TestEvaluatorMatchesHandRank(t *testing.T) {
+	if testing.Short() {
+		t.Skip("checks every five-card hand")
+	}
+	testEvaluatorEquivalence(t, "standard", UNSHUFFLED[:], standardRanking)
+	testEvaluatorEquivalence(t, "short deck", SHORT_DECK, shortDeckRanking)
+}
+
+func TestEvaluateSevenCards(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, tt := range []struct {
+		name    string
+		deck    []Card
+		ranking handRanking
+	}{
+		{"standard", UNSHUFFLED[:], standardRanking},
+		{"short deck", SHORT_DECK, shortDeckRanking},
+	} {
+		for n := 5; n <= 7; n++ {
+			for i := 0; i < 20000; i++ {
+				order := r.Perm(len(tt.deck))
+				cards := make([]Card, n)
+				for j := range cards {
+					cards[j] = tt.deck[order[j]]
+				}
+				best := bestHand(nChooseK(cards, 5), tt.ranking)
+				v, expected := tt.ranking.tables.evaluate(evalCards(cards)), best.slowValue(tt.ranking)
+				if v != expected {
+					t.Fatalf("%v: evaluated %v as %x, expected %x for %v", tt.name, cards, v, expected, best)
+				}
+				if made := tt.ranking.madeHand(cards, v); len(made) != 5 || made.slowValue(tt.ranking) != v {
+					t.Fatalf("%v: made %v from %v, expected a hand valued %x like %v", tt.name, made, cards, v, best)
+				}
+			}
+		}
+	}
+}
+
+// slowBest returns the value of the best five of cards by handRank.
+func slowBest(cards []Card, ranking handRanking) (best handValue) {
+	for _, h := range nChooseK(cards, 5) {
+		if v := h.slowValue(ranking); v > best {
+			best = v
+		}
+	}
+	return best
+}
+
+func TestEvaluatorTablesMatchHandRank(t *testing.T) {
+	if testing.Short() {
+		t.Skip("checks every set of ranks and every flush")
+	}
+	for _, tt := range []struct {
+		name    string
+		ranking handRanking
+	}{
+		{"standard", standardRanking},
+		{"short deck", shortDeckRanking},
+	} {
+		// every count of ranks of five to seven cards, dealt round the
+		// suits so that no five share one
+		for k := 5; k <= 7; k++ {
+			checked := 0
+			forEachCounts(&rankCounts{}, 0, k, func(counts *rankCounts) {
+				cards := make([]Card, 0, k)
+				for r, c := range counts {
+					for j := 0; j < c; j++ {
+						cards = append(cards, newCard(r+2, len(cards)%4))
+					}
+				}
+				if v, expected := tt.ranking.tables.evaluate(evalCards(cards)), slowBest(cards, tt.ranking); v != expected {
+					t.Fatalf("%v: evaluated %v as %x, expected %x", tt.name, cards, v, expected)
+				}
+				checked++
+			})
+			if checked != len(tt.ranking.tables.ranks[k]) {
+				t.Errorf("%v: checked %v counts of %v cards' ranks, expected %v", tt.name, checked, k, len(tt.ranking.tables.ranks[k]))
+			}
+		}
+
+		// every flush of five to seven cards, with any other cards of
+		// seven pairing its highest ranks
+		for suited := uint32(0); suited < 1<<13; suited++ {
+			if n := bits.OnesCount32(suited); n < 5 || n > 7 {
+				continue
+			}
+			cards := make([]Card, 0, 7)
+			for r := 12; r >= 0; r-- {
+				if suited&(1<<uint(r)) != 0 {
+					cards = append(cards, newCard(r+2, 0))
+				}
+			}
+			for i := 0; len(cards) < 7; i++ {
+				cards = append(cards, newCard(cards[i].rank(), i+1))
+			}
+			if v, expected := tt.ranking.tables.evaluate(evalCards(cards)), slowBest(cards, tt.ranking); v != expected {
+				t.Fatalf("%v: evaluated %v as %x, expected %x", tt.name, cards, v, expected)
+			}
+		}
+	}
+}
+
+func TestVariantBest(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for name, v := range variants {
+		deck := v.deck()
+		for i := 0; i < 2000; i++ {
+			order := r.Perm(len(deck))
+			hole := make([]Card, v.holeCards())
+			board := make([]Card, v.boardCards())
+			for j := range hole {
+				hole[j] = deck[order[j]]
+			}
+			for j := range board {
+				board[j] = deck[order[len(hole)+j]]
+			}
+			best := bestHand(v.hands(hole, board), v.ranking())
+			got := v.best(hole, board)
+			if got.value(v.ranking()) != best.value(v.ranking()) {
+				t.Fatalf("%v: best of %v and %v was %v, expected %v", name, hole, board, got, best)
+			}
+			for _, h := range v.hands(hole, board) {
+				if h.value(v.ranking()) == best.value(v.ranking()) && sameCards(h, got) {
+					best = nil
+					break
+				}
+			}
+			if best != nil {
+				t.Fatalf("%v: %v is not a hand %v and %v may make", name, got, hole, board)
+			}
+		}
+	}
+}
+
+var benchmarkSevens = func() [][]Card {
+	r := rand.New(rand.NewSource(1))
+	sevens := make([][]Card, 1000)
+	for i := range sevens {
+		order := r.Perm(52)
+		for _, j := range order[:7] {
+			sevens[i] = append(sevens[i], UNSHUFFLED[j])
+		}
+	}
+	return sevens
+}()
+
+var benchmarkHands = func() [][]Hand {
+	hands := make([][]Hand, len(benchmarkSevens))
+	for i, cards := range benchmarkSevens {
+		hands[i] = nChooseK(cards, 5)
+	}
+	return hands
+}()
+
+// BenchmarkBestHandByHandRank finds the best of seven cards the way it
+// was done before the lookup evaluator: comparing handRank for each hand.
+func BenchmarkBestHandByHandRank(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		hands := benchmarkHands[i%len(benchmarkHands)]
+		best, bestCounts := hands[0].handRank(standardRanking)
+		for _, h := range hands[1:] {
+			rank, counts := h.handRank(standardRanking)
+			if rank > best || (rank == best && gt(counts, bestCounts)) {
+				best, bestCounts = rank, counts
+			}
+		}
+	}
+}
+
+// BenchmarkBestHandOfHands finds the best of seven cards by looking up
+// each of the hands they make.
+func BenchmarkBestHandOfHands(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		bestHand(benchmarkHands[i%len(benchmarkHands)], standardRanking)
+	}
+}
+
+// BenchmarkBestHand finds the best of seven cards the way a showdown does.
+func BenchmarkBestHand(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		cards := benchmarkSevens[i%len(benchmarkSevens)]
+		holdemVariant{}.best(cards[:2], cards[2:])
+	}
+}
+
+func BenchmarkEvaluateSevenCards(b *testing.B) {
+	sevens := make([][]evalCard, len(benchmarkSevens))
+	for i, cards := range benchmarkSevens {
+		sevens[i] = evalCards(cards)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		standardRanking.tables.evaluate(sevens[i%len(sevens)])
+	}
+}
@@ -225,7 +225,6 @@ func joinAnyGame(scheme, host, user, pass string) (*Game, error) {
 			return &Game{}, fmt.Errorf("did not join game; timed out trying to get game list")
 		}
 	}
-	panic("unreachable")
 }
 
 func getGameList(scheme, host string) (gameList []*Game, err error) {
@@ -263,6 +262,9 @@ func (g *Game) join() error {
 	}
 	req.SetBasicAuth(g.User, g.Pass)
 	resp, err := client(*useTLS).Do(req)
+	if err != nil {
+		return err
+	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusAccepted {
 		raw := make([]byte, 1024)
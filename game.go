@@ -1,13 +1,13 @@
 package main
 
 import (
+	"encoding/hex"
 	"fmt"
 	"math/rand"
 	"time"
 )
 
-const SEED int64 = 0 // seed for deal
-var UNSHUFFLED = generateCardNames()
+var UNSHUFFLED = generateCards()
 
 const (
 	fold int = iota
@@ -19,7 +19,6 @@ const (
 	folded
 	called
 )
-const BUY_IN money = 500
 
 type state int
 type money uint64
@@ -29,6 +28,9 @@ type Player struct {
 	guid     guid
 	wealth   money
 	bestHand Hand
+	// bestLow is the player's best eight-or-better low in a hi-lo game,
+	// or nil if they don't have one.
+	bestLow Hand
 }
 type Game struct {
 	table      Table
@@ -36,38 +38,134 @@ type Game struct {
 	gameID     guid
 	deck       Deck
 	round      uint
-	smallBlind money
+	rules      Rules
 	controller *controller
 	random     *rand.Rand
+	seed       int64
+	shuffle    string
+	handSeed   []byte
+	hands      int
+	history    *HandHistory
+	tournament *tournament
+	// straddler is the player who straddled this hand, if anyone did.
+	straddler *Player
+	// bringer is the player who brought in this hand, in stud games.
+	bringer *Player
+	// allIn is set once nobody can bet again this hand, though two or more
+	// players are still in it. Their hands are shown, with their equities,
+	// as the rest of the board is dealt.
+	allIn    bool
+	equities map[guid]Equity
+	// runTwice is set if the players all in agreed to run the board twice.
+	runTwice bool
+	// aggressor is the last player to bet or raise on the last round
+	// there was betting on, if anyone did.
+	aggressor *Player
+	// showOrder is the players who reached showdown, in the order they
+	// showed or mucked their hands.
+	showOrder Table
+	// sittingOut is the players keeping their seat and chips while they
+	// sit out. They aren't dealt in, and don't pay blinds, until they sit
+	// back in.
+	sittingOut Table
 }
 
-//run executes the game of poker forever while there are at least 2 players
+//run executes the game of poker while there are at least 2 players,
+// forever or until a tournament is over
 func (g *Game) run() {
-	i := 0
-	for {
-		i++
-		g.removeBrokePlayers()
-		g.addWaitingPlayers()
-		if len(g.table) < 2 {
-			fmt.Println("Less than two players at the table...")
+	for !g.over() && !g.controller.halted() {
+		if !g.playHand() {
+			if g.controller.halted() {
+				break
+			}
+			fmt.Println("Waiting for players...")
 			time.Sleep(2 * time.Second)
 			continue //Need 2 players to start a hand
 		}
-		g.table.AdvanceButton()
-		g.pot = newPot()
+		if g.hands%1000 == 0 {
+			fmt.Printf("completed %v hands...\n", g.hands)
+		}
+	}
+	if g.over() {
+		fmt.Printf("Tournament %v is over after %v hands\n", g.gameID, g.hands)
+	}
+}
+
+// over returns true once a tournament has a winner. Cash games are never over.
+func (g *Game) over() bool {
+	return g.tournament != nil && g.tournament.finished
+}
+
+// playHand seats any waiting players and plays a single hand. It returns
+// false, without playing, if there are fewer than 2 players at the table,
+// or if the game is a tournament that hasn't filled up or is over. It also
+// returns false, leaving the hand unfinished, if the game's replay halts.
+func (g *Game) playHand() bool {
+	if g.over() {
+		return false
+	}
+	g.removeBrokePlayers()
+	g.removeLeavers()
+	g.changeSitOuts()
+	g.addWaitingPlayers()
+	if len(g.table) < 2 {
+		return false
+	}
+	if g.tournament != nil && !g.tournament.ready(g) {
+		return false
+	}
+	g.table.AdvanceButton()
+	g.pot = newPot()
+	g.hands++
+	if g.tournament != nil {
+		g.tournament.setLevel(g)
+	}
+	g.history = newHandHistory(g)
+	g.allIn, g.runTwice, g.equities = false, false, nil
+	g.aggressor, g.showOrder = nil, nil
+	g.betAntes()
+	if g.variant().stud() {
+		g.deal()
+		g.betBringIn()
+	} else {
 		g.betBlinds()
+		g.betStraddle()
 		g.deal()
-		for g.round = 0; !g.allFolded() && g.round < 4; g.round++ {
-			g.placeBets()
-			g.table.makeCalledPlayersActive()
-			g.pot.newRound()
+	}
+	if g.controller.halted() {
+		return false
+	}
+	for g.round = 0; !g.allFolded() && g.round < uint(len(g.variant().streets())); g.round++ {
+		if g.playersInHand() > 1 {
+			g.history.reachStreet(g)
 		}
-		g.resolveBets()
-		g.table.makeAllPlayersActive()
-		if i%1000 == 0 {
-			fmt.Printf("completed %v hands...\n", i)
+		if g.allIn {
+			g.runOut()
+		} else {
+			g.placeBets()
+			if g.controller.halted() {
+				return false
+			}
+			if g.bettingOver() {
+				g.runOut()
+			}
 		}
+		g.table.makeCalledPlayersActive()
+		g.pot.newRound()
 	}
+	g.resolveBets()
+	g.history.Seed = hex.EncodeToString(g.handSeed)
+	g.controller.recordHand(g.history)
+	g.controller.clearPreActions()
+	g.controller.refillTimeBanks(g)
+	g.table.makeAllPlayersActive()
+	if g.tournament != nil {
+		g.tournament.eliminate(g)
+		g.removeBrokePlayers()
+		g.controller.publish(g)
+	}
+	g.controller.checkpoint(g)
+	return true
 }
 
 // removeBrokePlayers folds and removes any players with wealth == 0
@@ -82,57 +180,176 @@ func (g *Game) removeBrokePlayers() {
 	}
 }
 
+// removeLeavers takes the players who asked to leave away from the table,
+// and returns their chips.
+func (g *Game) removeLeavers() {
+	leaves := g.controller.leaves(g)
+	for _, player := range leaves {
+		g.controller.removePlayerFromGame(g, player)
+	}
+	if len(leaves) > 0 {
+		g.controller.publish(g)
+	}
+}
+
+// changeSitOuts moves the players who asked to sit out off the table, and
+// those who asked to sit back in onto it, joining where new players do.
+func (g *Game) changeSitOuts() {
+	out, in := g.controller.sitOutChanges(g)
+	if len(out) > 0 || len(in) > 0 {
+		defer g.controller.publish(g)
+		defer g.controller.updateSeats(g)
+	}
+	for _, p := range g.table.getPlayers(out) {
+		g.table = g.table.without(p.guid)
+		g.sittingOut = append(g.sittingOut, p)
+	}
+	for _, p := range g.sittingOut.getPlayers(in) {
+		g.sittingOut = g.sittingOut.without(p.guid)
+		err := g.table.addPlayer(p, g.rules.MaxSeats)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
 // addWaitingPlayers asks controller for waiting players
 //  and adds them to the table
 func (g *Game) addWaitingPlayers() {
-	numPlayersNeeded := (10 - len(g.table))
+	numPlayersNeeded := (g.rules.MaxSeats - len(g.table) - len(g.sittingOut))
 	newPlayers := g.controller.getNewPlayers(g, numPlayersNeeded)
 	for _, p := range newPlayers {
-		err := g.table.addPlayer(p.guid)
+		err := g.table.addPlayer(p, g.rules.MaxSeats)
 		if err != nil {
 			panic(err)
 		}
 	}
+	if len(newPlayers) > 0 {
+		g.controller.updateSeats(g)
+	}
+}
+
+// betAntes takes the ante from every player. Antes are dead money: they go
+// into a pot of their own, so they don't count towards anyone's bet. A
+// player who can't cover the ante is put all in. With a big blind ante,
+// betBlinds takes the ante instead.
+func (g *Game) betAntes() {
+	if g.rules.Ante == 0 || g.rules.BigBlindAnte {
+		return
+	}
+	for _, player := range g.table {
+		ante := g.rules.Ante
+		if player.wealth < ante {
+			ante = player.wealth
+		}
+		g.history.act(0, player.guid, "ante", ante, 0, ante == player.wealth)
+		g.pot.commitBet(player, ante)
+	}
+	g.pot.newRound()
 }
 
 // betBlinds places the small blind and big blind bet for the first two players.
 //  If those players do not have enough money to meet blinds, will put player all in
+//  With a big blind ante, the big blind then antes for the whole table, as
+//  dead money in the main pot, with whatever is left after the blind.
 func (g *Game) betBlinds() {
 	//Bet small blind
 	player := g.table[0]
-	if player.wealth >= g.smallBlind {
-		g.pot.commitBet(player, g.smallBlind)
+	if player.wealth > g.rules.SmallBlind {
+		g.history.act(0, player.guid, "small_blind", g.rules.SmallBlind, g.rules.SmallBlind, false)
+		g.pot.commitBet(player, g.rules.SmallBlind)
 	} else {
+		g.history.act(0, player.guid, "small_blind", player.wealth, player.wealth, true)
 		g.pot.commitBet(player, player.wealth)
 	}
 
 	//Bet big blind
 	player = g.table[1]
-	if player.wealth >= 2*g.smallBlind {
-		g.pot.commitBet(player, 2*g.smallBlind)
+	if player.wealth > g.rules.BigBlind {
+		g.history.act(0, player.guid, "big_blind", g.rules.BigBlind, g.rules.BigBlind, false)
+		g.pot.commitBet(player, g.rules.BigBlind)
 	} else {
+		g.history.act(0, player.guid, "big_blind", player.wealth, player.wealth, true)
 		g.pot.commitBet(player, player.wealth)
 	}
+	// the big blind is the first bet of the round, however short of it the
+	// blinds were
+	g.pot.minRaise = g.rules.BigBlind
+	g.pot.raises = 1
+
+	if g.rules.BigBlindAnte && g.rules.Ante > 0 && player.wealth > 0 {
+		ante := g.rules.Ante
+		if player.wealth < ante {
+			ante = player.wealth
+		}
+		g.history.act(0, player.guid, "ante", ante, 0, ante == player.wealth)
+		g.pot.commitDeadMoney(player, ante)
+	}
+}
+
+// betStraddle places a straddle of twice the big blind for the player after
+// the big blind, if the table allows straddles and the player wants to.
+// The straddle is a third blind: it must be called, raises must be by at
+// least as much, and the straddler acts last before the flop.
+func (g *Game) betStraddle() {
+	g.straddler = nil
+	if !g.rules.Straddles || len(g.table) < 3 {
+		return
+	}
+	player := g.table[2]
+	straddle := 2 * g.rules.BigBlind
+	if player.wealth <= straddle || !g.controller.straddles(g, player.guid) {
+		return
+	}
+	g.history.act(0, player.guid, "straddle", straddle, straddle, false)
+	g.pot.commitBet(player, straddle)
+	g.pot.minRaise = straddle
+	g.straddler = player
 }
 
-//deal assigns 2 unique cards to each player and 5 unique cards to the table
+//deal assigns the variant's unique hole cards to each player and 5 unique cards to the table
+// from the variant's deck.
+// In stud there is no table, and players are dealt all seven cards at once.
 func (g *Game) deal() {
-	g.deck = make(Deck, 52)
+	deck := g.variant().deck()
+	g.deck = make(Deck, 0, len(deck))
 	numPlayers := len(g.table)
-	rand_ints := g.random.Perm(52)
+	holeCards := g.variant().holeCards()
+	g.handSeed = g.newHandSeed()
+	rand_ints := shuffleOrder(g.handSeed, len(deck))
+	if g.variant().stud() {
+		g.dealStud(deck, rand_ints)
+		g.table.assignBestHands(g.deck, g.variant(), 0)
+		g.history.dealt(g)
+		g.controller.dealt(g)
+		return
+	}
 	for i := 0; i < numPlayers; i++ {
-		card1, card2 := UNSHUFFLED[rand_ints[i*2]], UNSHUFFLED[rand_ints[i*2+1]]
-		g.deck[card1] = string(g.table[i].guid)
-		g.deck[card2] = string(g.table[i].guid)
+		for j := 0; j < holeCards; j++ {
+			g.deck.deal(deck[rand_ints[i*holeCards+j]], location{place: hole, player: g.table[i].guid})
+		}
 	}
-	n := numPlayers * 2
-	g.deck[UNSHUFFLED[rand_ints[n+0]]] = "FLOP"
-	g.deck[UNSHUFFLED[rand_ints[n+1]]] = "FLOP"
-	g.deck[UNSHUFFLED[rand_ints[n+2]]] = "FLOP"
-	g.deck[UNSHUFFLED[rand_ints[n+3]]] = "TURN"
-	g.deck[UNSHUFFLED[rand_ints[n+4]]] = "RIVER"
+	n := numPlayers * holeCards
+	g.deck.deal(deck[rand_ints[n+0]], location{place: flop})
+	g.deck.deal(deck[rand_ints[n+1]], location{place: flop})
+	g.deck.deal(deck[rand_ints[n+2]], location{place: flop})
+	g.deck.deal(deck[rand_ints[n+3]], location{place: turn})
+	g.deck.deal(deck[rand_ints[n+4]], location{place: river})
 
-	g.table.assignBestHands(g.deck)
+	g.table.assignBestHands(g.deck, g.variant(), 0)
+	g.history.dealt(g)
+	g.controller.dealt(g)
+}
+
+// playersInHand returns the number of players who have not folded.
+func (g *Game) playersInHand() int {
+	n := 0
+	for _, p := range g.table {
+		if p.state != folded {
+			n++
+		}
+	}
+	return n
 }
 
 //allFolded returns true if all players have folded.
@@ -148,27 +365,41 @@ func (g *Game) allFolded() bool {
 
 //placeBets gets bet from controller, checks bet validity, and places bet
 func (g *Game) placeBets() {
-	for i := 0; g.betsNeeded(); i = (i + 1) % len(g.table) {
+	g.aggressor = nil
+	g.controller.dropChangedPreActions(g)
+	for i := g.firstToAct(); g.betsNeeded(); i = (i + 1) % len(g.table) {
 		player := g.table[i]
 
 		if player.state != active {
 			continue
 		}
 		action, betAmount, err := g.controller.getPlayerBet(g, player.guid)
+		if g.controller.halted() {
+			return
+		}
 
 		//Illegit bets
 		if err != nil {
-			//Err occurs on connection timeout
+			//Err occurs on connection timeout. The player keeps their seat,
+			// checking if they can and folding if not.
+			g.controller.timedOut(g, player.guid)
+			if g.pot.totalPlayerBetThisRound(player.guid) == g.pot.totalToCall {
+				g.history.act(g.round, player.guid, "timeout_check", 0, g.pot.totalPlayerBetThisRound(player.guid), false)
+				g.pot.commitBet(player, 0)
+				player.state = called
+				continue
+			}
+			g.history.act(g.round, player.guid, "timeout", 0, g.pot.totalPlayerBetThisRound(player.guid), false)
 			player.state = folded
-			fmt.Println("Player", player.guid, "timed out and is being removed from the game...")
-			g.controller.removePlayerFromGame(g, player.guid)
 			continue
 		}
 		if action == fold {
+			g.history.act(g.round, player.guid, "fold", 0, g.pot.totalPlayerBetThisRound(player.guid), false)
 			player.state = folded
 			continue
 		}
-		if g.pot.betInvalid(player, betAmount) {
+		if g.betInvalid(player, betAmount) {
+			g.history.act(g.round, player.guid, "invalid", 0, g.pot.totalPlayerBetThisRound(player.guid), false)
 			g.controller.registerInvalidBet(g, player.guid, betAmount)
 			player.state = folded
 			continue
@@ -178,9 +409,132 @@ func (g *Game) placeBets() {
 		if g.pot.raiseAmount(player.guid, betAmount) > 0 {
 			g.table.makeCalledPlayersActive()
 		}
+		if g.pot.totalPlayerBetThisRound(player.guid)+betAmount > g.pot.totalToCall {
+			g.aggressor = player
+		}
+		g.history.recordBet(g, player, betAmount)
 		g.pot.commitBet(player, betAmount)
 		player.state = called
+		g.controller.dropChangedPreActions(g)
+	}
+}
+
+// bettingOver returns true if two or more players are still in the hand,
+// but no more than one of them has chips left and they have called, so
+// nobody can bet again.
+func (g *Game) bettingOver() bool {
+	inHand, canBet := 0, 0
+	for _, p := range g.table {
+		if p.state == folded {
+			continue
+		}
+		inHand++
+		if p.wealth > 0 {
+			canBet++
+			if g.pot.totalPlayerBetThisRound(p.guid) < g.pot.totalToCall {
+				return false
+			}
+		}
 	}
+	return inHand > 1 && canBet <= 1
+}
+
+// runOut shows the hands of the players all in, with their equities on
+// the board so far, once nobody can bet again. The first time, it offers
+// to run the rest of the board twice; after that, the rounds are dealt
+// without asking anyone to bet.
+func (g *Game) runOut() {
+	if !g.allIn {
+		g.allIn = true
+		g.offerRunTwice()
+	}
+	g.equities = g.allInEquities()
+	g.controller.publish(g)
+}
+
+// offerRunTwice runs the rest of the board twice if the table allows it,
+// there are cards to come, and every player in the hand wants to.
+func (g *Game) offerRunTwice() {
+	if !g.rules.RunItTwice || g.round+1 >= uint(len(g.variant().streets())) {
+		return
+	}
+	agreed := true
+	for _, p := range g.table {
+		if p.state != folded && !g.controller.runsItTwice(g, p.guid) {
+			agreed = false
+		}
+	}
+	if !agreed {
+		return
+	}
+	g.runTwice = true
+	g.history.SecondBoard = append(make([]Card, 0), g.history.Board...)
+
+	// the second run is dealt from the cards after the first board
+	deck := g.variant().deck()
+	order := shuffleOrder(g.handSeed, len(deck))
+	n := len(g.table)*g.variant().holeCards() + g.variant().boardCards()
+	for _, p := range boardPlaces[g.round+1:] {
+		cards := 1
+		if p == flop {
+			cards = 3
+		}
+		for i := 0; i < cards; i++ {
+			g.deck.deal(deck[order[n]], location{place: p, run: 1})
+			n++
+		}
+	}
+}
+
+// allInEquities returns the equity of each player in an all-in hand, on
+// the board so far, from no more than ALL_IN_BOARDS boards. With the board
+// run twice, a player's equity is the average of their equities on each
+// board. Stud hands have no board to work equities out on.
+func (g *Game) allInEquities() map[guid]Equity {
+	if g.variant().stud() {
+		return nil
+	}
+	players := make([]guid, 0)
+	req := EquityRequest{Variant: g.rules.Variant}
+	for _, p := range g.table {
+		if p.state != folded {
+			players = append(players, p.guid)
+			req.Players = append(req.Players, g.deck.holeCards(p.guid))
+		}
+	}
+	boards := [][]Card{g.history.Board}
+	if g.runTwice {
+		boards = append(boards, g.history.SecondBoard)
+	}
+	equities := make(map[guid]Equity)
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for _, board := range boards {
+		req.Board = board
+		result, err := calculateEquity(req, random, allInBudget)
+		if err != nil {
+			return nil
+		}
+		for i, player := range players {
+			e := equities[player]
+			e.Win += result.Players[i].Win / float64(len(boards))
+			e.Tie += result.Players[i].Tie / float64(len(boards))
+			e.Equity += result.Players[i].Equity / float64(len(boards))
+			equities[player] = e
+		}
+	}
+	return equities
+}
+
+// firstToAct returns the index of the player who acts first this round.
+//  Before the flop of a straddled hand, the player after the straddler acts first.
+func (g *Game) firstToAct() int {
+	switch {
+	case g.variant().stud():
+		return g.studFirstToAct()
+	case g.round == 0 && g.straddler != nil:
+		return 3 % len(g.table)
+	}
+	return 0
 }
 
 // betsNeeded returns true if there are players that still need to bet
@@ -199,34 +553,169 @@ func (g *Game) betsNeeded() bool {
 
 // resolveBets loops through all sidepots. For each sidepot,
 // among the stakeholders, the pot is distributed to the winner(s).
+// A hand run twice has each sidepot split in two, and each half
+// distributed to the winners on one of the boards.
 func (g *Game) resolveBets() {
+	g.showdown()
+	runs := 1
+	if g.runTwice {
+		runs = 2
+	}
+	for run := 0; run < runs; run++ {
+		if run > 0 {
+			g.table.assignBestHands(g.deck, g.variant(), run)
+		}
+		g.resolveRun(run, runs)
+	}
+}
+
+// resolveRun distributes run's share of each sidepot. The first run
+// takes the odd chip.
+func (g *Game) resolveRun(run, runs int) {
 	moneyInPots := g.pot.amounts()
+	g.history.showdown(g.showOrder, run)
 
 	for potNumber, guids := range g.pot.stakeholders() {
 		sidepot := moneyInPots[potNumber]
+		if runs == 2 && run == 0 {
+			sidepot -= sidepot / 2
+		} else if runs == 2 {
+			sidepot /= 2
+		}
 		//TODO: what happens when we've removed the players from the list because they timed out?
 		players := g.table.getPlayers(guids)
 		winners := g.findWinners(players)
-		numWinners := money(len(winners))
-		for _, p := range winners {
-			p.wealth += sidepot / numWinners
-			if sidepot%numWinners > 0 {
-				p.wealth++
-				moneyInPots[potNumber]--
+		if len(winners) == 0 {
+			// everyone who put money in this pot has folded; give it back
+			winners = players
+		}
+		shares := make([]PotShare, 0)
+		var lowWinners []*Player
+		if g.variant().hiLo() {
+			lowWinners = g.findLowWinners(players)
+		}
+		if len(lowWinners) == 0 {
+			shares = share(shares, sidepot, winners)
+		} else {
+			// the high hand takes the odd chip of a split pot
+			shares = share(shares, sidepot-sidepot/2, winners)
+			shares = share(shares, sidepot/2, lowWinners)
+		}
+		g.history.pot(potNumber, run, sidepot, shares)
+	}
+}
+
+// showdown has the players still in the hand show their hands, starting
+// with the last player to bet or raise on the last round of betting, or
+// the first seat if nobody did, and going round the table. A player who
+// chose to muck losing hands mucks, and so folds, a hand that can't win
+// any part of a pot against the hands already shown. Hands all in were
+// shown already, and can't be mucked.
+func (g *Game) showdown() {
+	if g.playersInHand() < 2 {
+		return
+	}
+	start := 0
+	for i, p := range g.table {
+		if p == g.aggressor {
+			start = i
+		}
+	}
+	shown := make([]*Player, 0)
+	for n := range g.table {
+		p := g.table[(start+n)%len(g.table)]
+		if p.state == folded {
+			continue
+		}
+		g.showOrder = append(g.showOrder, p)
+		if !g.allIn && g.beaten(p, shown) && g.controller.mucks(g, p.guid) {
+			p.state = folded
+			continue
+		}
+		shown = append(shown, p)
+	}
+}
+
+// beaten returns true if, in every pot p has a stake in, a hand in shown
+// beats p's, and p has no low or a hand in shown has a better one.
+func (g *Game) beaten(p *Player, shown []*Player) bool {
+	if len(shown) == 0 {
+		return false
+	}
+	ranking := g.variant().ranking()
+	for _, guids := range g.pot.stakeholders() {
+		stake := false
+		for _, id := range guids {
+			stake = stake || id == p.guid
+		}
+		if !stake {
+			continue
+		}
+		highBeaten, lowBeaten := false, p.bestLow == nil
+		for _, q := range shown {
+			inPot := false
+			for _, id := range guids {
+				inPot = inPot || id == q.guid
+			}
+			if !inPot {
+				continue
+			}
+			highBeaten = highBeaten || q.bestHand.value(ranking) > p.bestHand.value(ranking)
+			if q.bestLow != nil && p.bestLow != nil {
+				qLow, _ := q.bestLow.lowRank()
+				pLow, _ := p.bestLow.lowRank()
+				lowBeaten = lowBeaten || less(qLow, pLow)
 			}
 		}
+		if !highBeaten || !lowBeaten {
+			return false
+		}
 	}
+	return true
 }
 
-//NewGame is a constructor for a Game object.
-func NewGame(gc *GameController) *Game {
+// share splits amount between winners and adds what each one won to shares.
+func share(shares []PotShare, amount money, winners []*Player) []PotShare {
+	numWinners := money(len(winners))
+	for i, p := range winners {
+		won := amount / numWinners
+		if money(i) < amount%numWinners {
+			// the odd chips go one each to the first winners
+			won++
+		}
+		p.wealth += won
+		found := false
+		for j := range shares {
+			if shares[j].Player == p.guid {
+				shares[j].Amount += won
+				found = true
+			}
+		}
+		if !found {
+			shares = append(shares, PotShare{Player: p.guid, Amount: won})
+		}
+	}
+	return shares
+}
+
+//NewGame is a constructor for a Game object played under rules. Unless
+// the game is switched to cryptoShuffle, every deal is determined by seed.
+func NewGame(gc *GameController, seed int64, rules Rules) *Game {
 	g := new(Game)
 	g.gameID = guid(createGuid())
 	g.table = make(Table, 0)
 	g.pot = new(Pot)
 	g.pot.bets = make([]Bet, 0)
+	g.rules = rules
+	g.seed = seed
+	g.shuffle = mathShuffle
+	if rules.Tournament != nil {
+		g.tournament = newTournament(*rules.Tournament)
+	}
 	g.controller = NewController(g)
-	g.smallBlind = 10
-	g.random = rand.New(rand.NewSource(SEED))
+	if gc != nil {
+		g.controller.ledger = gc.ledger
+	}
+	g.random = rand.New(rand.NewSource(seed))
 	return g
 }
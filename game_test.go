@@ -1,8 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 const passes = 100
@@ -26,3 +37,1460 @@ func TestS4(t *testing.T) {
 		}
 	}
 }
+
+func TestReplayReproducesGame(t *testing.T) {
+	for _, shuffle := range []string{mathShuffle, cryptoShuffle} {
+		testReplay(t, shuffle)
+	}
+}
+
+func testReplay(t *testing.T, shuffle string) {
+	dir, err := ioutil.TempDir("", "pokerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gc := NewGameController()
+	gc.logDir = dir
+	pg := gc.makeGame(42, shuffle, DefaultRules())
+	g := gc.Games[guid(pg.GameID)]
+	for _, id := range []guid{"player-1", "player-2", "player-3"} {
+		g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+	}
+
+	playHands(t, g, 20)
+	played := g.controller.handHistories()[:20]
+	for _, h := range played {
+		if v := VerifyHand(h.viewFor("")); !v.Valid {
+			t.Errorf("%v shuffle: hand %v does not verify: %v", shuffle, h.Number, v.Problem)
+		}
+	}
+
+	gameLog, err := readGameLog(filepath.Join(dir, pg.GameID+".log"))
+	if err != nil {
+		t.Fatalf("%v shuffle: could not read game log: %v", shuffle, err)
+	}
+	replayed, err := Replay(gameLog)
+	if err != nil {
+		t.Fatalf("%v shuffle: could not replay game: %v", shuffle, err)
+	}
+	hands := replayed.controller.handHistories()
+	if len(hands) < len(played) {
+		t.Fatalf("%v shuffle: replayed %v hands, expected at least %v", shuffle, len(hands), len(played))
+	}
+	for i, h := range played {
+		r := *hands[i]
+		r.Started = h.Started
+		if !reflect.DeepEqual(*h, r) {
+			t.Errorf("%v shuffle: hand %v differs on replay:\ngot      %+v\nexpected %+v", shuffle, h.Number, r, *h)
+		}
+	}
+}
+
+func TestReplayHalts(t *testing.T) {
+	// a log that ends partway through a hand replays without it
+	log := goldenLog()
+	log.Events = log.Events[:len(log.Events)-3]
+	g, err := Replay(log)
+	if err != nil {
+		t.Errorf("replaying a log that ends partway through a hand: %v", err)
+	}
+	if n := len(g.controller.handHistories()); n != 0 {
+		t.Errorf("replayed %v hands of a log that ends partway through the first", n)
+	}
+
+	// a log that doesn't fit the game stops where it goes wrong
+	log = goldenLog()
+	log.Events[4].Player = "player-2"
+	g, err = Replay(log)
+	if err == nil || !strings.Contains(err.Error(), "waited on player player-1") {
+		t.Errorf("replaying an act by the wrong player: got error %v", err)
+	}
+	if n := len(g.controller.handHistories()); n != 0 {
+		t.Errorf("replayed %v hands of a log that goes wrong in the first", n)
+	}
+}
+
+func TestRulesApplyToTable(t *testing.T) {
+	rules := Rules{SmallBlind: 25, BuyIn: 5000, MaxSeats: 2}.withDefaults()
+	if err := rules.validate(); err != nil {
+		t.Fatalf("rules %+v do not validate: %v", rules, err)
+	}
+	gc := NewGameController()
+	pg := gc.makeGame(7, mathShuffle, rules)
+	if pg.Rules != rules {
+		t.Errorf("public game shows rules %+v, expected %+v", pg.Rules, rules)
+	}
+	g := gc.Games[guid(pg.GameID)]
+	for _, id := range []guid{"player-1", "player-2", "player-3"} {
+		g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+	}
+
+	playHands(t, g, 1)
+	h := g.controller.handHistories()[0]
+	if h.SmallBlind != 25 || h.BigBlind != 50 {
+		t.Errorf("got blinds %v/%v, expected 25/50", h.SmallBlind, h.BigBlind)
+	}
+	if len(h.Seats) != 2 {
+		t.Errorf("got %v players seated at a 2 seat table", len(h.Seats))
+	}
+	for _, s := range h.Seats {
+		if s.Stack != 5000 {
+			t.Errorf("player %v sat down with %v, expected the buy-in of 5000", s.Player, s.Stack)
+		}
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	for _, rules := range []Rules{
+		{SmallBlind: 20, BigBlind: 10},
+		{SmallBlind: 100, BuyIn: 150},
+		{MaxSeats: 1},
+		{MaxSeats: MAX_SEATS + 1},
+		{Timeout: -1},
+		{Betting: "spread_limit"},
+		{Variant: "razz"},
+		{Variant: stud, MaxSeats: STUD_MAX_SEATS + 1},
+		{Variant: stud, Straddles: true},
+	} {
+		if err := rules.withDefaults().validate(); err == nil {
+			t.Errorf("rules %+v validated, expected an error", rules)
+		}
+	}
+}
+
+func TestTimeBankDefaults(t *testing.T) {
+	for _, tt := range []struct {
+		rules        string
+		bank, refill time.Duration
+	}{
+		{`{}`, TIME_BANK * time.Second, TIME_BANK_REFILL * time.Second},
+		{`{"time_bank":0,"time_bank_refill":0}`, 0, 0},
+		{`{"time_bank":0}`, 0, TIME_BANK_REFILL * time.Second},
+		{`{"time_bank":60,"time_bank_refill":0}`, 60 * time.Second, 0},
+	} {
+		var rules Rules
+		if err := json.Unmarshal([]byte(tt.rules), &rules); err != nil {
+			t.Fatal(err)
+		}
+		rules = rules.withDefaults()
+		if err := rules.validate(); err != nil {
+			t.Errorf("rules %v didn't validate: %v", tt.rules, err)
+		}
+		if rules.timeBank() != tt.bank || rules.timeBankRefill() != tt.refill {
+			t.Errorf("rules %v give a time bank of %v refilled by %v, expected %v refilled by %v", tt.rules, rules.timeBank(), rules.timeBankRefill(), tt.bank, tt.refill)
+		}
+	}
+	if err := (Rules{TimeBank: seconds(-1)}).withDefaults().validate(); err == nil {
+		t.Error("a negative time bank validated, expected an error")
+	}
+}
+
+func TestStraddleAndBigBlindAnte(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pokerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rules := Rules{Ante: 20, BigBlindAnte: true, Straddles: true, BuyIn: 2000, MaxSeats: 3}.withDefaults()
+	gc := NewGameController()
+	gc.logDir = dir
+	pg := gc.makeGame(3, mathShuffle, rules)
+	g := gc.Games[guid(pg.GameID)]
+	updates := g.controller.listen()
+	for _, id := range []guid{"player-1", "player-2", "player-3"} {
+		g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+		g.controller.setStraddle(id, true)
+	}
+
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("game never asked for a bet")
+	}
+	g.controller.unlisten(updates)
+	turn := g.controller.turn()
+	if turn.BetToPlayer != 40 || turn.MinRaise != 40 {
+		t.Errorf("got bet to player %v and minimum raise %v after a straddle, expected 40 and 40", turn.BetToPlayer, turn.MinRaise)
+	}
+	if turn.Player != g.table[0].guid {
+		t.Errorf("got %v first to act after a straddle, expected the small blind", turn.Player)
+	}
+
+	done := make(chan struct{})
+	go playBot(g, done)
+	for deadline := time.Now().Add(10 * time.Second); len(g.controller.handHistories()) < 10; {
+		if time.Now().After(deadline) {
+			t.Fatalf("game stopped after %v hands", len(g.controller.handHistories()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(done)
+	for _, h := range g.controller.handHistories()[:10] {
+		forced := make(map[string]money)
+		var bet, won money
+		for _, a := range h.Actions {
+			if a.forced() {
+				forced[a.Action] += a.Amount
+			}
+			bet += a.Amount
+		}
+		if forced["ante"] != 20 || forced["straddle"] != 40 {
+			t.Errorf("hand %v: got antes of %v and a straddle of %v, expected one ante of 20 and a straddle of 40", h.Number, forced["ante"], forced["straddle"])
+		}
+		for _, p := range h.Pots {
+			for _, s := range p.Winners {
+				won += s.Amount
+			}
+		}
+		if won != bet {
+			t.Errorf("hand %v: players bet %v and won %v, expected every chip bet to be won", h.Number, bet, won)
+		}
+	}
+
+	gameLog, err := readGameLog(filepath.Join(dir, pg.GameID+".log"))
+	if err != nil {
+		t.Fatalf("could not read game log: %v", err)
+	}
+	replayed, err := Replay(gameLog)
+	if err != nil {
+		t.Fatalf("could not replay game: %v", err)
+	}
+	for i, h := range g.controller.handHistories()[:10] {
+		r := *replayed.controller.handHistories()[i]
+		r.Started = h.Started
+		if !reflect.DeepEqual(*h, r) {
+			t.Errorf("hand %v differs on replay", h.Number)
+		}
+	}
+}
+
+func TestForcedBets(t *testing.T) {
+	tests := []struct {
+		name      string
+		rules     Rules
+		straddles bool
+		// stacks are what each player sits down with
+		stacks []money
+		// posted is what each player has put in, toCall the bet facing
+		// the next to act and first the player who acts first
+		posted     []money
+		inPot      money
+		toCall     money
+		minRaise   money
+		firstToAct int
+	}{
+		{"antes", Rules{Ante: 5}, false, []money{1000, 1000, 1000}, []money{15, 25, 5}, 45, 20, 20, 0},
+		{"short ante", Rules{Ante: 5}, false, []money{1000, 1000, 3}, []money{15, 25, 3}, 43, 20, 20, 0},
+		{"big blind ante", Rules{Ante: 20, BigBlindAnte: true}, false, []money{1000, 1000, 1000}, []money{10, 40, 0}, 50, 20, 20, 0},
+		{"short big blind ante", Rules{Ante: 20, BigBlindAnte: true}, false, []money{1000, 30, 1000}, []money{10, 30, 0}, 40, 20, 20, 0},
+		{"straddle", Rules{Straddles: true}, true, []money{1000, 1000, 1000, 1000}, []money{10, 20, 40, 0}, 70, 40, 40, 3},
+		{"straddle not wanted", Rules{Straddles: true}, false, []money{1000, 1000, 1000, 1000}, []money{10, 20, 0, 0}, 30, 20, 20, 0},
+		{"straddle not covered", Rules{Straddles: true}, true, []money{1000, 1000, 40, 1000}, []money{10, 20, 0, 0}, 30, 20, 20, 0},
+	}
+	for _, tt := range tests {
+		tt.rules.MaxSeats = len(tt.stacks)
+		rules := tt.rules.withDefaults()
+		g := NewGame(NewGameController(), 1, rules)
+		for i, stack := range tt.stacks {
+			id := guid("player-" + strconv.Itoa(i+1))
+			g.table.addPlayer(NewPlayer(id, stack), rules.MaxSeats)
+			g.controller.setStraddle(id, tt.straddles)
+		}
+		g.pot = newPot()
+		g.history = newHandHistory(g)
+		g.betAntes()
+		g.betBlinds()
+		g.betStraddle()
+		for i, p := range g.table {
+			if posted := tt.stacks[i] - p.wealth; posted != tt.posted[i] {
+				t.Errorf("%v: %v posted %v, expected %v", tt.name, p.guid, posted, tt.posted[i])
+			}
+		}
+		if g.pot.totalInPot() != tt.inPot || g.pot.totalToCall != tt.toCall || g.pot.minRaise != tt.minRaise {
+			t.Errorf("%v: got %v in the pot, %v to call and a minimum raise of %v, expected %v, %v and %v", tt.name, g.pot.totalInPot(), g.pot.totalToCall, g.pot.minRaise, tt.inPot, tt.toCall, tt.minRaise)
+		}
+		if first := g.firstToAct(); first != tt.firstToAct {
+			t.Errorf("%v: seat %v acts first, expected seat %v", tt.name, first, tt.firstToAct)
+		}
+	}
+}
+
+func TestOmaha(t *testing.T) {
+	for _, variant := range []string{omaha, omahaHiLo} {
+		testOmaha(t, variant)
+	}
+}
+
+func testOmaha(t *testing.T, variant string) {
+	rules := Rules{Variant: variant, Betting: potLimit}.withDefaults()
+	gc := NewGameController()
+	pg := gc.makeGame(5, mathShuffle, rules)
+	if pg.Variant != variant {
+		t.Errorf("public game shows variant %q, expected %q", pg.Variant, variant)
+	}
+	g := gc.Games[guid(pg.GameID)]
+	for _, id := range []guid{"player-1", "player-2", "player-3"} {
+		g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+	}
+
+	playHands(t, g, 5)
+	for _, h := range g.controller.handHistories()[:5] {
+		for player, hole := range h.Hole {
+			if len(hole) != 4 {
+				t.Errorf("%v hand %v: %v was dealt %v, expected four cards", variant, h.Number, player, hole)
+			}
+		}
+		if v := VerifyHand(h.viewFor("")); !v.Valid {
+			t.Errorf("%v hand %v does not verify: %v", variant, h.Number, v.Problem)
+		}
+	}
+}
+
+func TestShortDeck(t *testing.T) {
+	gc := NewGameController()
+	pg := gc.makeGame(5, mathShuffle, Rules{Variant: shortDeck}.withDefaults())
+	g := gc.Games[guid(pg.GameID)]
+	for _, id := range []guid{"player-1", "player-2", "player-3"} {
+		g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+	}
+
+	playHands(t, g, 5)
+	for _, h := range g.controller.handHistories()[:5] {
+		for player, hole := range h.Hole {
+			for _, card := range hole {
+				if card.rank() < 6 {
+					t.Errorf("short deck hand %v: %v was dealt %v", h.Number, player, card)
+				}
+			}
+		}
+		view := h.viewFor("")
+		if v := VerifyHand(view); !v.Valid || len(view.Cards) != 36 {
+			t.Errorf("short deck hand %v does not verify with %v cards: %v", h.Number, len(view.Cards), v.Problem)
+		}
+	}
+}
+
+func TestSevenCardStud(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pokerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rules := Rules{Variant: stud, Ante: 5}.withDefaults()
+	if rules.Betting != fixedLimit || rules.MaxSeats != STUD_MAX_SEATS {
+		t.Errorf("stud defaults to %v at %v seats, expected fixed limit at %v", rules.Betting, rules.MaxSeats, STUD_MAX_SEATS)
+	}
+	gc := NewGameController()
+	gc.logDir = dir
+	pg := gc.makeGame(9, mathShuffle, rules)
+	g := gc.Games[guid(pg.GameID)]
+	for _, id := range []guid{"player-1", "player-2", "player-3"} {
+		g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+	}
+
+	sawUpcards := false
+	playUntil(t, g, func() bool {
+		if cards := g.controller.publicGame().Cards; cards != nil && len(cards.Upcards) > 0 {
+			sawUpcards = true
+		}
+		return len(g.controller.handHistories()) >= 10
+	})
+	if !sawUpcards {
+		t.Errorf("public cards never showed anyone's upcards")
+	}
+	for _, h := range g.controller.handHistories()[:10] {
+		// the lowest upcard brings in, after the antes
+		var bringIn ActionRecord
+		var lowest Card
+		for _, a := range h.Actions {
+			if a.Action != "ante" {
+				bringIn = a
+				break
+			}
+		}
+		for player, up := range h.Upcards {
+			down := len(h.Hole[player]) - len(up)
+			if len(up) == 0 || len(up) > 4 || down < 2 || down > 2+len(up)/4 {
+				t.Errorf("hand %v: %v was dealt %v with %v up", h.Number, player, h.Hole[player], up)
+			}
+			if lowest == 0 || cardLess(up[0], lowest) {
+				lowest = up[0]
+			}
+		}
+		if bringIn.Action != "bring_in" || h.Upcards[bringIn.Player][0] != lowest {
+			t.Errorf("hand %v: %v by %v after the antes, expected the bring-in by the player showing %v", h.Number, bringIn.Action, bringIn.Player, lowest)
+		}
+		if v := VerifyHand(h.viewFor("")); !v.Valid {
+			t.Errorf("hand %v does not verify: %v", h.Number, v.Problem)
+		}
+	}
+
+	gameLog, err := readGameLog(filepath.Join(dir, pg.GameID+".log"))
+	if err != nil {
+		t.Fatalf("could not read game log: %v", err)
+	}
+	replayed, err := Replay(gameLog)
+	if err != nil {
+		t.Fatalf("could not replay game: %v", err)
+	}
+	for i, h := range g.controller.handHistories()[:10] {
+		r := *replayed.controller.handHistories()[i]
+		r.Started = h.Started
+		if !reflect.DeepEqual(*h, r) {
+			t.Errorf("hand %v differs on replay", h.Number)
+		}
+	}
+}
+
+func TestShowingRank(t *testing.T) {
+	// sorted from the best showing to the worst
+	showing := [][]Card{
+		mustParseCards("9C 9D 9H 9S"),
+		mustParseCards("2C 2D 2H AS"),
+		mustParseCards("KC KD 3H 3S"),
+		mustParseCards("AC AD 5H 4S"),
+		mustParseCards("AC KD QH JS"),
+		mustParseCards("AC KD QH TS"),
+	}
+	for i := 0; i < len(showing)-1; i++ {
+		r1, c1 := showingRank(showing[i])
+		r2, c2 := showingRank(showing[i+1])
+		if r1 < r2 || (r1 == r2 && !gt(c1, c2)) {
+			t.Errorf("%v shows worse than %v", showing[i], showing[i+1])
+		}
+	}
+}
+
+func TestHiLoSplitsPots(t *testing.T) {
+	// each of three players puts 100 in the pot; player-1 has the best
+	// high, and ties player-2 for the best low
+	resolve := func(lows bool) []money {
+		g := NewGame(NewGameController(), 1, Rules{Variant: omahaHiLo}.withDefaults())
+		g.pot = newPot()
+		g.history = newHandHistory(g)
+		high := []string{"AS AH AD KC QC", "9S 9H 2C 3D 4H", "KS KH 2D 3S 5C"}
+		low := []string{"AS 2C 3D 4H 6S", "AH 2D 3C 4S 6H", ""}
+		for i, id := range []guid{"player-1", "player-2", "player-3"} {
+			p := NewPlayer(id, 100)
+			p.bestHand = mustParseCards(high[i])
+			if lows && low[i] != "" {
+				p.bestLow = mustParseCards(low[i])
+			}
+			g.table.addPlayer(p, g.rules.MaxSeats)
+			g.pot.commitBet(p, 100)
+		}
+		g.pot.newRound()
+		g.resolveBets()
+		wealth := make([]money, 0)
+		for _, p := range g.table {
+			wealth = append(wealth, p.wealth)
+		}
+		return wealth
+	}
+
+	if got, expected := resolve(true), []money{225, 75, 0}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("quartered pot: got %v, expected %v", got, expected)
+	}
+	if got, expected := resolve(false), []money{300, 0, 0}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("pot without a low: got %v, expected %v", got, expected)
+	}
+}
+
+func TestAllInShowdown(t *testing.T) {
+	// two players are all in before the flop; the board is run twice
+	// only if they both agree
+	for _, bothAgree := range []bool{false, true} {
+		g := NewGame(NewGameController(), 7, Rules{RunItTwice: true, BuyIn: 1000, MaxSeats: 2}.withDefaults())
+		for _, id := range []guid{"player-1", "player-2"} {
+			g.table.addPlayer(NewPlayer(id, g.rules.BuyIn), g.rules.MaxSeats)
+		}
+		g.controller.setRunItTwice("player-1", true)
+		g.controller.setRunItTwice("player-2", bothAgree)
+		g.pot = newPot()
+		g.history = newHandHistory(g)
+		g.deal()
+		for _, p := range g.table {
+			g.pot.commitBet(p, p.wealth)
+			p.state = called
+		}
+		if !g.bettingOver() {
+			t.Fatalf("betting isn't over with both players all in")
+		}
+		g.runOut()
+
+		allIn := MakePublicGame(g).AllIn
+		if allIn == nil || allIn.RunTwice != bothAgree || len(allIn.Hole) != 2 || len(allIn.Equities) != 2 {
+			t.Fatalf("got all in showdown %+v, expected both hands shown with their equities", allIn)
+		}
+		total := 0.0
+		for _, e := range allIn.Equities {
+			total += e.Equity
+		}
+		if math.Abs(total-1) > 1e-9 {
+			t.Errorf("all in equities add up to %v", total)
+		}
+
+		g.pot.newRound()
+		for g.round = 1; g.round < 4; g.round++ {
+			g.history.reachStreet(g)
+			g.runOut()
+		}
+		g.resolveBets()
+		runs := 0
+		var won money
+		for _, p := range g.history.Pots {
+			runs++
+			if p.Size != 2000/money(len(g.history.Pots)) {
+				t.Errorf("run %v has a pot of %v", p.Run, p.Size)
+			}
+		}
+		for _, p := range g.table {
+			won += p.wealth
+		}
+		if bothAgree != (runs == 2) || won != 2000 {
+			t.Errorf("run twice %v: got %v runs paying out %v, expected 2000", bothAgree, runs, won)
+		}
+		if bothAgree != (len(g.history.SecondBoard) == 5) {
+			t.Errorf("run twice %v: got a second board of %v", bothAgree, g.history.SecondBoard)
+		}
+		g.history.Seed = hex.EncodeToString(g.handSeed)
+		if v := VerifyHand(g.history.viewFor("")); !v.Valid {
+			t.Errorf("run twice %v: hand does not verify: %v", bothAgree, v.Problem)
+		}
+	}
+}
+
+func TestRunItTwice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pokerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rules := Rules{RunItTwice: true, BuyIn: 1000, MaxSeats: 2}.withDefaults()
+	gc := NewGameController()
+	gc.logDir = dir
+	pg := gc.makeGame(7, mathShuffle, rules)
+	g := gc.Games[guid(pg.GameID)]
+	updates := g.controller.listen()
+	for _, id := range []guid{"player-1", "player-2"} {
+		g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+		g.controller.setRunItTwice(id, true)
+	}
+
+	// both players move all in before the flop
+	for len(g.controller.handHistories()) < 1 {
+		select {
+		case <-updates:
+		case <-time.After(5 * time.Second):
+			t.Fatal("game stopped before the hand finished")
+		}
+		if turn := g.controller.turn(); turn.Player != "" {
+			g.controller.registerPlayerAct(Act{Player: turn.Player, Action: call, BetAmount: turn.MaxBet})
+		}
+	}
+	g.controller.unlisten(updates)
+
+	h := g.controller.handHistories()[0]
+	if len(h.Board) != 5 || len(h.SecondBoard) != 5 || sameCards(h.Board, h.SecondBoard) {
+		t.Errorf("hand was run out to %v and %v, expected two different boards", h.Board, h.SecondBoard)
+	}
+	var runs [2]money
+	var won money
+	for _, p := range h.Pots {
+		runs[p.Run] += p.Size
+		for _, s := range p.Winners {
+			won += s.Amount
+		}
+	}
+	if runs != [2]money{1000, 1000} || won != 2000 {
+		t.Errorf("got pots of %v for each run and %v won, expected 1000 for each and 2000 won", runs, won)
+	}
+	if v := VerifyHand(h.viewFor("")); !v.Valid {
+		t.Errorf("hand run twice does not verify: %v", v.Problem)
+	}
+	var text bytes.Buffer
+	h.WriteText(&text, "player-1")
+	if !strings.Contains(text.String(), "*** SECOND SHOW DOWN ***") {
+		t.Errorf("hand history shows one showdown:\n%v", text.String())
+	}
+
+	gameLog, err := readGameLog(filepath.Join(dir, pg.GameID+".log"))
+	if err != nil {
+		t.Fatalf("could not read game log: %v", err)
+	}
+	replayed, err := Replay(gameLog)
+	if err != nil {
+		t.Fatalf("could not replay game: %v", err)
+	}
+	r := *replayed.controller.handHistories()[0]
+	r.Started = h.Started
+	if !reflect.DeepEqual(*h, r) {
+		t.Errorf("hand run twice differs on replay")
+	}
+}
+
+func TestShowdownOrderAndMuck(t *testing.T) {
+	// player-1 is all in for 50 with the best hand; player-2 bet last and
+	// shows first, and player-3 can't beat player-2 in either pot
+	g := NewGame(NewGameController(), 1, DefaultRules())
+	g.pot = newPot()
+	g.history = newHandHistory(g)
+	hole := []string{"AS AH", "KS KH", "QS QH"}
+	best := []string{"AS AH AD KC QC", "KS KH 2C 3D 4H", "QS QH 2D 3S 5C"}
+	bets := []money{50, 100, 100}
+	for i, id := range []guid{"player-1", "player-2", "player-3"} {
+		p := NewPlayer(id, bets[i])
+		p.bestHand = mustParseCards(best[i])
+		g.table.addPlayer(p, g.rules.MaxSeats)
+		g.history.Hole[id] = mustParseCards(hole[i])
+		g.pot.commitBet(p, bets[i])
+		g.controller.setMuck(id, true)
+	}
+	g.aggressor = g.table[1]
+	g.pot.newRound()
+	g.resolveBets()
+
+	shown := make([]string, 0)
+	for _, ph := range g.history.Showdown {
+		shown = append(shown, fmt.Sprintf("%v %v", ph.PlayerID, ph.Mucked))
+	}
+	if expected := []string{"player-2 false", "player-3 true", "player-1 false"}; !reflect.DeepEqual(shown, expected) {
+		t.Errorf("got showdown %v, expected %v", shown, expected)
+	}
+	if wealth := []money{g.table[0].wealth, g.table[1].wealth, g.table[2].wealth}; !reflect.DeepEqual(wealth, []money{150, 100, 0}) {
+		t.Errorf("got wealth %v after showdown, expected [150 100 0]", wealth)
+	}
+	view := g.history.viewFor("player-1")
+	if _, ok := view.Hole["player-3"]; ok || len(view.Hole) != 2 {
+		t.Errorf("player-1 can see hole cards %v, expected player-3's to be mucked", view.Hole)
+	}
+}
+
+func TestShowAfterUncontestedWin(t *testing.T) {
+	c := NewGame(NewGameController(), 1, DefaultRules()).controller
+	if err := c.show("player-1"); err == nil {
+		t.Errorf("showed a hand before any were played")
+	}
+	c.recordHand(&HandHistory{
+		Number:   1,
+		Hole:     map[guid][]Card{"player-1": mustParseCards("AS AH"), "player-2": mustParseCards("KS KH")},
+		Showdown: make([]Playerhand, 0),
+		Pots:     []PotRecord{{Size: 30, Winners: []PotShare{{Player: "player-1", Amount: 30}}}},
+	})
+	if err := c.show("player-2"); err == nil {
+		t.Errorf("player-2 showed a hand they folded")
+	}
+	if err := c.show("player-1"); err != nil {
+		t.Fatalf("player-1 could not show the hand they won: %v", err)
+	}
+	if shown := c.public.LastShowdown; len(shown) != 1 || shown[0].PlayerID != "player-1" || joinCards(shown[0].Hole) != "AS AH" {
+		t.Errorf("got last showdown %+v, expected player-1 to show AS AH", shown)
+	}
+	h := c.handHistories()[0]
+	if _, ok := h.viewFor("player-2").Hole["player-1"]; !ok {
+		t.Errorf("player-2 can't see the hand player-1 showed")
+	}
+	var text bytes.Buffer
+	h.WriteText(&text, "player-2")
+	if !strings.Contains(text.String(), "player-1: shows [AS AH]") {
+		t.Errorf("hand history doesn't show player-1's hand:\n%v", text.String())
+	}
+}
+
+func TestSitOutAndLeave(t *testing.T) {
+	gc := NewGameController()
+	gc.ledger = NewLedger()
+	g := NewGame(gc, 1, DefaultRules())
+	for _, id := range []guid{"player-1", "player-2", "player-3", "player-4"} {
+		g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+	}
+	g.controller.requestLeave("player-4")
+	g.addWaitingPlayers()
+	if len(g.table) != 3 || g.table.contains("player-4") {
+		t.Fatalf("player-4 left before being seated, but the table is %v", g.table)
+	}
+	g.controller.setSitOut("player-3", true)
+	g.changeSitOuts()
+	if len(g.table) != 2 || !g.sittingOut.contains("player-3") {
+		t.Fatalf("expected player-3 to be sitting out")
+	}
+	if !g.controller.hasSeat("player-3") || g.controller.isSeated("player-3") || !g.controller.isSeated("player-1") {
+		t.Errorf("the controller doesn't have player-3 sitting out and player-1 playing")
+	}
+	if err := g.controller.enqueuePlayer(g, NewPlayer("player-3", g.rules.BuyIn)); err == nil {
+		t.Errorf("player-3 was queued to join while sitting out")
+	}
+	for _, pp := range MakePublicGame(g).Table {
+		if pp.GUID == "player-3" && pp.State != "sitting_out" {
+			t.Errorf("player-3 is %v, expected sitting_out", pp.State)
+		}
+	}
+	g.table[0].state = folded
+	g.controller.requestLeave(g.table[0].guid)
+	if pp := MakePublicPlayer(g, g.table[0]); pp.State != "leaving" {
+		t.Errorf("folded player leaving is %v, expected leaving", pp.State)
+	}
+	g.controller.requestLeave("player-3")
+	g.removeLeavers()
+	if len(g.table) != 1 || len(g.sittingOut) != 0 {
+		t.Fatalf("expected only one player left, got table %v and %v sitting out", g.table, g.sittingOut)
+	}
+	if g.controller.hasSeat("player-3") {
+		t.Errorf("the controller still has a seat for player-3 after they left")
+	}
+	if b := gc.ledger.balance(userAccount("player-3")); b != STARTING_BANKROLL {
+		t.Errorf("player-3 has %v after leaving, expected all %v back", b, STARTING_BANKROLL)
+	}
+}
+
+func TestSitOutAndLeaveReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pokerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gc := NewGameController()
+	gc.logDir = dir
+	pg := gc.makeGame(5, mathShuffle, DefaultRules())
+	g := gc.Games[guid(pg.GameID)]
+	for _, id := range []guid{"player-1", "player-2", "player-3"} {
+		g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+	}
+	seated := func(h *HandHistory, player guid) bool {
+		for _, s := range h.Seats {
+			if s.Player == player {
+				return true
+			}
+		}
+		return false
+	}
+
+	playHands(t, g, 3)
+	g.controller.setSitOut("player-3", true)
+	n := len(g.controller.handHistories())
+	playHands(t, g, n+3)
+	for _, h := range g.controller.handHistories()[n+1:] {
+		if seated(h, "player-3") {
+			t.Errorf("player-3 was dealt into hand %v while sitting out", h.Number)
+		}
+	}
+	g.controller.setSitOut("player-3", false)
+	n = len(g.controller.handHistories())
+	playHands(t, g, n+3)
+	for _, h := range g.controller.handHistories()[n+1:] {
+		if !seated(h, "player-3") {
+			t.Errorf("player-3 wasn't dealt into hand %v after sitting back in", h.Number)
+		}
+	}
+	g.controller.requestLeave("player-2")
+	n = len(g.controller.handHistories())
+	playHands(t, g, n+3)
+	for _, h := range g.controller.handHistories()[n+1:] {
+		if seated(h, "player-2") {
+			t.Errorf("player-2 was dealt into hand %v after leaving", h.Number)
+		}
+	}
+
+	played := g.controller.handHistories()
+	gameLog, err := readGameLog(filepath.Join(dir, pg.GameID+".log"))
+	if err != nil {
+		t.Fatalf("could not read game log: %v", err)
+	}
+	replayed, err := Replay(gameLog)
+	if err != nil {
+		t.Fatalf("could not replay game: %v", err)
+	}
+	hands := replayed.controller.handHistories()
+	if len(hands) < len(played) {
+		t.Fatalf("replayed %v hands, expected at least %v", len(hands), len(played))
+	}
+	sameHands(t, "replayed", hands[:len(played)], played)
+}
+
+func TestRegisterPlayerAct(t *testing.T) {
+	rules := Rules{Timeout: 1, TimeBank: seconds(1), TimeBankRefill: seconds(1)}.withDefaults()
+	gc := NewGameController()
+	pg := gc.makeGame(3, mathShuffle, rules)
+	g := gc.Games[guid(pg.GameID)]
+	for _, id := range []guid{"player-2", "player-1"} {
+		g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+	}
+	updates := g.controller.listen()
+	defer g.controller.unlisten(updates)
+	waitForTurn := func(player guid) Turn {
+		for {
+			if turn := g.controller.turn(); turn.Player == player {
+				return turn
+			}
+			select {
+			case <-updates:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("game never asked %v to act", player)
+			}
+		}
+	}
+
+	// the same act, sent at once over many connections, is taken once
+	turn := waitForTurn("player-1")
+	errs := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() {
+			errs <- g.controller.registerPlayerAct(Act{Player: "player-1", Action: call, BetAmount: turn.BetToPlayer - turn.PlayerBet})
+		}()
+	}
+	taken := 0
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err == nil {
+			taken++
+		}
+	}
+	if taken != 1 {
+		t.Errorf("the same act was taken %v times, expected once", taken)
+	}
+
+	// player-2 runs out of time, and their act sent after is refused
+	// instead of being taken on player-1's turn
+	waitForTurn("player-2")
+	waitForTurn("player-1")
+	err := g.controller.registerPlayerAct(Act{Player: "player-2", Action: fold})
+	if e, ok := err.(*ActError); !ok || e.Code != notYourTurn {
+		t.Errorf("act after player-2's turn ran out got %v, expected it not to be their turn", err)
+	}
+	if turn := g.controller.turn(); turn.Player != "player-1" {
+		t.Errorf("it is %q's turn after player-2's late act, expected player-1's", turn.Player)
+	}
+}
+
+func TestTimeBank(t *testing.T) {
+	rules := Rules{Timeout: 1, TimeBank: seconds(1), TimeBankRefill: seconds(1)}.withDefaults()
+	gc := NewGameController()
+	pg := gc.makeGame(3, mathShuffle, rules)
+	g := gc.Games[guid(pg.GameID)]
+	// player-1 is the small blind, so acts first on every street
+	for _, id := range []guid{"player-2", "player-1"} {
+		g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+	}
+
+	// player-1 calls preflop and bets the flop, while player-2 never acts
+	updates := g.controller.listen()
+	defer g.controller.unlisten(updates)
+	banks := make(map[int]bool)
+	for len(g.controller.handHistories()) == 0 {
+		select {
+		case <-updates:
+		case <-time.After(3 * time.Second):
+			t.Fatal("game stopped asking for bets")
+		}
+		turn := g.controller.turn()
+		switch turn.Player {
+		case "player-1":
+			act := Act{Player: turn.Player, Action: call, BetAmount: turn.BetToPlayer - turn.PlayerBet}
+			if act.BetAmount == 0 {
+				act.BetAmount = g.rules.BigBlind
+			}
+			g.controller.registerPlayerAct(act)
+		case "player-2":
+			banks[turn.TimeBank] = true
+		}
+	}
+	if !banks[1] || !banks[0] {
+		t.Errorf("player-2 was shown time banks %v, expected a full one and then an empty one", banks)
+	}
+	actions := make([]string, 0)
+	for _, a := range g.controller.handHistories()[0].Actions {
+		if a.Player == "player-2" && a.Action != "big_blind" {
+			actions = append(actions, a.Street+" "+a.Action)
+		}
+	}
+	expected := []string{"PREFLOP timeout_check", "FLOP timeout"}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("player-2 took actions %v, expected %v", actions, expected)
+	}
+
+	// having timed out, player-2 keeps their seat but sits out
+	for i := 0; ; i++ {
+		if i == 100 {
+			t.Fatal("player-2 never sat out")
+		}
+		public := g.controller.publicGame()
+		if len(public.Table) == 2 && public.Table[1].GUID == "player-2" && public.Table[1].State == "sitting_out" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPreActions(t *testing.T) {
+	gc := NewGameController()
+	pg := gc.makeGame(8, mathShuffle, DefaultRules())
+	g := gc.Games[guid(pg.GameID)]
+	// player-1 is the small blind, so acts first on every street
+	for _, id := range []guid{"player-2", "player-1"} {
+		g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+	}
+
+	// on each street, player-2 queues a pre-action before player-1 acts
+	streets := []struct {
+		pre PreAction
+		bet money
+	}{
+		{PreAction{Action: "check_fold"}, 10},
+		{PreAction{Action: "call", Amount: 20}, 40},
+		{PreAction{Action: "call", Amount: 1000}, 0},
+		{PreAction{Action: "fold"}, 0},
+	}
+	updates := g.controller.listen()
+	defer g.controller.unlisten(updates)
+	street := 0
+	for len(g.controller.handHistories()) == 0 {
+		select {
+		case <-updates:
+		case <-time.After(time.Second):
+			t.Fatal("game stopped asking for bets")
+		}
+		turn := g.controller.turn()
+		switch turn.Player {
+		case "player-1":
+			if street == len(streets) {
+				t.Fatal("player-1 was asked to act after the river")
+			}
+			if err := g.controller.setPreAction("player-1", PreAction{Action: "fold"}); err == nil {
+				t.Error("player-1 queued a pre-action on their own turn")
+			}
+			if err := g.controller.setPreAction("player-2", streets[street].pre); err != nil {
+				t.Fatalf("could not queue pre-action: %v", err)
+			}
+			if g.controller.registerPlayerAct(Act{Player: "player-1", Action: call, BetAmount: streets[street].bet}) == nil {
+				street++
+			}
+		case "player-2":
+			// player-1's bet on the flop dropped player-2's pre-action
+			if p := g.controller.preAction("player-2"); p != nil {
+				t.Errorf("player-2 still has pre-action %+v after the bet facing them changed", *p)
+			}
+			g.controller.registerPlayerAct(Act{Player: "player-2", Action: call, BetAmount: 40})
+		}
+	}
+
+	actions := make([]string, 0)
+	for _, a := range g.controller.handHistories()[0].Actions {
+		if a.Player == "player-2" && a.Action != "big_blind" {
+			actions = append(actions, a.Street+" "+a.Action)
+		}
+	}
+	expected := []string{"PREFLOP check", "FLOP call", "TURN check", "RIVER fold"}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("player-2 took actions %v, expected %v", actions, expected)
+	}
+}
+
+func TestPreActionQueuedBetweenRounds(t *testing.T) {
+	gc := NewGameController()
+	pg := gc.makeGame(8, mathShuffle, DefaultRules())
+	g := gc.Games[guid(pg.GameID)]
+	for _, id := range []guid{"player-2", "player-1"} {
+		g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+	}
+
+	// the small blind acts first on every street, and queues each pre-action
+	// after acting, while the big blind can still close the betting round
+	pres := []PreAction{{Action: "check_fold"}, {Action: "call", Amount: 20}}
+	bets := []money{0, 40}
+	updates := g.controller.listen()
+	defer g.controller.unlisten(updates)
+	var first, second guid
+	turns := map[guid]int{}
+	for len(g.controller.handHistories()) == 0 {
+		select {
+		case <-updates:
+		case <-time.After(time.Second):
+			t.Fatal("game stopped asking for bets")
+		}
+		turn := g.controller.turn()
+		if turn.Player == "" || len(g.controller.handHistories()) > 0 {
+			// the next hand may already be asking for bets
+			continue
+		}
+		if first == "" {
+			first, second = turn.Player, "player-1"
+			if first == "player-1" {
+				second = "player-2"
+			}
+		}
+		switch turn.Player {
+		case first:
+			switch turns[first] {
+			case 0:
+				g.controller.registerPlayerAct(Act{Player: first, Action: call, BetAmount: 10})
+			case 1:
+				// the bet of 40 dropped the call up to 20
+				if p := g.controller.preAction(first); p != nil {
+					t.Errorf("%v still has pre-action %+v after a bet it didn't cover", first, *p)
+				}
+				g.controller.registerPlayerAct(Act{Player: first, Action: fold})
+			default:
+				t.Fatalf("%v was asked to act after folding", first)
+			}
+		case second:
+			if turns[second] == len(pres) {
+				t.Fatalf("%v was asked to act after %v folded", second, first)
+			}
+			if err := g.controller.setPreAction(first, pres[turns[second]]); err != nil {
+				t.Fatalf("could not queue pre-action: %v", err)
+			}
+			g.controller.registerPlayerAct(Act{Player: second, Action: call, BetAmount: bets[turns[second]]})
+		}
+		turns[turn.Player]++
+	}
+
+	actions := make([]string, 0)
+	for _, a := range g.controller.handHistories()[0].Actions {
+		if a.Player == first && a.Action != "small_blind" {
+			actions = append(actions, a.Street+" "+a.Action)
+		}
+	}
+	expected := []string{"PREFLOP call", "FLOP check", "FLOP fold"}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("%v took actions %v, expected %v", first, actions, expected)
+	}
+}
+
+func TestPreActionDroppedByRaise(t *testing.T) {
+	for _, pre := range []PreAction{{Action: "call_any"}, {Action: "fold"}, {Action: "check_fold"}, {Action: "call", Amount: 1000}} {
+		gc := NewGameController()
+		pg := gc.makeGame(8, mathShuffle, DefaultRules())
+		g := gc.Games[guid(pg.GameID)]
+		for _, id := range []guid{"player-2", "player-1"} {
+			g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+		}
+
+		// the small blind calls, and queues the pre-action while the big
+		// blind raises, so it must decide again for itself
+		updates := g.controller.listen()
+		var first guid
+		turns := 0
+		for len(g.controller.handHistories()) == 0 {
+			select {
+			case <-updates:
+			case <-time.After(time.Second):
+				t.Fatal("game stopped asking for bets")
+			}
+			turn := g.controller.turn()
+			if turn.Player == "" || len(g.controller.handHistories()) > 0 {
+				continue
+			}
+			if first == "" {
+				first = turn.Player
+			}
+			if turn.Player != first {
+				if err := g.controller.setPreAction(first, pre); err != nil {
+					t.Fatalf("could not queue pre-action: %v", err)
+				}
+				g.controller.registerPlayerAct(Act{Player: turn.Player, Action: call, BetAmount: 40})
+				continue
+			}
+			switch turns {
+			case 0:
+				g.controller.registerPlayerAct(Act{Player: first, Action: call, BetAmount: 10})
+			case 1:
+				if p := g.controller.preAction(first); p != nil {
+					t.Errorf("%v still has pre-action %+v after a raise", first, *p)
+				}
+				g.controller.registerPlayerAct(Act{Player: first, Action: fold})
+			default:
+				t.Fatalf("%v was asked to act after folding", first)
+			}
+			turns++
+		}
+		g.controller.unlisten(updates)
+
+		actions := make([]string, 0)
+		for _, a := range g.controller.handHistories()[0].Actions {
+			if a.Player == first && a.Action != "small_blind" {
+				actions = append(actions, a.Street+" "+a.Action)
+			}
+		}
+		expected := []string{"PREFLOP call", "PREFLOP fold"}
+		if !reflect.DeepEqual(actions, expected) {
+			t.Errorf("%v took actions %v with pre-action %v queued, expected %v", first, actions, pre.Action, expected)
+		}
+	}
+}
+
+func TestBettingStructures(t *testing.T) {
+	// blinds of 10/20 are posted, and it is the third player's turn
+	blinds := func(betting string) (*Game, []*Player) {
+		rules := Rules{Betting: betting, MaxSeats: 3}.withDefaults()
+		g := NewGame(NewGameController(), 1, rules)
+		for _, id := range []guid{"player-1", "player-2", "player-3"} {
+			g.table.addPlayer(NewPlayer(id, rules.BuyIn), rules.MaxSeats)
+		}
+		g.pot = newPot()
+		g.history = newHandHistory(g)
+		g.betBlinds()
+		return g, g.table
+	}
+	limits := func(g *Game, p *Player, min, max money) {
+		if gotMin, gotMax := g.betLimits(p); gotMin != min || gotMax != max {
+			t.Errorf("%v: got bets from %v to %v, expected %v to %v", g.rules.Betting, gotMin, gotMax, min, max)
+		}
+		if g.betInvalid(p, max) || (max < p.wealth && !g.betInvalid(p, max+1)) {
+			t.Errorf("%v: expected %v to be the largest valid bet", g.rules.Betting, max)
+		}
+	}
+
+	g, players := blinds(noLimit)
+	limits(g, players[2], 40, 10000)
+	if !g.betInvalid(players[2], 30) {
+		t.Errorf("no_limit: raise of 10 is valid, expected raises of at least 20")
+	}
+	// a player with 30 facing 20 may go all in for a raise of 10
+	players[2].wealth = 30
+	limits(g, players[2], 30, 30)
+
+	// the pot of 30 plus the call of 20 lets the pot be raised by 50
+	g, players = blinds(potLimit)
+	limits(g, players[2], 40, 70)
+
+	// facing a pot-sized raise to 70, the small blind calls 60 and may
+	// raise by the pot of 160 that makes
+	g.pot.commitBet(players[2], 70)
+	limits(g, players[0], 110, 220)
+
+	g, players = blinds(fixedLimit)
+	limits(g, players[2], 40, 40)
+	if !g.betInvalid(players[2], 30) {
+		t.Errorf("fixed_limit: raise of 10 is valid, expected only raises of 20")
+	}
+	g.pot.commitBet(players[2], 40)
+	g.pot.commitBet(players[0], 50)
+	g.pot.commitBet(players[1], 60)
+	// the big blind, a raise and two reraises reach the cap
+	limits(g, players[2], 40, 40)
+	g.round = 2
+	g.pot.newRound()
+	limits(g, players[0], 40, 40)
+
+	// in stud, the player after the bring-in of 10 may only complete it
+	// to 20, which is the first bet, and the next may raise that by 20
+	rules := Rules{Variant: stud, MaxSeats: 3}.withDefaults()
+	g = NewGame(NewGameController(), 1, rules)
+	for _, id := range []guid{"player-1", "player-2", "player-3"} {
+		g.table.addPlayer(NewPlayer(id, rules.BuyIn), rules.MaxSeats)
+	}
+	g.pot = newPot()
+	g.history = newHandHistory(g)
+	g.deal()
+	g.betBringIn()
+	next := g.table[g.studFirstToAct()]
+	limits(g, next, 20, 20)
+	if !g.betInvalid(next, 30) {
+		t.Errorf("fixed_limit: raise of the bring-in to 30 is valid, expected only a completion to 20")
+	}
+	g.pot.commitBet(next, 20)
+	for _, p := range g.table {
+		if p != next && p != g.bringer {
+			limits(g, p, 40, 40)
+		}
+	}
+}
+
+func TestSitAndGo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pokerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rules := Rules{BuyIn: 300, MaxSeats: 3, Tournament: &TournamentRules{HandsPerLevel: 2}}.withDefaults()
+	if err := rules.validate(); err != nil {
+		t.Fatalf("rules %+v do not validate: %v", rules, err)
+	}
+	gc := NewGameController()
+	gc.logDir = dir
+	pg := gc.makeGame(11, mathShuffle, rules)
+	g := gc.Games[guid(pg.GameID)]
+	for _, id := range []guid{"player-1", "player-2", "player-3"} {
+		if err := g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn)); err != nil {
+			t.Fatalf("could not register %v: %v", id, err)
+		}
+	}
+	if err := g.controller.enqueuePlayer(g, NewPlayer("player-4", g.rules.BuyIn)); err != errTournamentFull {
+		t.Errorf("registering a fourth player at three seats: got %v, expected errTournamentFull", err)
+	}
+
+	playUntil(t, g, func() bool { return g.controller.publicGame().Tournament.Finished })
+	status := g.controller.publicGame().Tournament
+	if len(status.Standings) != 3 {
+		t.Fatalf("got standings %+v, expected 3 places", status.Standings)
+	}
+	for i, s := range status.Standings {
+		if s.Place != i+1 {
+			t.Errorf("got place %v at position %v of standings", s.Place, i)
+		}
+	}
+	if status.Standings[0].Payout != 100 {
+		t.Errorf("winner is paid %v percent, expected 100", status.Standings[0].Payout)
+	}
+	if len(g.table) != 1 || g.table[0].guid != status.Standings[0].Player || g.table[0].wealth != 900 {
+		t.Errorf("expected the winner to hold all 900 chips")
+	}
+	hands := g.controller.handHistories()
+	if last := hands[len(hands)-1]; last.Level < 2 {
+		t.Errorf("blinds never went up in %v hands", len(hands))
+	}
+
+	gameLog, err := readGameLog(filepath.Join(dir, pg.GameID+".log"))
+	if err != nil {
+		t.Fatalf("could not read game log: %v", err)
+	}
+	replayed, err := Replay(gameLog)
+	if err != nil {
+		t.Fatalf("could not replay game: %v", err)
+	}
+	if !replayed.over() || !reflect.DeepEqual(replayed.tournament.status(), status) {
+		t.Errorf("replay finished with standings %+v, expected %+v", replayed.tournament.status().Standings, status.Standings)
+	}
+}
+
+func TestMultiTableTournament(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pokerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rules := MultiTableRules{Rules: Rules{BuyIn: 300, MaxSeats: 3, Tournament: &TournamentRules{HandsPerLevel: 3}}, Entrants: 7}.withDefaults()
+	if err := rules.validate(); err != nil {
+		t.Fatalf("rules %+v do not validate: %v", rules, err)
+	}
+	gc := NewGameController()
+	gc.logDir = dir
+	m := gc.makeTournament(rules, mathShuffle)
+	for i := 1; i <= 7; i++ {
+		if err := m.register(gc, guid("player-"+strconv.Itoa(i))); err != nil {
+			t.Fatalf("could not register player %v: %v", i, err)
+		}
+	}
+	if err := m.register(gc, "player-8"); err != errTournamentFull {
+		t.Errorf("registering an eighth player for 7 entrants: got %v, expected errTournamentFull", err)
+	}
+	status := m.status()
+	if !status.Started || len(status.Tables) != 3 {
+		t.Fatalf("expected 7 entrants to start at 3 tables, got %+v", status.Tables)
+	}
+	for _, table := range status.Tables {
+		if len(table.Players) < 2 || len(table.Players) > 3 {
+			t.Errorf("table %v starts with %v players", table.GameID, len(table.Players))
+		}
+	}
+
+	tables := make([]*Game, 0)
+	for _, table := range status.Tables {
+		tables = append(tables, gc.Games[table.GameID])
+	}
+	done := make(chan struct{})
+	for _, g := range tables {
+		go playBot(g, done)
+	}
+	deadline := time.After(10 * time.Second)
+	for !m.status().Finished {
+		select {
+		case <-deadline:
+			close(done)
+			t.Fatalf("tournament did not finish: %+v", m.status())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	close(done)
+
+	status = m.status()
+	if len(status.Standings) != 7 || len(status.Tables) != 1 || status.Remaining != 1 {
+		t.Fatalf("expected 7 places and a final table with a winner, got %+v", status)
+	}
+	payouts := []int{50, 30, 20, 0, 0, 0, 0}
+	for i, s := range status.Standings {
+		if s.Place != i+1 || s.Payout != payouts[i] {
+			t.Errorf("got %+v at position %v of standings, expected place %v paying %v", s, i, i+1, payouts[i])
+		}
+	}
+	// each table publishes its last state once it is over
+	for _, g := range tables {
+		waitUntil(t, "table "+string(g.gameID)+" to finish", func() bool {
+			pg := g.controller.publicGame()
+			return pg.Tournament != nil && pg.Tournament.Finished
+		})
+	}
+	final := gc.Games[status.Tables[0].GameID]
+	if len(final.table) != 1 || final.table[0].guid != status.Standings[0].Player || final.table[0].wealth != 2100 {
+		t.Errorf("expected the winner to hold all 2100 chips at the final table")
+	}
+	for _, g := range tables {
+		if !g.over() {
+			t.Errorf("table %v is still playing", g.gameID)
+		}
+		gameLog, err := readGameLog(filepath.Join(dir, string(g.gameID)+".log"))
+		if err != nil {
+			t.Fatalf("could not read log of table %v: %v", g.gameID, err)
+		}
+		replayed, err := Replay(gameLog)
+		if err != nil {
+			t.Errorf("could not replay table %v: %v", g.gameID, err)
+			continue
+		}
+		if len(replayed.table) != len(g.table) {
+			t.Errorf("table %v replayed to %v players, expected %v", g.gameID, len(replayed.table), len(g.table))
+		}
+	}
+}
+
+func TestMultiTableBalancing(t *testing.T) {
+	rules := MultiTableRules{Rules: Rules{BuyIn: 100, MaxSeats: 4, Tournament: &TournamentRules{HandsPerLevel: 3}}, Entrants: 12}.withDefaults()
+	// tables seats players at three tables, without starting them, and
+	// returns the tables and the busted players of each
+	tables := func(sizes ...int) (*MultiTable, []*Game) {
+		gc := NewGameController()
+		m := gc.makeTournament(rules, mathShuffle)
+		games := make([]*Game, 0)
+		for i, n := range sizes {
+			g := NewGame(gc, 1, rules.Rules)
+			g.tournament.mtt = m
+			for j := 0; j < n; j++ {
+				p := NewPlayer(guid(fmt.Sprintf("player-%v-%v", i, j)), rules.BuyIn)
+				g.table = append(g.table, p)
+				m.registered = append(m.registered, p)
+				m.seats[p.guid] = g
+			}
+			m.tables = append(m.tables, g)
+			games = append(games, g)
+		}
+		return m, games
+	}
+	bust := func(g *Game, n int) []guid {
+		busted := make([]guid, 0)
+		for _, p := range g.table[:n] {
+			p.wealth = 0
+			busted = append(busted, p.guid)
+		}
+		return busted
+	}
+	counts := func(m *MultiTable, games []*Game) []int {
+		n := make([]int, len(games))
+		for i, g := range games {
+			n[i] = m.count(g)
+		}
+		return n
+	}
+
+	// a table two short of the others gets a player from the next to
+	// finish a hand
+	m, games := tables(4, 4, 4)
+	m.handOver(games[0], bust(games[0], 2))
+	if got := counts(m, games); !reflect.DeepEqual(got, []int{2, 4, 4}) {
+		t.Errorf("after two busted at the first table, got tables of %v, expected 2, 4 and 4", got)
+	}
+	m.handOver(games[1], nil)
+	if got := counts(m, games); !reflect.DeepEqual(got, []int{3, 3, 4}) {
+		t.Errorf("after the second table's hand, got tables of %v, expected 3, 3 and 4", got)
+	}
+	if len(games[1].table) != 3 || len(games[0].controller.waiting) != 1 {
+		t.Errorf("the moved player wasn't taken from the second table and sent to the first")
+	}
+	m.handOver(games[2], nil)
+	if got := counts(m, games); !reflect.DeepEqual(got, []int{3, 3, 4}) {
+		t.Errorf("after the third table's hand, got tables of %v, expected no one moved from a table one over", got)
+	}
+
+	// a table whose players fit at the others is broken up
+	m, games = tables(4, 3, 3)
+	m.handOver(games[1], bust(games[1], 2))
+	if got := counts(m, games); !reflect.DeepEqual(got, []int{4, 0, 4}) {
+		t.Errorf("after two busted at the second table, got tables of %v, expected it broken into the smallest", got)
+	}
+	if len(m.tables) != 2 || !games[1].over() {
+		t.Errorf("the broken table is still playing")
+	}
+	if len(m.standings) != 2 || m.standings[0].Place != 10 || m.standings[1].Place != 9 {
+		t.Errorf("got standings %+v, expected the busted players placed 10th and 9th", m.standings)
+	}
+
+	// a table broken while a player is on their way to it sends them on
+	m, games = tables(4, 4, 4)
+	m.handOver(games[0], bust(games[0], 2))
+	m.handOver(games[1], nil)
+	games[0].table = games[0].table[2:]
+	m.handOver(games[0], bust(games[0], 2))
+	if got := counts(m, games); !reflect.DeepEqual(got, []int{0, 4, 4}) {
+		t.Errorf("after the first table was broken with a player on the way, got tables of %v, expected 0, 4 and 4", got)
+	}
+	if len(games[0].controller.waiting) != 0 || len(games[1].controller.waiting) != 1 {
+		t.Errorf("the player on their way to the broken table wasn't sent on to the second")
+	}
+}
+
+// playBot plays every seat at g, as the bot of playHands does, until done
+// is closed. g may already be waiting on a player when the bot starts.
+func playBot(g *Game, done chan struct{}) {
+	updates := g.controller.listen()
+	defer g.controller.unlisten(updates)
+	for i := 0; ; i++ {
+		botAct(g, i)
+		select {
+		case <-updates:
+		case <-done:
+			return
+		}
+	}
+}
+
+// playHands plays g until it has finished n hands, with a bot that calls,
+// raises and folds in turn for every player.
+func playHands(t *testing.T, g *Game, n int) {
+	playUntil(t, g, func() bool { return len(g.controller.handHistories()) >= n })
+}
+
+// playUntil plays g with the bot of playHands until done returns true.
+func playUntil(t *testing.T, g *Game, done func() bool) {
+	updates := g.controller.listen()
+	defer g.controller.unlisten(updates)
+	for i := 0; !done(); i++ {
+		select {
+		case <-updates:
+		case <-time.After(time.Second):
+			t.Fatalf("game stopped asking for bets after %v hands", len(g.controller.handHistories()))
+		}
+		botAct(g, i)
+	}
+}
+
+// botAct makes the i'th act of a bot that calls, raises and folds in turn,
+// if it is anyone's turn at g.
+func botAct(g *Game, i int) {
+	turn := g.controller.turn()
+	if turn.Player == "" {
+		return
+	}
+	act := Act{Player: turn.Player, Action: call, BetAmount: turn.BetToPlayer - turn.PlayerBet}
+	switch i % 7 {
+	case 3:
+		if turn.MinRaise > g.rules.BigBlind {
+			act.BetAmount += turn.MinRaise
+		} else {
+			act.BetAmount += g.rules.BigBlind
+		}
+	case 5:
+		act.Action = fold
+	}
+	for _, p := range g.controller.publicGame().Table {
+		if p.GUID == turn.Player && p.Wealth < act.BetAmount {
+			act.BetAmount = p.Wealth
+		}
+	}
+	g.controller.registerPlayerAct(act)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// GameLog is everything needed to replay a game: its seed and rules, and
+// every input the game received from its players, in order.
+type GameLog struct {
+	GameID  guid   `json:"gameID"`
+	Seed    int64  `json:"seed"`
+	Rules   Rules  `json:"rules"`
+	Shuffle string `json:"shuffle"`
+	// Tournament is the ID of the multi-table tournament the game is a
+	// table of, if it is one.
+	Tournament guid       `json:"tournament,omitempty"`
+	Events     []LogEvent `json:"-"`
+	// size is the length of the log read, up to the end of its last
+	// complete event.
+	size int64
+}
+
+// LogEvent is a single input to a game. Type is "join" when a player was
+// seated with Stack before hand Hand, "leave" when a player left or was
+// moved to another table after it, "sit_out" and "sit_in" when a player
+// sat out or back in from it, "level" when a tournament's blinds went up to
+// Level for it, "straddle" when a player straddled it, "run_it_twice" when
+// a player all in agreed to run its board twice, "shuffle" when the
+// hand's seed was drawn from crypto/rand, "act" when a player acted during
+// it, "timeout" when the game gave up waiting for a player to act, "muck"
+// when a player mucked at its showdown, "show" when a player showed their
+// hand after winning it without one, and "snapshot" when the game was
+// snapshotted after it, reseeding its random number generator. Shows come
+// whenever the player asks, so may be logged during the next hand.
+type LogEvent struct {
+	Hand      int    `json:"hand"`
+	Type      string `json:"type"`
+	Player    guid   `json:"playerID,omitempty"`
+	Action    int    `json:"action,omitempty"`
+	BetAmount money  `json:"betAmount,omitempty"`
+	Seed      string `json:"seed,omitempty"`
+	Level     int    `json:"level,omitempty"`
+	Stack     money  `json:"stack,omitempty"`
+}
+
+// eventLog appends a game's log to a file, one JSON document per line:
+// the GameLog first, then each LogEvent as it happens. Each event is
+// synced to disk before the game goes on, so nothing the game has acted on
+// is lost in a crash. events counts the events in the file. The log is
+// locked while an event is written, since shows are logged by the
+// requests that ask for them while the game goes on.
+type eventLog struct {
+	f      *os.File
+	enc    *json.Encoder
+	path   string
+	events int
+	sync.Mutex
+}
+
+// createEventLog starts a new log for g at path.
+func createEventLog(path string, g *Game) (*eventLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &eventLog{f: f, enc: json.NewEncoder(f), path: path}
+	header := GameLog{GameID: g.gameID, Seed: g.seed, Rules: g.rules, Shuffle: g.shuffle}
+	if g.tournament != nil {
+		header.Tournament = g.tournament.multiTable
+	}
+	err = l.enc.Encode(header)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// openEventLog reopens the log read from path to go on appending to it,
+// first cutting off anything after the last event read.
+func openEventLog(path string, log *GameLog) (*eventLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = f.Truncate(log.size)
+	if err == nil {
+		_, err = f.Seek(log.size, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &eventLog{f: f, enc: json.NewEncoder(f), path: path, events: len(log.Events)}, nil
+}
+
+// record appends e to the log, and returns the number of events in it
+// with e.
+func (l *eventLog) record(e LogEvent) (events int, err error) {
+	l.Lock()
+	defer l.Unlock()
+	err = l.enc.Encode(e)
+	if err != nil {
+		return l.events, err
+	}
+	l.events++
+	return l.events, l.f.Sync()
+}
+
+func (l *eventLog) close() error {
+	l.Lock()
+	defer l.Unlock()
+	return l.f.Close()
+}
+
+// readGameLog reads the log written at path. A partially written last
+// line, as left by a crash, is ignored.
+func readGameLog(path string) (*GameLog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	line, err := r.ReadBytes('\n')
+	if err == io.EOF {
+		return nil, fmt.Errorf("gamelog: %v is empty", path)
+	} else if err != nil {
+		return nil, err
+	}
+	log := new(GameLog)
+	err = json.Unmarshal(line, log)
+	if err != nil {
+		return nil, fmt.Errorf("gamelog: could not read header of %v: %v", path, err)
+	}
+	log.size = int64(len(line))
+	log.Events = make([]LogEvent, 0)
+	for {
+		line, err = r.ReadBytes('\n')
+		if err == io.EOF {
+			return log, nil
+		} else if err != nil {
+			return nil, err
+		}
+		var e LogEvent
+		if json.Unmarshal(line, &e) != nil {
+			return log, nil
+		}
+		log.Events = append(log.Events, e)
+		log.size += int64(len(line))
+	}
+}
+
+// replayer feeds a game the recorded events of a log instead of waiting on
+// players. When the game asks for something the log does not have, the
+// replayer halts: it answers as harmlessly as it can and sets halted,
+// which the game checks to abandon the hand it is playing. A resumed game
+// instead plays on live once the log runs out. shows are the log's show
+// events, kept back until the hands they are from have been replayed.
+type replayer struct {
+	events []LogEvent
+	shows  []LogEvent
+	buyIn  money
+	resume bool
+	// halted is set once the replay has stopped, with err the reason, or
+	// nil if the log simply ended.
+	halted bool
+	err    error
+	// resumed receives nil once a resumed game is playing live, or the
+	// reason it couldn't be.
+	resumed chan error
+}
+
+func newReplayer(events []LogEvent, buyIn money) *replayer {
+	r := &replayer{events: make([]LogEvent, 0), shows: make([]LogEvent, 0), buyIn: buyIn}
+	// shows don't change how the game is played, so are only shown once
+	// the hands they are from have been replayed
+	for _, e := range events {
+		if e.Type == "show" {
+			r.shows = append(r.shows, e)
+		} else {
+			r.events = append(r.events, e)
+		}
+	}
+	return r
+}
+
+// halt stops the replay, for the reason err, or because the log ended if
+// err is nil.
+func (r *replayer) halt(err error) {
+	if r.halted {
+		return
+	}
+	r.halted, r.err = true, err
+}
+
+// joins returns the players the log seated before hand.
+func (r *replayer) joins(hand int) (players []*Player) {
+	players = make([]*Player, 0)
+	for len(r.events) > 0 && r.events[0].Type == "join" && r.events[0].Hand == hand {
+		stack := r.events[0].Stack
+		if stack == 0 {
+			stack = r.buyIn
+		}
+		players = append(players, NewPlayer(r.events[0].Player, stack))
+		r.events = r.events[1:]
+	}
+	return players
+}
+
+// leaves returns the players the log moved to other tables after hand.
+func (r *replayer) leaves(hand int) (players []guid) {
+	players = make([]guid, 0)
+	for len(r.events) > 0 && r.events[0].Type == "leave" && r.events[0].Hand == hand {
+		players = append(players, r.events[0].Player)
+		r.events = r.events[1:]
+	}
+	return players
+}
+
+// sits returns the players the log has changing to sitting out, for typ
+// "sit_out", or sitting back in, for typ "sit_in", before hand.
+func (r *replayer) sits(hand int, typ string) (players []guid) {
+	players = make([]guid, 0)
+	for len(r.events) > 0 && r.events[0].Type == typ && r.events[0].Hand == hand {
+		players = append(players, r.events[0].Player)
+		r.events = r.events[1:]
+	}
+	return players
+}
+
+// level returns the tournament level recorded for hand, or current if the
+// level didn't change.
+func (r *replayer) level(hand, current int) int {
+	if len(r.events) == 0 || r.events[0].Type != "level" || r.events[0].Hand != hand {
+		return current
+	}
+	level := r.events[0].Level
+	r.events = r.events[1:]
+	return level
+}
+
+// straddle returns true if the log has player straddling hand.
+func (r *replayer) straddle(hand int, player guid) bool {
+	if len(r.events) == 0 || r.events[0].Type != "straddle" || r.events[0].Hand != hand || r.events[0].Player != player {
+		return false
+	}
+	r.events = r.events[1:]
+	return true
+}
+
+// runItTwice returns true if the log has player agreeing to run the board
+// of hand twice.
+func (r *replayer) runItTwice(hand int, player guid) bool {
+	if len(r.events) == 0 || r.events[0].Type != "run_it_twice" || r.events[0].Hand != hand || r.events[0].Player != player {
+		return false
+	}
+	r.events = r.events[1:]
+	return true
+}
+
+// muck returns true if the log has player mucking at the showdown of hand.
+func (r *replayer) muck(hand int, player guid) bool {
+	if len(r.events) == 0 || r.events[0].Type != "muck" || r.events[0].Hand != hand || r.events[0].Player != player {
+		return false
+	}
+	r.events = r.events[1:]
+	return true
+}
+
+// snapshot returns true if the log has the game snapshotted after hand.
+func (r *replayer) snapshot(hand int) bool {
+	if len(r.events) == 0 || r.events[0].Type != "snapshot" || r.events[0].Hand != hand {
+		return false
+	}
+	r.events = r.events[1:]
+	return true
+}
+
+// handSeed returns the recorded seed of hand. If the log doesn't have it,
+// the replay halts and an empty seed is returned.
+func (r *replayer) handSeed(hand int) []byte {
+	empty := make([]byte, 32)
+	if len(r.events) == 0 {
+		r.halt(nil)
+		return empty
+	}
+	e := r.events[0]
+	if e.Hand != hand || e.Type != "shuffle" {
+		r.halt(fmt.Errorf("replay: hand %v needed a seed, but the log has a %v by player %v in hand %v", hand, e.Type, e.Player, e.Hand))
+		return empty
+	}
+	r.events = r.events[1:]
+	seed, err := hex.DecodeString(e.Seed)
+	if err != nil {
+		r.halt(fmt.Errorf("replay: could not read seed of hand %v: %v", hand, err))
+		return empty
+	}
+	return seed
+}
+
+// act returns the recorded act of player wanted in hand. If the log doesn't
+// have it, the replay halts and a fold is returned.
+func (r *replayer) act(hand int, wanted guid) (int, money, error) {
+	if len(r.events) == 0 {
+		r.halt(nil)
+		return fold, 0, nil
+	}
+	e := r.events[0]
+	if e.Hand != hand || e.Player != wanted || (e.Type != "act" && e.Type != "timeout") {
+		r.halt(fmt.Errorf("replay: hand %v waited on player %v, but the log has a %v by player %v in hand %v", hand, wanted, e.Type, e.Player, e.Hand))
+		return fold, 0, nil
+	}
+	r.events = r.events[1:]
+	if e.Type == "timeout" {
+		return 0, 0, fmt.Errorf("controller: timed out waiting for bet from player %v", wanted)
+	}
+	return e.Action, e.BetAmount, nil
+}
+
+// Replay plays back a logged game and returns it. Every deal, pot and
+// payout is reproduced exactly, so the histories kept by the returned game
+// match those of the original. A hand the log ends partway through is left
+// out. An error is returned if the log does not fit the game it describes.
+func Replay(log *GameLog) (*Game, error) {
+	g := newLoggedGame(NewGameController(), log)
+	r := newReplayer(log.Events, log.Rules.BuyIn)
+	g.controller.replay = r
+	for len(r.events) > 0 && g.playHand() {
+	}
+	g.controller.replayShows(r.shows)
+	return g, r.err
+}
+
+// newLoggedGame returns the game log describes, before any of its events.
+func newLoggedGame(gc *GameController, log *GameLog) *Game {
+	g := NewGame(gc, log.Seed, log.Rules)
+	g.gameID = log.GameID
+	g.shuffle = log.Shuffle
+	if g.tournament != nil {
+		g.tournament.multiTable = log.Tournament
+	}
+	return g
+}
@@ -0,0 +1,9 @@
+module github.com/bcgraham/pokerserver
+
+go 1.22
+
+require (
+	github.com/gorilla/mux v1.8.1
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/crypto v0.31.0
+)
@@ -1,13 +1,56 @@
 package main
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
-// "fmt"
-
-type Hand []string
+type Hand []Card
 
 var RANKS string = "--23456789TJQKA"
 
+// The kinds of five-card hand, lowest first in standard ranking.
+const (
+	highCard uint = iota
+	onePair
+	twoPair
+	threeOfAKind
+	straight
+	flush
+	fullHouse
+	fourOfAKind
+	straightFlush
+)
+
+// handRanking is the order a game ranks the kinds of hand in, and the
+// straight an ace plays low in.
+type handRanking struct {
+	// order lists the kinds of hand, lowest first.
+	order []uint
+	// wheel is the ranks of the ace-low straight, ace high, and low the
+	// same ranks with the ace counting below the rest.
+	wheel, low []int
+	// tables are the ranking's lookup tables, built when the server starts.
+	tables *evalTables
+}
+
+// standardRanking is the usual ranking of poker hands, with A-2-3-4-5 the
+// lowest straight.
+var standardRanking = handRanking{
+	order: []uint{highCard, onePair, twoPair, threeOfAKind, straight, flush, fullHouse, fourOfAKind, straightFlush},
+	wheel: []int{14, 5, 4, 3, 2},
+	low:   []int{5, 4, 3, 2, 1},
+}
+
+// shortDeckRanking ranks hands made from a deck without twos to fives. A
+// flush, which is harder to make than a full house with fewer cards in
+// each suit, beats one, and A-6-7-8-9 is the lowest straight.
+var shortDeckRanking = handRanking{
+	order: []uint{highCard, onePair, twoPair, threeOfAKind, straight, fullHouse, flush, fourOfAKind, straightFlush},
+	wheel: []int{14, 9, 8, 7, 6},
+	low:   []int{9, 8, 7, 6, 5},
+}
+
 // findWinners returns the slice of winning players.
 func (g *Game) findWinners(players []*Player) []*Player {
 	if len(players) == 0 {
@@ -24,7 +67,7 @@ func (g *Game) findWinners(players []*Player) []*Player {
 		hands = append(hands, p.bestHand)
 	}
 
-	winningHands := findWinningHands(hands)
+	winningHands := findWinningHands(hands, g.variant().ranking())
 
 	winners := make([]*Player, 0)
 	for _, p := range players {
@@ -45,7 +88,7 @@ func (g *Game) findWinners(players []*Player) []*Player {
 }
 
 //Returns nil if hands is empty
-func findWinningHands(hands []Hand) (winners []Hand) {
+func findWinningHands(hands []Hand, ranking handRanking) (winners []Hand) {
 	if len(hands) == 0 {
 		return nil
 	} else if len(hands) == 1 {
@@ -53,21 +96,61 @@ func findWinningHands(hands []Hand) (winners []Hand) {
 	}
 
 	winners = append(make([]Hand, 0), hands[0])
-	max_hand, max_count := winners[0].handRank()
+	max_value := winners[0].value(ranking)
 	for _, h := range hands[1:] {
-		test_hand, test_count := h.handRank()
-		if test_hand == max_hand && isEq(test_count, max_count) {
+		test_value := h.value(ranking)
+		if test_value == max_value {
 			//The hands are equal
 			winners = append(winners, h)
-		} else if (test_hand > max_hand) ||
-			((test_hand == max_hand) && gt(test_count, max_count)) {
-			//test_hand > max_hand
+		} else if test_value > max_value {
 			winners = append(make([]Hand, 0), h)
-			max_hand, max_count = h.handRank()
-		} else if (test_hand < max_hand) ||
-			((test_hand == max_hand) && less(test_count, max_count)) {
-			//test_hand < max_hand
+			max_value = test_value
+		}
+	}
+	return winners
+}
+
+// findLowWinners returns the players in a hi-lo game with the best
+// eight-or-better low, or none if nobody has one.
+func (g *Game) findLowWinners(players []*Player) []*Player {
+	lows := make([]Hand, 0)
+	for _, p := range players {
+		if p.state != folded && p.bestLow != nil {
+			lows = append(lows, p.bestLow)
+		}
+	}
+
+	winningLows := findLowHands(lows)
+
+	winners := make([]*Player, 0)
+	for _, p := range players {
+		for _, h := range winningLows {
+			if p.state != folded && areHandsEq(p.bestLow, h) {
+				winners = append(winners, p)
+				break
+			}
+		}
+	}
+	if len(winners) > 0 {
+		g.controller.recordLowWinners(winners)
+	}
+	return winners
+}
+
+// findLowHands returns the best eight-or-better lows among hands. Returns
+// nil if none of them qualifies.
+func findLowHands(hands []Hand) (winners []Hand) {
+	var best []int
+	for _, h := range hands {
+		ranks, ok := h.lowRank()
+		switch {
+		case !ok:
 			continue
+		case best == nil || less(ranks, best):
+			best = ranks
+			winners = append(make([]Hand, 0), h)
+		case isEq(ranks, best):
+			winners = append(winners, h)
 		}
 	}
 	return winners
@@ -115,14 +198,10 @@ func isEq(a, b []int) bool {
 	return true
 }
 
-func (h Hand) rawRanksAndSuits() (ranks [5]int, suits [5]rune) {
+func (h Hand) rawRanksAndSuits() (ranks [5]int, suits [5]int) {
 	for i, card := range h {
-		numeral := string(card[0])
-		ranks[i] = strings.Index(RANKS, numeral)
-		if ranks[i] == -1 {
-			panic("can't find card")
-		}
-		suits[i] = rune(card[1])
+		ranks[i] = card.rank()
+		suits[i] = card.suit()
 	}
 	return ranks, suits
 }
@@ -158,7 +237,7 @@ func isStraight(countRanks []int) bool {
 	return true
 }
 
-func isFlush(suits [5]rune) bool {
+func isFlush(suits [5]int) bool {
 	suit := suits[0]
 	for _, v := range suits[1:] {
 		if suit != v {
@@ -171,42 +250,80 @@ func isFlush(suits [5]rune) bool {
 //Returns the ranking for the hand and the card rankings
 //'7 T 7 9 7' => handRank = 3 and cardRanks = [7, 10, 9]
 // cardRanks is ordered by count first, then by card rank
-func (h Hand) handRank() (handRank uint, countRanks []int) {
+// handRank is the kind of hand's place in ranking's order
+// It is too slow to rank every hand with; Hand.value looks up the same
+// ranking in tables built from it.
+func (h Hand) handRank(ranking handRanking) (handRank uint, countRanks []int) {
+	kind, countRanks := h.kind(ranking)
+	for i, k := range ranking.order {
+		if k == kind {
+			return uint(i), countRanks
+		}
+	}
+	panic("kind of hand missing from ranking")
+}
+
+// kind returns the kind of hand h is, and its card rankings as for handRank.
+func (h Hand) kind(ranking handRanking) (kind uint, countRanks []int) {
 	rawRanks, suits := h.rawRanksAndSuits()
 	counts, countRanks := countAndCombine(rawRanks)
-	if isEq(countRanks, []int{14, 5, 4, 3, 2}) {
+	if isEq(countRanks, ranking.wheel) {
 		//Ace low straight
-		countRanks = []int{5, 4, 3, 2, 1}
+		countRanks = append([]int{}, ranking.low...)
 	}
-	straight := isStraight(countRanks)
-	flush := isFlush(suits)
+	isStraight := isStraight(countRanks)
+	isFlush := isFlush(suits)
 
 	switch {
-	case straight && flush:
-		handRank = 8
+	case isStraight && isFlush:
+		kind = straightFlush
 	case isEq(counts, []int{4, 1}):
-		handRank = 7
+		kind = fourOfAKind
 	case isEq(counts, []int{3, 2}):
-		handRank = 6
-	case flush:
-		handRank = 5
-	case straight:
-		handRank = 4
+		kind = fullHouse
+	case isFlush:
+		kind = flush
+	case isStraight:
+		kind = straight
 	case isEq(counts, []int{3, 1, 1}):
-		handRank = 3
+		kind = threeOfAKind
 	case isEq(counts, []int{2, 2, 1}):
-		handRank = 2
+		kind = twoPair
 	case isEq(counts, []int{2, 1, 1, 1}):
-		handRank = 1
+		kind = onePair
 	default:
 		if !isEq(counts, []int{1, 1, 1, 1, 1}) {
 			panic("Either have 5 of a kind, or missed a straight and/or a flush")
 		}
-		handRank = 0
+		kind = highCard
 	}
 
-	return handRank, countRanks
+	return kind, countRanks
+}
 
+//Returns the card rankings of the hand as a low, highest first, with aces
+//counting as 1, and whether the hand qualifies as an eight-or-better low:
+//five different ranks, none higher than eight. Straights and flushes
+//don't count against a low.
+//'5 A 3 8 2' => lowRanks = [8, 5, 3, 2, 1], qualifies = true
+func (h Hand) lowRank() (lowRanks []int, qualifies bool) {
+	rawRanks, _ := h.rawRanksAndSuits()
+	var seen [9]bool
+	for _, n := range rawRanks {
+		if n == 14 {
+			n = 1
+		}
+		if n > 8 || seen[n] {
+			return nil, false
+		}
+		seen[n] = true
+	}
+	for n := 8; n >= 1; n-- {
+		if seen[n] {
+			lowRanks = append(lowRanks, n)
+		}
+	}
+	return lowRanks, true
 }
 
 func areHandsEq(a, b Hand) bool {
@@ -220,3 +337,70 @@ func areHandsEq(a, b Hand) bool {
 	}
 	return true
 }
+
+// lowestRank returns the lowest card ranking of straight h, whose card
+// rankings are r: an ace if it's an ace-low straight, however low the
+// rest of the deck goes.
+func (h Hand) lowestRank(r []int) int {
+	if r[0] == 14 {
+		return r[4]
+	}
+	for _, card := range h {
+		if card.rank() == 14 {
+			return 1
+		}
+	}
+	return r[4]
+}
+
+var rankNames = []string{"", "Ace", "Deuce", "Three", "Four", "Five", "Six", "Seven", "Eight", "Nine", "Ten", "Jack", "Queen", "King", "Ace"}
+
+// plural returns the plural name of a card rank, e.g. 6 => "Sixes"
+func plural(rank int) string {
+	if rank == 6 {
+		return "Sixes"
+	}
+	return rankNames[rank] + "s"
+}
+
+// describeLow returns the hand as a low, highest card first, e.g. "8,5,3,2,A"
+func (h Hand) describeLow() string {
+	r, _ := h.lowRank()
+	names := make([]string, 0)
+	for _, n := range r {
+		if n == 1 {
+			n = 14
+		}
+		names = append(names, string(RANKS[n]))
+	}
+	return strings.Join(names, ",")
+}
+
+// describe returns a description of the hand in words,
+// e.g. "a full house, Tens full of Sevens"
+func (h Hand) describe(ranking handRanking) string {
+	kind, r := h.kind(ranking)
+	switch kind {
+	case straightFlush:
+		if r[0] == 14 {
+			return "a Royal Flush"
+		}
+		return fmt.Sprintf("a straight flush, %v to %v", rankNames[h.lowestRank(r)], rankNames[r[0]])
+	case fourOfAKind:
+		return fmt.Sprintf("four of a kind, %v", plural(r[0]))
+	case fullHouse:
+		return fmt.Sprintf("a full house, %v full of %v", plural(r[0]), plural(r[1]))
+	case flush:
+		return fmt.Sprintf("a flush, %v high", rankNames[r[0]])
+	case straight:
+		return fmt.Sprintf("a straight, %v to %v", rankNames[h.lowestRank(r)], rankNames[r[0]])
+	case threeOfAKind:
+		return fmt.Sprintf("three of a kind, %v", plural(r[0]))
+	case twoPair:
+		return fmt.Sprintf("two pair, %v and %v", plural(r[0]), plural(r[1]))
+	case onePair:
+		return fmt.Sprintf("a pair of %v", plural(r[0]))
+	default:
+		return fmt.Sprintf("high card %v", rankNames[r[0]])
+	}
+}
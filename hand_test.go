@@ -1,36 +1,35 @@
 package main
 
 import (
-	"strings"
 	"testing"
 )
 
 //Hands - No Ties
-var sf1 Hand = strings.Fields("TD JD QD KD AD") // Straight Flush
-var sf2 Hand = strings.Fields("6C 7C 8C 9C TC") // Straight Flush
-var fk1 Hand = strings.Fields("9D 9H 9S 9C 7D") // Four of a Kind
-var fk2 Hand = strings.Fields("9D 9H 9S 9C 3D") // Four of a Kind
-var fk3 Hand = strings.Fields("4D 4H 4S 4C AC") // Four of a Kind
-var fh1 Hand = strings.Fields("TD TC TH 7C 7D") // Full House
-var fh2 Hand = strings.Fields("TD TC TH 4C 4D") // Full House
-var fh3 Hand = strings.Fields("9D 9C 9H 7C 7D") // Full House
-var fl1 Hand = strings.Fields("TD AD 6D 7D 9D") // Flush
-var fl2 Hand = strings.Fields("TC AC 6C 4C 9C") // Flush
-var fl3 Hand = strings.Fields("TH KH 6H 7H 9H") // Flush
-var st1 Hand = strings.Fields("TC JC QC KS AS") // 10-A straight
-var st2 Hand = strings.Fields("2C 3C 4C 5S 6S") // 2-6 straight
-var st3 Hand = strings.Fields("AS 2S 3S 4S 5C") // A-5 straight
-var tk1 Hand = strings.Fields("TS TD TC JC 2S") // Three of a kind
-var tk2 Hand = strings.Fields("5S 5D 5C 9C 6S") // Three of a kind
-var tp1 Hand = strings.Fields("5C 5H TH TC 2S") // two pair
-var tp2 Hand = strings.Fields("5S 5D 9H 9C AS") // two pair
-var tp3 Hand = strings.Fields("4S 4D 2H 2C KS") // two pair
-var pr1 Hand = strings.Fields("2S 3C JD 4S 4H") // pair
-var pr2 Hand = strings.Fields("2S 3C AD TS TC") // pair
-var pr3 Hand = strings.Fields("2S 3C KD QS QC") // pair
-var ah1 Hand = strings.Fields("AS 2S 3S 4S 6C") // A high
-var sh1 Hand = strings.Fields("2S 3S 4S 6C 8D") // 8 high
-var sh2 Hand = strings.Fields("2S 3S 4S 6C 7D") // 7 high
+var sf1 Hand = mustParseCards("TD JD QD KD AD") // Straight Flush
+var sf2 Hand = mustParseCards("6C 7C 8C 9C TC") // Straight Flush
+var fk1 Hand = mustParseCards("9D 9H 9S 9C 7D") // Four of a Kind
+var fk2 Hand = mustParseCards("9D 9H 9S 9C 3D") // Four of a Kind
+var fk3 Hand = mustParseCards("4D 4H 4S 4C AC") // Four of a Kind
+var fh1 Hand = mustParseCards("TD TC TH 7C 7D") // Full House
+var fh2 Hand = mustParseCards("TD TC TH 4C 4D") // Full House
+var fh3 Hand = mustParseCards("9D 9C 9H 7C 7D") // Full House
+var fl1 Hand = mustParseCards("TD AD 6D 7D 9D") // Flush
+var fl2 Hand = mustParseCards("TC AC 6C 4C 9C") // Flush
+var fl3 Hand = mustParseCards("TH KH 6H 7H 9H") // Flush
+var st1 Hand = mustParseCards("TC JC QC KS AS") // 10-A straight
+var st2 Hand = mustParseCards("2C 3C 4C 5S 6S") // 2-6 straight
+var st3 Hand = mustParseCards("AS 2S 3S 4S 5C") // A-5 straight
+var tk1 Hand = mustParseCards("TS TD TC JC 2S") // Three of a kind
+var tk2 Hand = mustParseCards("5S 5D 5C 9C 6S") // Three of a kind
+var tp1 Hand = mustParseCards("5C 5H TH TC 2S") // two pair
+var tp2 Hand = mustParseCards("5S 5D 9H 9C AS") // two pair
+var tp3 Hand = mustParseCards("4S 4D 2H 2C KS") // two pair
+var pr1 Hand = mustParseCards("2S 3C JD 4S 4H") // pair
+var pr2 Hand = mustParseCards("2S 3C AD TS TC") // pair
+var pr3 Hand = mustParseCards("2S 3C KD QS QC") // pair
+var ah1 Hand = mustParseCards("AS 2S 3S 4S 6C") // A high
+var sh1 Hand = mustParseCards("2S 3S 4S 6C 8D") // 8 high
+var sh2 Hand = mustParseCards("2S 3S 4S 6C 7D") // 7 high
 
 //Sorted highest ranking to lowest ranking
 var allHands []Hand = []Hand{sf1, sf2, fk1, fk2, fk3, fh1, fh2, fh3, fl1, fl2, fl3, st1, st2, st3, tk1, tk2, tp1, tp2, tp3, ah1, sh1, sh2}
@@ -44,7 +43,7 @@ func TestFindWinningHand(t *testing.T) {
 		for q := i + 1; q < len(allHands)-1; q++ {
 			for n := q + 1; n < len(allHands); n++ {
 				testGame := append(make([]Hand, 0), allHands[i], allHands[q], allHands[n])
-				winners := findWinningHands(testGame)
+				winners := findWinningHands(testGame, standardRanking)
 				if len(winners) != 1 {
 					t.Errorf("too many winners")
 				}
@@ -57,28 +56,113 @@ func TestFindWinningHand(t *testing.T) {
 	}
 
 	testGame := append(make([]Hand, 0), allHands[10])
-	winners := findWinningHands(testGame)
+	winners := findWinningHands(testGame, standardRanking)
 	if len(winners) != 1 && !areHandsEq(winners[0], allHands[10]) {
 		t.Errorf("Incorrect number of winners. Incorrect winner")
 	}
 
 	//Test Tie manually
 	testGame = append(make([]Hand, 0), allHands[10], allHands[8], allHands[8])
-	winners = findWinningHands(testGame)
+	winners = findWinningHands(testGame, standardRanking)
 	if len(winners) != 2 && !areHandsEq(winners[0], allHands[8]) && !areHandsEq(winners[0], allHands[8]) {
 		t.Errorf("%v are the winner, should be %v tied. Winners len=%v",
 			winners, allHands[3], len(winners))
 	}
 }
 
+func TestOmahaHands(t *testing.T) {
+	// hold'em would play the ace of spades with the four spades on the
+	// board, but Omaha must use exactly two hole cards and three board cards
+	hole := mustParseCards("AS AD AH 3C")
+	board := mustParseCards("QS JS TS 9S 2C")
+	hands := omahaVariant{}.hands(hole, board)
+	if len(hands) != 60 {
+		t.Errorf("got %v Omaha hands, expected 6 * 10 = 60", len(hands))
+	}
+	if best := bestHand(hands, standardRanking); best.describe(standardRanking) != "a pair of Aces" {
+		t.Errorf("got best Omaha hand %v (%v), expected a pair of Aces", best, best.describe(standardRanking))
+	}
+	if best := bestHand(holdemVariant{}.hands(hole[:2], board), standardRanking); best.describe(standardRanking) != "a flush, Ace high" {
+		t.Errorf("got best hold'em hand %v (%v), expected a flush", best, best.describe(standardRanking))
+	}
+}
+
+//Lows, sorted best to worst
+var lo1 Hand = mustParseCards("AS 2D 3C 4H 5S") // wheel
+var lo2 Hand = mustParseCards("6C 4D 3C 2S AD") // 6-4
+var lo3 Hand = mustParseCards("7C 5D 4C 3S 2D") // 7-5
+var lo4 Hand = mustParseCards("8C 6D 5C 4S 3D") // 8-6
+var lo5 Hand = mustParseCards("8H 7D 6C 5S 4D") // 8-7, though a straight
+
+var allLows []Hand = []Hand{lo1, lo2, lo3, lo4, lo5}
+
+func TestFindLowHands(t *testing.T) {
+	for i := 0; i < len(allLows)-1; i++ {
+		for q := i + 1; q < len(allLows); q++ {
+			winners := findLowHands([]Hand{allLows[q], allLows[i]})
+			if len(winners) != 1 || !areHandsEq(winners[0], allLows[i]) {
+				t.Errorf("%v is the best low, should be %v", winners, allLows[i])
+			}
+		}
+	}
+	for _, h := range []Hand{tk1, pr1, mustParseCards("9C 4D 3C 2S AD")} {
+		if _, ok := h.lowRank(); ok {
+			t.Errorf("%v qualifies as a low, expected it not to", h)
+		}
+		if winners := findLowHands([]Hand{h}); winners != nil {
+			t.Errorf("got low winners %v from %v, expected none", winners, h)
+		}
+	}
+	tie := mustParseCards("6S 4H 3D 2C AC")
+	if winners := findLowHands([]Hand{lo3, lo2, tie}); len(winners) != 2 {
+		t.Errorf("got low winners %v, expected %v and %v to tie", winners, lo2, tie)
+	}
+	if d := lo2.describeLow(); d != "6,4,3,2,A" {
+		t.Errorf("got %q describing %v, expected 6,4,3,2,A", d, lo2)
+	}
+}
+
+func TestShortDeckRanking(t *testing.T) {
+	flush := Hand(mustParseCards("KD 9D 8D 7D 6D"))
+	boat := Hand(mustParseCards("AS AD AC KS KH"))
+	wheel := Hand(mustParseCards("AS 6D 7C 8S 9H"))
+	trips := Hand(mustParseCards("AS AD AC KS QH"))
+	sixToTen := Hand(mustParseCards("6S 7D 8C 9S TH"))
+	tests := []struct {
+		better, worse Hand
+	}{
+		{flush, boat},
+		{wheel, trips},
+		{sixToTen, wheel},
+	}
+	for _, tt := range tests {
+		winners := findWinningHands([]Hand{tt.worse, tt.better}, shortDeckRanking)
+		if len(winners) != 1 || !areHandsEq(winners[0], tt.better) {
+			t.Errorf("got short deck winners %v, expected %v to beat %v", winners, tt.better, tt.worse)
+		}
+	}
+	if winners := findWinningHands([]Hand{flush, boat}, standardRanking); !areHandsEq(winners[0], boat) {
+		t.Errorf("%v beats %v outside short deck", winners, boat)
+	}
+	if winners := findWinningHands([]Hand{wheel, trips}, standardRanking); !areHandsEq(winners[0], trips) {
+		t.Errorf("%v beats %v outside short deck", winners, trips)
+	}
+	if d := wheel.describe(shortDeckRanking); d != "a straight, Ace to Nine" {
+		t.Errorf("got %q describing %v, expected a straight, Ace to Nine", d, wheel)
+	}
+	if d := wheel.describe(standardRanking); d != "high card Ace" {
+		t.Errorf("got %q describing %v outside short deck, expected high card Ace", d, wheel)
+	}
+}
+
 // func TestRawRanksAndSuits(t *testing.T) {
 //     //Test legimitate conversion
-//     var fl1 Hand = strings.Fields("TD AD 6D 7D 9D") // Flush
-//     var sh1 Hand = strings.Fields("2S 3S 4S 6C 8D") // 8 high
-//     var tk1 Hand = strings.Fields("TS TD TC JC 2S") // Three of a kind
+//     var fl1 Hand = mustParseCards("TD AD 6D 7D 9D") // Flush
+//     var sh1 Hand = mustParseCards("2S 3S 4S 6C 8D") // 8 high
+//     var tk1 Hand = mustParseCards("TS TD TC JC 2S") // Three of a kind
 
 //     //Test failure on invalid suits/ranks
-//     var invalid1 Hand = strings.Fields("MS VD TC JC 2S")
-//     var invalid2 Hand = strings.Fields("0S TD TC JC 2S")
+//     var invalid1 Hand = mustParseCards("MS VD TC JC 2S")
+//     var invalid2 Hand = mustParseCards("0S TD TC JC 2S")
 
 // }
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// HISTORY_LENGTH is the number of past hands each game keeps.
+const HISTORY_LENGTH = 1000
+
+// HandHistory is the record of everything that happened in a single hand.
+type HandHistory struct {
+	Number     int            `json:"hand"`
+	GameID     guid           `json:"gameID"`
+	Started    time.Time      `json:"started"`
+	Variant    string         `json:"variant"`
+	Betting    string         `json:"betting"`
+	SmallBlind money          `json:"small_blind"`
+	BigBlind   money          `json:"big_blind"`
+	Ante       money          `json:"ante"`
+	Level      int            `json:"level,omitempty"`
+	MaxSeats   int            `json:"max_seats"`
+	Button     int            `json:"button"`
+	Seats      []SeatRecord   `json:"seats"`
+	Actions    []ActionRecord `json:"actions"`
+	Board      []Card         `json:"board"`
+	// SecondBoard is the board of the second run, if the hand was run
+	// twice. It starts with the cards dealt before the players were all in.
+	SecondBoard []Card          `json:"second_board,omitempty"`
+	Hole        map[guid][]Card `json:"hole"`
+	// Upcards are the cards each player was dealt face up, in stud games.
+	Upcards  map[guid][]Card `json:"upcards,omitempty"`
+	Showdown []Playerhand    `json:"showdown"`
+	// Shown are the players who showed their hands after winning without
+	// a showdown.
+	Shown      []guid      `json:"shown,omitempty"`
+	Pots       []PotRecord `json:"pots"`
+	Commitment string      `json:"commitment"`
+	// Cards are the commitments to every card of the deck, in the order
+	// dealt, and Revealed opens those of the cards shown in the history.
+	Cards    []string       `json:"cards,omitempty"`
+	Revealed []RevealedCard `json:"revealed,omitempty"`
+	// Seed is what the deck was shuffled from. It is kept by the server
+	// but never shown, since it would give away every card in the deck.
+	Seed string `json:"seed,omitempty"`
+}
+
+// SeatRecord is a player's seat and stack at the start of a hand.
+// Seat 1 is the small blind.
+type SeatRecord struct {
+	Seat   int   `json:"seat"`
+	Player guid  `json:"playerID"`
+	Stack  money `json:"stack"`
+}
+
+// ActionRecord is a single action taken by a player. Amount is what the
+// action put into the pot; To is the player's total bet on the street after it.
+type ActionRecord struct {
+	Street string `json:"street"`
+	Player guid   `json:"playerID"`
+	Action string `json:"action"`
+	Amount money  `json:"amount"`
+	To     money  `json:"to"`
+	AllIn  bool   `json:"all_in"`
+}
+
+// PotRecord is a pot and how it was paid out. A hand run twice has a
+// record of each pot for each run, with Run 1 for the second.
+type PotRecord struct {
+	Number  uint       `json:"pot"`
+	Run     int        `json:"run,omitempty"`
+	Size    money      `json:"size"`
+	Winners []PotShare `json:"winners"`
+}
+
+type PotShare struct {
+	Player guid  `json:"playerID"`
+	Amount money `json:"amount"`
+}
+
+// newHandHistory starts the record of the hand about to be played at g.
+func newHandHistory(g *Game) *HandHistory {
+	h := new(HandHistory)
+	h.Number = g.hands
+	h.GameID = g.gameID
+	h.Started = time.Now()
+	h.Variant = g.rules.Variant
+	h.Betting = g.rules.Betting
+	h.SmallBlind = g.rules.SmallBlind
+	h.BigBlind = g.rules.BigBlind
+	h.Ante = g.rules.Ante
+	if g.tournament != nil {
+		h.Level = g.tournament.level + 1
+	}
+	h.MaxSeats = g.rules.MaxSeats
+	h.Button = len(g.table)
+	if len(g.table) == 2 {
+		h.Button = 1
+	}
+	for i, p := range g.table {
+		h.Seats = append(h.Seats, SeatRecord{Seat: i + 1, Player: p.guid, Stack: p.wealth})
+	}
+	h.Actions = make([]ActionRecord, 0)
+	h.Board = make([]Card, 0)
+	h.Hole = make(map[guid][]Card)
+	h.Upcards = make(map[guid][]Card)
+	h.Showdown = make([]Playerhand, 0)
+	h.Pots = make([]PotRecord, 0)
+	return h
+}
+
+func (h *HandHistory) act(round uint, player guid, action string, amount, to money, allIn bool) {
+	h.Actions = append(h.Actions, ActionRecord{Street: h.streets()[round], Player: player, Action: action, Amount: amount, To: to, AllIn: allIn})
+}
+
+// recordBet records a valid bet from p before it is committed to the pot.
+func (h *HandHistory) recordBet(g *Game, p *Player, bet money) {
+	soFar := g.pot.totalPlayerBetThisRound(p.guid)
+	action := "call"
+	switch {
+	case soFar+bet > g.pot.totalToCall && g.pot.totalToCall == 0:
+		action = "bet"
+	case soFar+bet > g.pot.totalToCall:
+		action = "raise"
+	case bet == 0:
+		action = "check"
+	}
+	h.act(g.round, p.guid, action, bet, soFar+bet, bet > 0 && bet == p.wealth)
+}
+
+// dealt records every player's hole cards, or in stud their cards for
+// third street.
+func (h *HandHistory) dealt(g *Game) {
+	for _, p := range g.table {
+		if g.variant().stud() {
+			h.Hole[p.guid], h.Upcards[p.guid] = g.studCards(p.guid, 0)
+		} else {
+			h.Hole[p.guid] = g.deck.holeCards(p.guid)
+		}
+	}
+	h.Commitment = commitment(deckCommitments(g.handSeed, g.variant().deck()))
+}
+
+// reachStreet records the board cards revealed on the current round, or
+// in stud the cards dealt to each player still in the hand.
+func (h *HandHistory) reachStreet(g *Game) {
+	if g.round == 0 {
+		return
+	}
+	if !g.variant().stud() {
+		h.Board = append(h.Board, g.deck.board(boardPlaces[g.round])...)
+		if g.runTwice {
+			h.SecondBoard = append(h.SecondBoard, g.deck.at(location{place: boardPlaces[g.round], run: 1})...)
+		}
+		return
+	}
+	for _, p := range g.table {
+		if p.state == folded {
+			continue
+		}
+		cards, up := g.studCards(p.guid, int(g.round))
+		h.Hole[p.guid] = append(h.Hole[p.guid], cards...)
+		h.Upcards[p.guid] = append(h.Upcards[p.guid], up...)
+	}
+}
+
+// streets returns the names of the hand's betting rounds.
+func (h *HandHistory) streets() []string {
+	return variantNamed(h.Variant).streets()
+}
+
+// ranking returns how the hand's game ranks hands.
+func (h *HandHistory) ranking() handRanking {
+	return variantNamed(h.Variant).ranking()
+}
+
+// showdown records the hands on run's board of the players who reached
+// showdown, in the order they showed them, if there is more than one.
+// Players who have since folded mucked their hands.
+func (h *HandHistory) showdown(order Table, run int) {
+	hands := make([]Playerhand, 0)
+	for _, p := range order {
+		if p.state == folded {
+			hands = append(hands, Playerhand{PlayerID: p.guid, Mucked: true, Run: run})
+		} else {
+			hands = append(hands, Playerhand{PlayerID: p.guid, Hand: p.bestHand, Low: p.bestLow, Run: run})
+		}
+	}
+	if len(hands) > 1 {
+		h.Showdown = append(h.Showdown, hands...)
+	}
+}
+
+func (h *HandHistory) pot(number uint, run int, size money, shares []PotShare) {
+	h.Pots = append(h.Pots, PotRecord{Number: number, Run: run, Size: size, Winners: shares})
+	sort.Sort(byPotNumber(h.Pots))
+}
+
+type byPotNumber []PotRecord
+
+func (p byPotNumber) Len() int { return len(p) }
+func (p byPotNumber) Less(i, j int) bool {
+	return p[i].Number < p[j].Number || (p[i].Number == p[j].Number && p[i].Run < p[j].Run)
+}
+func (p byPotNumber) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// viewFor returns a copy of the history with only the hole cards viewer
+// is allowed to see: their own, and those of players who showed them. The
+// seed is left out, and the commitments to only the cards viewer can see
+// are opened.
+func (h *HandHistory) viewFor(viewer guid) *HandHistory {
+	view := *h
+	view.Hole = make(map[guid][]Card)
+	visible := append(append(make([]Card, 0), h.Board...), h.SecondBoard...)
+	for player, cards := range h.Hole {
+		if player == viewer || h.shown(player) {
+			view.Hole[player] = cards
+			visible = append(visible, cards...)
+		}
+	}
+	for _, cards := range h.Upcards {
+		visible = append(visible, cards...)
+	}
+	view.Cards, view.Revealed = h.reveal(visible)
+	view.Seed = ""
+	return &view
+}
+
+// withShown returns a copy of the history with player's hand shown. The
+// history itself is left alone, since it may be being read.
+func (h *HandHistory) withShown(player guid) *HandHistory {
+	shown := *h
+	shown.Shown = append(append(make([]guid, 0), h.Shown...), player)
+	return &shown
+}
+
+func (h *HandHistory) shown(player guid) bool {
+	for _, ph := range h.Showdown {
+		if ph.PlayerID == player && !ph.Mucked {
+			return true
+		}
+	}
+	for _, p := range h.Shown {
+		if p == player {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText writes the hand in the PokerStars hand history format, with
+// the hole cards of viewer shown as the hero's.
+func (h *HandHistory) WriteText(w io.Writer, viewer guid) {
+	started := h.Started.UTC().Format("2006/01/02 15:04:05 UTC")
+	game := variantNamed(h.Variant).name() + " " + bettingNamed(h.Betting).name()
+	if h.Level > 0 {
+		fmt.Fprintf(w, "PokerStars Hand #%d: Tournament #%v, %v - Level %d (%d/%d) - %v\n", h.Number, h.GameID, game, h.Level, h.SmallBlind, h.BigBlind, started)
+	} else {
+		fmt.Fprintf(w, "PokerStars Hand #%d: %v (%d/%d) - %v\n", h.Number, game, h.SmallBlind, h.BigBlind, started)
+	}
+	fmt.Fprintf(w, "Table '%v' %d-max Seat #%d is the button\n", h.GameID, h.MaxSeats, h.Button)
+	for _, s := range h.Seats {
+		fmt.Fprintf(w, "Seat %d: %v (%d in chips)\n", s.Seat, s.Player, s.Stack)
+	}
+
+	streets := h.streets()
+	street := streets[0]
+	var maxTo money
+	dealt := false
+	for _, a := range h.Actions {
+		if !dealt && !a.forced() {
+			h.writeHoleCards(w, viewer)
+			dealt = true
+		}
+		if a.Street != street {
+			for i := h.streetIndex(street) + 1; i <= h.streetIndex(a.Street); i++ {
+				h.writeStreet(w, streets[i], viewer)
+			}
+			street = a.Street
+			maxTo = 0
+		}
+		fmt.Fprint(w, a.text(maxTo))
+		if a.To > maxTo {
+			maxTo = a.To
+		}
+	}
+	if !dealt {
+		h.writeHoleCards(w, viewer)
+	}
+	for i := h.streetIndex(street) + 1; i < len(streets); i++ {
+		h.writeStreet(w, streets[i], viewer)
+	}
+	h.writeSecondBoard(w)
+
+	if len(h.SecondBoard) == 0 {
+		h.writeShowdown(w, "SHOW DOWN", 0)
+	} else {
+		h.writeShowdown(w, "FIRST SHOW DOWN", 0)
+		h.writeShowdown(w, "SECOND SHOW DOWN", 1)
+	}
+	for _, player := range h.Shown {
+		fmt.Fprintf(w, "%v: shows [%v]\n", player, joinCards(h.Hole[player]))
+	}
+
+	fmt.Fprint(w, "*** SUMMARY ***\n")
+	var total money
+	for _, p := range h.Pots {
+		total += p.Size
+	}
+	fmt.Fprintf(w, "Total pot %d | Rake 0\n", total)
+	if len(h.SecondBoard) > 0 {
+		fmt.Fprint(w, "Hand was run twice\n")
+		fmt.Fprintf(w, "FIRST Board [%v]\n", joinCards(h.Board))
+		fmt.Fprintf(w, "SECOND Board [%v]\n", joinCards(h.SecondBoard))
+	} else if len(h.Board) > 0 {
+		fmt.Fprintf(w, "Board [%v]\n", joinCards(h.Board))
+	}
+	winnings := h.winnings()
+	for _, s := range h.Seats {
+		fmt.Fprintf(w, "Seat %d: %v%v %v\n", s.Seat, s.Player, h.position(s.Seat), h.outcome(s.Player, winnings))
+	}
+}
+
+// writeShowdown writes the hands shown on run's board, if there was a
+// showdown, and what each player collected from the pots on it.
+func (h *HandHistory) writeShowdown(w io.Writer, title string, run int) {
+	shown := false
+	for _, ph := range h.Showdown {
+		if ph.Run != run {
+			continue
+		}
+		if !shown {
+			fmt.Fprintf(w, "*** %v ***\n", title)
+			shown = true
+		}
+		if ph.Mucked {
+			fmt.Fprintf(w, "%v: mucks hand\n", ph.PlayerID)
+		} else if ph.Low != nil {
+			fmt.Fprintf(w, "%v: shows [%v] (HI: %v; LO: %v)\n", ph.PlayerID, joinCards(h.Hole[ph.PlayerID]), ph.Hand.describe(h.ranking()), ph.Low.describeLow())
+		} else {
+			fmt.Fprintf(w, "%v: shows [%v] (%v)\n", ph.PlayerID, joinCards(h.Hole[ph.PlayerID]), ph.Hand.describe(h.ranking()))
+		}
+	}
+	winnings := make(map[guid]money)
+	for _, p := range h.Pots {
+		for _, share := range p.Winners {
+			if p.Run == run && share.Amount > 0 {
+				winnings[share.Player] += share.Amount
+			}
+		}
+	}
+	for _, s := range h.Seats {
+		if won, ok := winnings[s.Player]; ok {
+			fmt.Fprintf(w, "%v collected %d from pot\n", s.Player, won)
+		}
+	}
+}
+
+// writeSecondBoard writes the streets of the second run of a hand run
+// twice, from the first the two boards don't share.
+func (h *HandHistory) writeSecondBoard(w io.Writer) {
+	b := h.SecondBoard
+	if len(b) < 5 {
+		return
+	}
+	shared := 0
+	for shared < len(h.Board) && h.Board[shared] == b[shared] {
+		shared++
+	}
+	if shared < 3 {
+		fmt.Fprintf(w, "*** SECOND FLOP *** [%v]\n", joinCards(b[:3]))
+	}
+	if shared < 4 {
+		fmt.Fprintf(w, "*** SECOND TURN *** [%v] [%v]\n", joinCards(b[:3]), b[3])
+	}
+	fmt.Fprintf(w, "*** SECOND RIVER *** [%v] [%v]\n", joinCards(b[:4]), b[4])
+}
+
+func (h *HandHistory) writeHoleCards(w io.Writer, viewer guid) {
+	if variantNamed(h.Variant).stud() {
+		h.writeStreet(w, studStreets[0], viewer)
+		return
+	}
+	fmt.Fprint(w, "*** HOLE CARDS ***\n")
+	if cards, ok := h.Hole[viewer]; ok {
+		fmt.Fprintf(w, "Dealt to %v [%v]\n", viewer, joinCards(cards))
+	}
+}
+
+// writeStreet writes the header for street, showing the board so far,
+// if the board reached that street. Stud streets show the cards dealt on
+// them instead.
+func (h *HandHistory) writeStreet(w io.Writer, street string, viewer guid) {
+	switch street {
+	case "FLOP":
+		if len(h.Board) >= 3 {
+			fmt.Fprintf(w, "*** FLOP *** [%v]\n", joinCards(h.Board[:3]))
+		}
+	case "TURN":
+		if len(h.Board) >= 4 {
+			fmt.Fprintf(w, "*** TURN *** [%v] [%v]\n", joinCards(h.Board[:3]), h.Board[3])
+		}
+	case "RIVER":
+		if len(h.Board) >= 5 {
+			fmt.Fprintf(w, "*** RIVER *** [%v] [%v]\n", joinCards(h.Board[:4]), h.Board[4])
+		}
+	default:
+		h.writeStudStreet(w, h.streetIndex(street), viewer)
+	}
+}
+
+// writeStudStreet writes the header for the n'th stud street, if it was
+// reached, and the cards dealt on it: all of viewer's, and everyone's upcards.
+func (h *HandHistory) writeStudStreet(w io.Writer, n int, viewer guid) {
+	reached := n == 0
+	for _, up := range h.Upcards {
+		reached = reached || (n < 4 && len(up) > n)
+	}
+	for _, a := range h.Actions {
+		reached = reached || a.Street == studStreets[n]
+	}
+	if n == 4 && len(h.Showdown) > 0 {
+		reached = true
+	}
+	if !reached {
+		return
+	}
+	titles := []string{"3rd STREET", "4th STREET", "5th STREET", "6th STREET", "RIVER"}
+	fmt.Fprintf(w, "*** %v ***\n", titles[n])
+	for _, s := range h.Seats {
+		cards := h.Upcards[s.Player]
+		dealt := n + 1
+		if s.Player == viewer {
+			cards = h.Hole[viewer]
+			dealt = n + 3
+			if n == 4 {
+				dealt = 7
+			}
+		}
+		if len(cards) < dealt || (n == 4 && s.Player != viewer) {
+			continue
+		}
+		if n == 0 {
+			fmt.Fprintf(w, "Dealt to %v [%v]\n", s.Player, joinCards(cards[:dealt]))
+		} else {
+			fmt.Fprintf(w, "Dealt to %v [%v] [%v]\n", s.Player, joinCards(cards[:dealt-1]), cards[dealt-1])
+		}
+	}
+}
+
+func (h *HandHistory) streetIndex(street string) int {
+	for i, s := range h.streets() {
+		if s == street {
+			return i
+		}
+	}
+	return -1
+}
+
+// forced returns true for the bets players make before they see their cards.
+func (a ActionRecord) forced() bool {
+	switch a.Action {
+	case "ante", "small_blind", "big_blind", "straddle":
+		return true
+	}
+	return false
+}
+
+// text returns the action as a line of a hand history. maxTo is the
+// largest bet on the street before this action.
+func (a ActionRecord) text(maxTo money) string {
+	allIn := ""
+	if a.AllIn {
+		allIn = " and is all-in"
+	}
+	switch a.Action {
+	case "ante":
+		return fmt.Sprintf("%v: posts the ante %d%v\n", a.Player, a.Amount, allIn)
+	case "small_blind":
+		return fmt.Sprintf("%v: posts small blind %d%v\n", a.Player, a.Amount, allIn)
+	case "big_blind":
+		return fmt.Sprintf("%v: posts big blind %d%v\n", a.Player, a.Amount, allIn)
+	case "straddle":
+		return fmt.Sprintf("%v: posts straddle %d%v\n", a.Player, a.Amount, allIn)
+	case "bring_in":
+		return fmt.Sprintf("%v: brings in for %d%v\n", a.Player, a.Amount, allIn)
+	case "check":
+		return fmt.Sprintf("%v: checks\n", a.Player)
+	case "call":
+		return fmt.Sprintf("%v: calls %d%v\n", a.Player, a.Amount, allIn)
+	case "bet":
+		return fmt.Sprintf("%v: bets %d%v\n", a.Player, a.Amount, allIn)
+	case "raise":
+		return fmt.Sprintf("%v: raises %d to %d%v\n", a.Player, a.To-maxTo, a.To, allIn)
+	case "timeout":
+		return fmt.Sprintf("%v has timed out\n%v: folds\n", a.Player, a.Player)
+	case "timeout_check":
+		return fmt.Sprintf("%v has timed out\n%v: checks\n", a.Player, a.Player)
+	default:
+		return fmt.Sprintf("%v: folds\n", a.Player)
+	}
+}
+
+// winnings returns the total each winning player collected.
+func (h *HandHistory) winnings() map[guid]money {
+	winnings := make(map[guid]money)
+	for _, p := range h.Pots {
+		for _, share := range p.Winners {
+			if share.Amount > 0 {
+				winnings[share.Player] += share.Amount
+			}
+		}
+	}
+	return winnings
+}
+
+func (h *HandHistory) position(seat int) string {
+	if variantNamed(h.Variant).stud() {
+		return ""
+	}
+	pos := ""
+	if seat == h.Button {
+		pos += " (button)"
+	}
+	switch seat {
+	case 1:
+		pos += " (small blind)"
+	case 2:
+		pos += " (big blind)"
+	}
+	return pos
+}
+
+var streetTitles = map[string]string{
+	"FLOP": "Flop", "TURN": "Turn", "RIVER": "River",
+	"THIRD": "3rd Street", "FOURTH": "4th Street", "FIFTH": "5th Street", "SIXTH": "6th Street", "SEVENTH": "7th Street",
+}
+
+// outcome summarizes how the hand ended for player.
+func (h *HandHistory) outcome(player guid, winnings map[guid]money) string {
+	for _, a := range h.Actions {
+		if a.Player == player && (a.Action == "fold" || a.Action == "timeout" || a.Action == "invalid") {
+			if a.Street == "PREFLOP" {
+				return "folded before Flop"
+			}
+			return "folded on the " + streetTitles[a.Street]
+		}
+	}
+	for _, ph := range h.Showdown {
+		if ph.PlayerID != player {
+			continue
+		}
+		if ph.Mucked {
+			return "mucked"
+		}
+		cards := joinCards(h.Hole[player])
+		if won, ok := winnings[player]; ok {
+			return fmt.Sprintf("showed [%v] and won (%d) with %v", cards, won, ph.Hand.describe(h.ranking()))
+		}
+		return fmt.Sprintf("showed [%v] and lost with %v", cards, ph.Hand.describe(h.ranking()))
+	}
+	if won, ok := winnings[player]; ok {
+		return fmt.Sprintf("collected (%d)", won)
+	}
+	return "mucked"
+}
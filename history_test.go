@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenLog is the log of a hand of three players: player-2 folds on the
+// flop, and player-1 mucks at the showdown player-3 wins.
+func goldenLog() *GameLog {
+	events := []LogEvent{
+		{Hand: 1, Type: "join", Player: "player-1"},
+		{Hand: 1, Type: "join", Player: "player-2"},
+		{Hand: 1, Type: "join", Player: "player-3"},
+	}
+	acts := []struct {
+		player guid
+		action int
+		amount money
+	}{
+		// preflop
+		{"player-3", call, 10}, {"player-1", call, 0}, {"player-2", call, 20},
+		// flop
+		{"player-3", call, 0}, {"player-1", call, 40}, {"player-2", fold, 0}, {"player-3", call, 40},
+		// turn
+		{"player-3", call, 0}, {"player-1", call, 0},
+		// river
+		{"player-3", call, 0}, {"player-1", call, 0},
+	}
+	for _, a := range acts {
+		events = append(events, LogEvent{Hand: 1, Type: "act", Player: a.player, Action: a.action, BetAmount: a.amount})
+	}
+	events = append(events, LogEvent{Hand: 1, Type: "muck", Player: "player-1"})
+	return &GameLog{GameID: "golden", Seed: 7, Rules: DefaultRules(), Shuffle: mathShuffle, Events: events}
+}
+
+// goldenHand replays the hand of goldenLog.
+func goldenHand(t *testing.T) *HandHistory {
+	g, err := Replay(goldenLog())
+	if err != nil {
+		t.Fatalf("could not replay hand: %v", err)
+	}
+	hands := g.controller.handHistories()
+	if len(hands) != 1 {
+		t.Fatalf("replayed %v hands, expected 1", len(hands))
+	}
+	h := hands[0]
+	h.Started = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return h
+}
+
+func TestWriteText(t *testing.T) {
+	h := goldenHand(t)
+	for _, viewer := range []guid{"", "player-1", "player-2"} {
+		name := "hand-" + string(viewer) + ".txt"
+		if viewer == "" {
+			name = "hand-public.txt"
+		}
+		var text bytes.Buffer
+		h.viewFor(viewer).WriteText(&text, viewer)
+		path := filepath.Join("testdata", name)
+		if *update {
+			if err := ioutil.WriteFile(path, text.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		golden, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("could not read golden file: %v", err)
+		}
+		if !bytes.Equal(text.Bytes(), golden) {
+			t.Errorf("hand as seen by %q differs from %v:\ngot\n%s\nexpected\n%s", viewer, path, text.Bytes(), golden)
+		}
+	}
+}
+
+func TestViewFor(t *testing.T) {
+	h := goldenHand(t)
+	// only player-3 showed; player-1 mucked and player-2 folded
+	visible := map[guid][]guid{
+		"":         {"player-3"},
+		"player-1": {"player-1", "player-3"},
+		"player-2": {"player-2", "player-3"},
+		"player-3": {"player-3"},
+	}
+	for viewer, expected := range visible {
+		got := make([]guid, 0)
+		for player := range h.viewFor(viewer).Hole {
+			got = append(got, player)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%q can see the hole cards of %v, expected %v", viewer, got, expected)
+		}
+	}
+	if len(h.Hole) != 3 {
+		t.Errorf("viewing the hand changed its hole cards to %v", h.Hole)
+	}
+}
+
+func TestViewForRevealsOnlyShownCards(t *testing.T) {
+	h := goldenHand(t)
+	view := h.viewFor("")
+	if view.Seed != "" {
+		t.Errorf("the hand's seed was shown: %v", view.Seed)
+	}
+	if v := VerifyHand(view); !v.Valid || v.Revealed != 7 {
+		t.Errorf("hand verified %v with %v cards revealed, expected the board and player-3's two: %v", v.Valid, v.Revealed, v.Problem)
+	}
+	for _, r := range view.Revealed {
+		for _, player := range []guid{"player-1", "player-2"} {
+			for _, c := range h.Hole[player] {
+				if r.Card == c {
+					t.Errorf("%v's hole card %v was revealed, though they didn't show it", player, c)
+				}
+			}
+		}
+	}
+	if v := VerifyHand(h.viewFor("player-1")); !v.Valid || v.Revealed != 9 {
+		t.Errorf("hand verified %v for player-1 with %v cards revealed, expected 9: %v", v.Valid, v.Revealed, v.Problem)
+	}
+
+	swapped := view.Revealed[0].Card
+	for _, c := range h.Hole["player-1"] {
+		view.Revealed[0].Card = c
+	}
+	if v := VerifyHand(view); v.Valid {
+		t.Errorf("hand verified with %v revealed in place of %v", view.Revealed[0].Card, swapped)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// STARTING_BANKROLL is what every player's bankroll starts with.
+const STARTING_BANKROLL = 100000
+
+// bankAccount is the account bankrolls are opened from. It is the only
+// account allowed to go below zero.
+const bankAccount = "bank"
+
+var errInsufficientFunds = errors.New("ledger: bankroll can't cover the buy-in")
+
+// Transaction moves Amount from one account to another. Every movement of
+// money is a transaction, entered twice over: taken from From and added
+// to To, so that the balances of all the accounts always add up to zero.
+// Players' accounts are "user:" and their player ID, and money in play is
+// kept in "game:" and a game ID, or "tournament:" and a tournament ID.
+type Transaction struct {
+	ID     int       `json:"id"`
+	Time   time.Time `json:"time"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Amount money     `json:"amount"`
+	Memo   string    `json:"memo"`
+}
+
+// Statement is a player's bankroll and every transaction that moved it,
+// oldest first.
+type Statement struct {
+	PlayerID     guid          `json:"playerID"`
+	Balance      money         `json:"balance"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+func userAccount(player guid) string  { return "user:" + string(player) }
+func gameAccount(game guid) string    { return "game:" + string(game) }
+func tournamentAccount(m guid) string { return "tournament:" + string(m) }
+
+// Ledger keeps the bankroll of every player and the money they have in
+// play. A player's bankroll is opened with STARTING_BANKROLL the first
+// time it is used.
+type Ledger struct {
+	transactions []Transaction
+	balances     map[string]int64
+	// f and enc append each transaction to the ledger's file, if it has one.
+	f   *os.File
+	enc *json.Encoder
+	sync.Mutex
+}
+
+// NewLedger returns a Ledger that lives only in memory.
+func NewLedger() *Ledger {
+	l := new(Ledger)
+	l.transactions = make([]Transaction, 0)
+	l.balances = make(map[string]int64)
+	return l
+}
+
+// OpenLedger loads the ledger kept at path, one transaction per line, and
+// goes on appending to it. The file is created if it does not exist. A
+// partially written last line, as left by a crash, is cut off.
+func OpenLedger(path string) (*Ledger, error) {
+	l := NewLedger()
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	var size int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			f.Close()
+			return nil, err
+		}
+		var t Transaction
+		if json.Unmarshal(line, &t) != nil {
+			break
+		}
+		l.apply(t)
+		size += int64(len(line))
+	}
+	err = f.Truncate(size)
+	if err == nil {
+		_, err = f.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.f, l.enc = f, json.NewEncoder(f)
+	return l, nil
+}
+
+func (l *Ledger) apply(t Transaction) {
+	l.transactions = append(l.transactions, t)
+	l.balances[t.From] -= int64(t.Amount)
+	l.balances[t.To] += int64(t.Amount)
+}
+
+// transfer moves amount from one account to another, failing with
+// errInsufficientFunds if from doesn't have it. It must be called with l
+// locked.
+func (l *Ledger) transfer(from, to string, amount money, memo string) error {
+	if amount == 0 {
+		return nil
+	}
+	if from != bankAccount && l.balances[from] < int64(amount) {
+		return errInsufficientFunds
+	}
+	t := Transaction{ID: len(l.transactions) + 1, Time: time.Now(), From: from, To: to, Amount: amount, Memo: memo}
+	if l.enc != nil {
+		// a transaction that didn't make it safely to disk is cut off
+		// again, so the file never holds one the ledger didn't make
+		size, err := l.f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		err = l.enc.Encode(t)
+		if err == nil {
+			err = l.f.Sync()
+		}
+		if err != nil {
+			if l.f.Truncate(size) == nil {
+				l.f.Seek(size, io.SeekStart)
+			}
+			return err
+		}
+	}
+	l.apply(t)
+	return nil
+}
+
+// open opens player's bankroll, if it hasn't been yet. It must be called
+// with l locked.
+func (l *Ledger) open(player guid) error {
+	if _, ok := l.balances[userAccount(player)]; ok {
+		return nil
+	}
+	return l.transfer(bankAccount, userAccount(player), STARTING_BANKROLL, "opening bankroll")
+}
+
+// canCover returns errInsufficientFunds unless player's bankroll holds at
+// least amount.
+func (l *Ledger) canCover(player guid, amount money) error {
+	l.Lock()
+	defer l.Unlock()
+	err := l.open(player)
+	if err != nil {
+		return err
+	}
+	if l.balances[userAccount(player)] < int64(amount) {
+		return errInsufficientFunds
+	}
+	return nil
+}
+
+// buyIn moves amount from player's bankroll into account.
+func (l *Ledger) buyIn(player guid, account string, amount money, memo string) error {
+	l.Lock()
+	defer l.Unlock()
+	err := l.open(player)
+	if err != nil {
+		return err
+	}
+	return l.transfer(userAccount(player), account, amount, memo)
+}
+
+// cashOut moves amount from account back into player's bankroll.
+func (l *Ledger) cashOut(account string, player guid, amount money, memo string) error {
+	l.Lock()
+	defer l.Unlock()
+	err := l.open(player)
+	if err != nil {
+		return err
+	}
+	return l.transfer(account, userAccount(player), amount, memo)
+}
+
+// refund empties account, which is being closed, into the bankrolls of
+// the players who paid into it, in proportion to what each paid in and
+// hasn't taken out again. The last of them gets whatever rounding leaves.
+func (l *Ledger) refund(account string, memo string) error {
+	l.Lock()
+	defer l.Unlock()
+	owed := make(map[string]int64)
+	players := make([]string, 0)
+	for _, t := range l.transactions {
+		switch {
+		case t.To == account && strings.HasPrefix(t.From, "user:"):
+			if _, ok := owed[t.From]; !ok {
+				players = append(players, t.From)
+			}
+			owed[t.From] += int64(t.Amount)
+		case t.From == account && strings.HasPrefix(t.To, "user:"):
+			owed[t.To] -= int64(t.Amount)
+		}
+	}
+	var total int64
+	owing := make([]string, 0)
+	for _, player := range players {
+		if owed[player] > 0 {
+			total += owed[player]
+			owing = append(owing, player)
+		}
+	}
+	left := l.balances[account]
+	pool := left
+	for i, player := range owing {
+		share := pool * owed[player] / total
+		if i == len(owing)-1 {
+			share = left
+		}
+		err := l.transfer(account, player, money(share), memo)
+		if err != nil {
+			return err
+		}
+		left -= share
+	}
+	return nil
+}
+
+// balance returns what account holds.
+func (l *Ledger) balance(account string) money {
+	l.Lock()
+	defer l.Unlock()
+	if l.balances[account] < 0 {
+		return 0
+	}
+	return money(l.balances[account])
+}
+
+// statement returns player's bankroll and its history, opening it if need be.
+func (l *Ledger) statement(player guid) (Statement, error) {
+	l.Lock()
+	defer l.Unlock()
+	err := l.open(player)
+	if err != nil {
+		return Statement{}, err
+	}
+	account := userAccount(player)
+	s := Statement{PlayerID: player, Balance: money(l.balances[account]), Transactions: make([]Transaction, 0)}
+	for _, t := range l.transactions {
+		if t.From == account || t.To == account {
+			s.Transactions = append(s.Transactions, t)
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLedger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pokerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ledger.log")
+
+	l, err := OpenLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.buyIn("player-1", gameAccount("game-1"), 3000, "buy-in"); err != nil {
+		t.Fatalf("could not buy in: %v", err)
+	}
+	if err := l.buyIn("player-1", gameAccount("game-1"), STARTING_BANKROLL, "buy-in"); err != errInsufficientFunds {
+		t.Errorf("buying in for more than the bankroll: got %v, expected errInsufficientFunds", err)
+	}
+	if err := l.cashOut(gameAccount("game-1"), "player-1", 5000, "cash out"); err != errInsufficientFunds {
+		t.Errorf("cashing out more than the game holds: got %v, expected errInsufficientFunds", err)
+	}
+	if err := l.cashOut(gameAccount("game-1"), "player-1", 1000, "cash out"); err != nil {
+		t.Fatalf("could not cash out: %v", err)
+	}
+	l.f.Close()
+
+	// as if the server died writing a transaction
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":4,`)
+	f.Close()
+
+	l, err = OpenLedger(path)
+	if err != nil {
+		t.Fatalf("could not reopen ledger: %v", err)
+	}
+	defer l.f.Close()
+	s, err := l.statement("player-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Balance != STARTING_BANKROLL-2000 {
+		t.Errorf("got balance %v, expected %v", s.Balance, STARTING_BANKROLL-2000)
+	}
+	memos := []string{"opening bankroll", "buy-in", "cash out"}
+	if len(s.Transactions) != len(memos) {
+		t.Fatalf("got transactions %+v, expected %v", s.Transactions, memos)
+	}
+	for i, tr := range s.Transactions {
+		if tr.ID != i+1 || tr.Memo != memos[i] {
+			t.Errorf("got transaction %+v, expected %v number %v", tr, memos[i], i+1)
+		}
+	}
+	if l.balance(gameAccount("game-1")) != 2000 {
+		t.Errorf("game holds %v, expected 2000", l.balance(gameAccount("game-1")))
+	}
+}
+
+func TestCashGameBankrolls(t *testing.T) {
+	gc := NewGameController()
+	gc.ledger = NewLedger()
+	g := NewGame(gc, 1, DefaultRules())
+	if err := g.controller.enqueuePlayer(g, NewPlayer("player-1", STARTING_BANKROLL+1)); err != errInsufficientFunds {
+		t.Errorf("joining for more than the bankroll: got %v, expected errInsufficientFunds", err)
+	}
+	for _, id := range []guid{"player-1", "player-2"} {
+		if err := g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn)); err != nil {
+			t.Fatalf("could not join %v: %v", id, err)
+		}
+	}
+	g.addWaitingPlayers()
+	if g.controller.ledger.balance(gameAccount(g.gameID)) != 2*g.rules.BuyIn {
+		t.Fatalf("game holds %v, expected both buy-ins", g.controller.ledger.balance(gameAccount(g.gameID)))
+	}
+	g.table[0].wealth, g.table[1].wealth = g.rules.BuyIn+500, g.rules.BuyIn-500
+	won := g.table[0].guid
+	g.controller.removePlayerFromGame(g, won)
+	if b := g.controller.ledger.balance(userAccount(won)); b != STARTING_BANKROLL+500 {
+		t.Errorf("player leaving with a profit of 500 has %v, expected %v", b, STARTING_BANKROLL+500)
+	}
+	if b := g.controller.ledger.balance(gameAccount(g.gameID)); b != g.rules.BuyIn-500 {
+		t.Errorf("game holds %v, expected the other player's stack", b)
+	}
+}
+
+func TestBuyInFailsAfterJoining(t *testing.T) {
+	gc := NewGameController()
+	gc.ledger = NewLedger()
+	g := NewGame(gc, 1, DefaultRules())
+	for _, id := range []guid{"player-1", "player-2"} {
+		if err := g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn)); err != nil {
+			t.Fatalf("could not join %v: %v", id, err)
+		}
+	}
+	rejections, err := g.controller.connectSocket("player-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// player-1 spends their bankroll elsewhere before they are seated
+	if err := gc.ledger.buyIn("player-1", gameAccount("game-2"), STARTING_BANKROLL, "buy-in"); err != nil {
+		t.Fatal(err)
+	}
+	g.addWaitingPlayers()
+	if len(g.table) != 1 || g.table[0].guid != "player-2" || g.controller.isWaiting("player-1") {
+		t.Errorf("got %v seated, expected only player-2, with player-1 no longer waiting", g.table)
+	}
+	select {
+	case e := <-rejections:
+		if e.Code != buyInFailed {
+			t.Errorf("player-1 was told %+v, expected that their buy-in failed", e)
+		}
+	default:
+		t.Errorf("player-1 wasn't told their buy-in failed")
+	}
+}
+
+func TestTournamentPrizes(t *testing.T) {
+	rules := Rules{BuyIn: 300, MaxSeats: 3, Tournament: &TournamentRules{HandsPerLevel: 2, Payouts: []int{70, 30}}}.withDefaults()
+	gc := NewGameController()
+	gc.ledger = NewLedger()
+	pg := gc.makeGame(11, mathShuffle, rules)
+	g := gc.Games[guid(pg.GameID)]
+	for _, id := range []guid{"player-1", "player-2", "player-3"} {
+		if err := g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn)); err != nil {
+			t.Fatalf("could not register %v: %v", id, err)
+		}
+	}
+	playUntil(t, g, func() bool { return g.controller.publicGame().Tournament.Finished })
+
+	prizes := map[int]money{1: 630, 2: 270, 3: 0}
+	for _, s := range g.controller.publicGame().Tournament.Standings {
+		expected := STARTING_BANKROLL - 300 + prizes[s.Place]
+		if b := gc.ledger.balance(userAccount(s.Player)); b != expected {
+			t.Errorf("player in place %v has %v, expected %v", s.Place, b, expected)
+		}
+	}
+	if b := gc.ledger.balance(gameAccount(g.gameID)); b != 0 {
+		t.Errorf("prize pool has %v left over", b)
+	}
+}
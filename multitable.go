@@ -0,0 +1,341 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MultiTableRules are the rules of a multi-table tournament: the rules
+// every table plays under, and the number of entrants it starts with once
+// they have registered.
+type MultiTableRules struct {
+	Rules
+	Entrants int `json:"entrants"`
+}
+
+// withDefaults fills in any rule left as zero, as Rules.withDefaults does.
+// Unless told otherwise, a tournament fills two tables, and pays out as
+// many places as it has entrants for.
+func (r MultiTableRules) withDefaults() MultiTableRules {
+	seats := r.MaxSeats
+	if seats == 0 {
+		seats = DefaultRules().MaxSeats
+	}
+	if r.Entrants == 0 {
+		r.Entrants = 2 * seats
+	}
+	tr := TournamentRules{}
+	if r.Tournament != nil {
+		tr = *r.Tournament
+	}
+	if len(tr.Payouts) == 0 {
+		tr.Payouts = defaultPayouts(r.Entrants)
+	}
+	r.Tournament = &tr
+	r.Rules = r.Rules.withDefaults()
+	return r
+}
+
+func (r MultiTableRules) validate() error {
+	if r.Entrants < 2 {
+		return errors.New("A tournament needs at least 2 entrants.")
+	}
+	table := r.Rules
+	table.Tournament = nil
+	err := table.validate()
+	if err != nil {
+		return err
+	}
+	return r.Tournament.validate(r.Entrants)
+}
+
+// MultiTable is a tournament played across many games at once. Registrants
+// are seated across as few tables as will hold them. As players bust, the
+// tables are kept within a player of each other, and a table is broken up
+// as soon as its players fit at the others, until everyone left is at a
+// final table.
+type MultiTable struct {
+	id         guid
+	rules      MultiTableRules
+	shuffle    string
+	registered []*Player
+	tables     []*Game
+	// seats is the table each player still in the tournament is at, or
+	// is on their way to.
+	seats     map[guid]*Game
+	started   time.Time
+	level     int
+	hands     int
+	standings []Standing
+	finished  bool
+	// ledger takes buy-ins and pays prizes, if the server keeps bankrolls.
+	ledger *Ledger
+	sync.Mutex
+}
+
+// MultiTableStatus is the public view of a multi-table tournament.
+type MultiTableStatus struct {
+	TournamentID guid              `json:"tournamentID"`
+	Rules        MultiTableRules   `json:"rules"`
+	Registered   []guid            `json:"registered"`
+	Started      bool              `json:"started"`
+	Level        int               `json:"level"`
+	SmallBlind   money             `json:"small_blind"`
+	BigBlind     money             `json:"big_blind"`
+	Ante         money             `json:"ante"`
+	Remaining    int               `json:"remaining"`
+	Tables       []TableAssignment `json:"tables"`
+	Finished     bool              `json:"finished"`
+	Standings    []Standing        `json:"standings"`
+}
+
+// TableAssignment is a table of a multi-table tournament and the players
+// assigned to it.
+type TableAssignment struct {
+	GameID  guid   `json:"gameID"`
+	Players []guid `json:"players"`
+}
+
+var errAlreadyRegistered = errors.New("controller: player has already registered for this tournament")
+
+// makeTournament creates a multi-table tournament for players to register for.
+func (gc *GameController) makeTournament(rules MultiTableRules, shuffle string) *MultiTable {
+	m := new(MultiTable)
+	m.id = guid(createGuid())
+	m.rules = rules
+	m.shuffle = shuffle
+	m.registered = make([]*Player, 0)
+	m.tables = make([]*Game, 0)
+	m.seats = make(map[guid]*Game)
+	m.standings = make([]Standing, 0)
+	m.ledger = gc.ledger
+	gc.Tournaments[m.id] = m
+	return m
+}
+
+// register enters player in the tournament, which starts once it has all
+// its entrants.
+func (m *MultiTable) register(gc *GameController, player guid) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, p := range m.registered {
+		if p.guid == player {
+			return errAlreadyRegistered
+		}
+	}
+	if len(m.registered) >= m.rules.Entrants {
+		return errTournamentFull
+	}
+	if m.ledger != nil {
+		err := m.ledger.buyIn(player, tournamentAccount(m.id), m.rules.BuyIn, "tournament buy-in")
+		if err != nil {
+			return err
+		}
+	}
+	m.registered = append(m.registered, NewPlayer(player, m.rules.BuyIn))
+	if len(m.registered) == m.rules.Entrants {
+		m.start(gc)
+	}
+	return nil
+}
+
+// start deals the registered players around as few tables as will seat
+// them and starts playing.
+func (m *MultiTable) start(gc *GameController) {
+	m.started = time.Now()
+	n := (len(m.registered) + m.rules.MaxSeats - 1) / m.rules.MaxSeats
+	seed := m.started.UnixNano()
+	for i := 0; i < n; i++ {
+		g := NewGame(gc, seed+int64(i), m.rules.Rules)
+		g.shuffle = m.shuffle
+		g.tournament.multiTable = m.id
+		g.tournament.mtt = m
+		g.controller.closeEntries()
+		m.tables = append(m.tables, g)
+	}
+	for i, p := range m.registered {
+		g := m.tables[i%n]
+		g.controller.transferPlayer(p)
+		m.seats[p.guid] = g
+	}
+	for _, g := range m.tables {
+		gc.startGame(g)
+	}
+}
+
+// blindLevel returns the level the hand about to be played at g is at.
+// Levels that go by hands count the hands of the busiest table.
+func (m *MultiTable) blindLevel(g *Game) int {
+	m.Lock()
+	defer m.Unlock()
+	tr := m.rules.Tournament
+	if g.hands > m.hands {
+		m.hands = g.hands
+	}
+	level := 0
+	if tr.HandsPerLevel > 0 {
+		level = (m.hands - 1) / tr.HandsPerLevel
+	} else {
+		level = int(time.Since(m.started) / (time.Duration(tr.LevelMinutes) * time.Minute))
+	}
+	if level >= len(tr.Levels) {
+		level = len(tr.Levels) - 1
+	}
+	if level > m.level {
+		m.level = level
+	}
+	return m.level
+}
+
+// handOver is told by table g, after each hand, which of its players
+// busted, lowest place first. It places them, then breaks g if its players
+// fit at the other tables, or otherwise moves players from g until it has
+// at most one more than the smallest table. Tables only ever give up
+// players between their own hands, so nobody is moved in the middle of one.
+func (m *MultiTable) handOver(g *Game, busted []guid) {
+	m.Lock()
+	defer m.Unlock()
+	for _, player := range busted {
+		m.place(player)
+		delete(m.seats, player)
+	}
+	if len(m.seats) == 1 {
+		for player := range m.seats {
+			m.place(player)
+		}
+		m.finished = true
+		g.tournament.finished = true
+		return
+	}
+
+	others := make([]*Game, 0)
+	for _, t := range m.tables {
+		if t != g {
+			others = append(others, t)
+		}
+	}
+	leaving := make([]*Player, 0)
+	for _, p := range g.table {
+		if p.wealth > 0 {
+			leaving = append(leaving, p)
+		}
+	}
+	if len(others) > 0 && len(m.seats) <= len(others)*m.rules.MaxSeats {
+		for _, p := range leaving {
+			m.move(g, p, m.smallest(others))
+		}
+		// players on their way to g, not yet seated, go on elsewhere
+		for _, p := range g.controller.takeWaiting() {
+			to := m.smallest(others)
+			to.controller.transferPlayer(p)
+			m.seats[p.guid] = to
+		}
+		m.tables = others
+		g.tournament.finished = true
+		return
+	}
+	for len(others) > 0 && len(leaving) > 0 {
+		to := m.smallest(others)
+		if m.count(g)-m.count(to) < 2 {
+			break
+		}
+		m.move(g, leaving[0], to)
+		leaving = leaving[1:]
+	}
+}
+
+// move takes p from table from to table to.
+func (m *MultiTable) move(from *Game, p *Player, to *Game) {
+	from.controller.moveOut(from, p)
+	to.controller.transferPlayer(p)
+	m.seats[p.guid] = to
+}
+
+// count returns the number of players at, or on their way to, table g.
+func (m *MultiTable) count(g *Game) int {
+	n := 0
+	for _, t := range m.seats {
+		if t == g {
+			n++
+		}
+	}
+	return n
+}
+
+func (m *MultiTable) smallest(tables []*Game) *Game {
+	smallest := tables[0]
+	for _, t := range tables[1:] {
+		if m.count(t) < m.count(smallest) {
+			smallest = t
+		}
+	}
+	return smallest
+}
+
+// place gives player the best place not yet taken.
+func (m *MultiTable) place(player guid) {
+	place := len(m.registered) - len(m.standings)
+	s := Standing{Place: place, Player: player}
+	if place <= len(m.rules.Tournament.Payouts) {
+		s.Payout = m.rules.Tournament.Payouts[place-1]
+	}
+	m.standings = append(m.standings, s)
+	m.payPrize(s)
+}
+
+// payPrize pays the prize for a standing out of the prize pool. First
+// place, paid last, takes whatever is left of it.
+func (m *MultiTable) payPrize(s Standing) {
+	if m.ledger == nil || s.Payout == 0 {
+		return
+	}
+	account := tournamentAccount(m.id)
+	prize := money(len(m.registered)) * m.rules.BuyIn * money(s.Payout) / 100
+	if s.Place == 1 {
+		prize = m.ledger.balance(account)
+	}
+	err := m.ledger.cashOut(account, s.Player, prize, fmt.Sprintf("tournament prize for place %d", s.Place))
+	if err != nil {
+		log.Printf("Could not pay player %v their prize from tournament %v: %v\n", s.Player, m.id, err)
+	}
+}
+
+func (m *MultiTable) status() *MultiTableStatus {
+	m.Lock()
+	defer m.Unlock()
+	l := m.rules.Tournament.Levels[m.level]
+	ms := &MultiTableStatus{TournamentID: m.id, Rules: m.rules, Started: !m.started.IsZero(), Level: m.level + 1}
+	ms.SmallBlind, ms.BigBlind, ms.Ante = l.SmallBlind, l.BigBlind, l.Ante
+	ms.Registered = make([]guid, 0)
+	for _, p := range m.registered {
+		ms.Registered = append(ms.Registered, p.guid)
+	}
+	ms.Remaining = len(m.seats)
+	ms.Tables = make([]TableAssignment, 0)
+	for _, g := range m.tables {
+		ta := TableAssignment{GameID: g.gameID, Players: make([]guid, 0)}
+		for player, t := range m.seats {
+			if t == g {
+				ta.Players = append(ta.Players, player)
+			}
+		}
+		sort.Sort(byGuid(ta.Players))
+		ms.Tables = append(ms.Tables, ta)
+	}
+	ms.Finished = m.finished
+	ms.Standings = make([]Standing, 0)
+	for i := len(m.standings) - 1; i >= 0; i-- {
+		ms.Standings = append(ms.Standings, m.standings[i])
+	}
+	return ms
+}
+
+type byGuid []guid
+
+func (g byGuid) Len() int           { return len(g) }
+func (g byGuid) Less(i, j int) bool { return g[i] < g[j] }
+func (g byGuid) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
@@ -7,11 +7,16 @@ type Pot struct {
 	totalToCall money
 	potNumber   uint
 	bets        []Bet
+	// raises counts the bets and raises made this round.
+	raises int
 }
 type Bet struct {
 	potNumber uint
 	player    guid
 	value     money
+	// dead money, like a big blind ante, is in the pot but doesn't count
+	// towards anyone's bet or make anyone eligible to win it.
+	dead bool
 }
 
 //newPot is a constructor for a new Pot struct
@@ -28,6 +33,7 @@ func (pot *Pot) newRound() {
 	pot.makeSidePots()
 	pot.minRaise = 0
 	pot.totalToCall = 0
+	pot.raises = 0
 	pot.potNumber++
 }
 
@@ -35,7 +41,7 @@ func (pot *Pot) condenseBets() {
 	playerBets := make(map[guid]money)
 	betsCopy := make([]Bet, 0)
 	for _, bet := range pot.bets {
-		if bet.potNumber == pot.potNumber {
+		if bet.potNumber == pot.potNumber && !bet.dead {
 			playerBets[bet.player] += bet.value
 		} else {
 			betsCopy = append(betsCopy, bet)
@@ -51,13 +57,13 @@ func (pot *Pot) condenseBets() {
 func (pot *Pot) allBetsEqual(potNumber uint) bool {
 	var prevBet money
 	for _, bet := range pot.bets {
-		if bet.potNumber == potNumber {
+		if bet.potNumber == potNumber && !bet.dead {
 			prevBet = bet.value
 			break
 		}
 	}
 	for _, bet := range pot.bets {
-		if bet.potNumber != potNumber {
+		if bet.potNumber != potNumber || bet.dead {
 			continue
 		}
 		if prevBet != bet.value {
@@ -75,13 +81,13 @@ func (pot *Pot) makeSidePots() {
 
 	minimum := money(math.MaxUint64)
 	for _, b := range pot.bets {
-		if b.value < minimum && b.potNumber == (pot.potNumber-1) {
+		if b.value < minimum && b.potNumber == (pot.potNumber-1) && !b.dead {
 			minimum = b.value
 		}
 	}
 	for i := 0; i < len(pot.bets); i++ {
 		b := pot.bets[i]
-		if b.value > minimum && b.potNumber == (pot.potNumber-1) {
+		if b.value > minimum && b.potNumber == (pot.potNumber-1) && !b.dead {
 			excess := b.value - minimum
 			b.value = minimum
 			pot.bets = append(pot.bets, Bet{potNumber: pot.potNumber, player: b.player, value: excess})
@@ -93,12 +99,13 @@ func (pot *Pot) makeSidePots() {
 
 func (p *Pot) receiveBet(id guid, bet money) {
 	betSoFar := p.totalPlayerBetThisRound(id)
-	raise := p.raiseAmount(id, bet)
 	if betSoFar+bet > p.totalToCall {
+		raise := p.raiseAmount(id, bet)
 		p.totalToCall = betSoFar + bet
-	}
-	if raise > p.minRaise {
-		p.minRaise = raise
+		p.raises++
+		if raise > p.minRaise {
+			p.minRaise = raise
+		}
 	}
 	newBet := Bet{potNumber: p.potNumber, player: id, value: bet}
 	p.bets = append(p.bets, newBet)
@@ -115,7 +122,7 @@ func (p *Pot) totalInPot() money {
 func (p *Pot) totalPlayerBetThisRound(id guid) money {
 	sum := money(0)
 	for _, bet := range p.bets {
-		if bet.player == id && bet.potNumber == p.potNumber {
+		if bet.player == id && bet.potNumber == p.potNumber && !bet.dead {
 			sum += bet.value
 		}
 	}
@@ -126,6 +133,9 @@ func (p *Pot) totalPlayerBetThisRound(id guid) money {
 func (p *Pot) stakeholders() map[uint][]guid {
 	stakeholders := make(map[uint][]guid)
 	for _, bet := range p.bets {
+		if bet.dead {
+			continue
+		}
 		if _, ok := stakeholders[bet.potNumber]; !ok {
 			stakeholders[bet.potNumber] = make([]guid, 0)
 		}
@@ -152,11 +162,20 @@ func (p *Pot) commitBet(player *Player, bet money) {
 	player.wealth -= bet
 }
 
+// commitDeadMoney decrements player wealth by amount and adds it to the
+// current pot as dead money. Dead money left in the pot when side pots are
+// made stays in the main pot.
+func (p *Pot) commitDeadMoney(player *Player, amount money) {
+	p.bets = append(p.bets, Bet{potNumber: p.potNumber, player: player.guid, value: amount, dead: true})
+	player.wealth -= amount
+}
+
 // betInvalid returns true if the bet is a valid bet, and false if the bet is not valid.
+// A player going all in may raise by less than the minimum raise.
 func (p *Pot) betInvalid(player *Player, bet money) bool {
 	raise := p.raiseAmount(player.guid, bet)
 	return (bet > player.wealth) ||
-		(raise > 0 && raise < p.minRaise) ||
+		(raise > 0 && raise < p.minRaise && bet < player.wealth) ||
 		(bet < player.wealth && (p.totalPlayerBetThisRound(player.guid)+bet) < p.totalToCall)
 }
 
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SNAPSHOT_HANDS is how many hands a logged game plays between snapshots.
+const SNAPSHOT_HANDS = 100
+
+// Snapshot is the state of a game between hands, so that recovering it
+// needs only the events logged since. Events is how many events the log
+// had when it was taken, and Seed is what the game's random number
+// generator was reseeded with.
+type Snapshot struct {
+	GameID     guid                `json:"gameID"`
+	Hand       int                 `json:"hand"`
+	Events     int                 `json:"events"`
+	Seed       int64               `json:"seed"`
+	Rules      Rules               `json:"rules"`
+	Table      []SeatRecord        `json:"table"`
+	SittingOut []SeatRecord        `json:"sitting_out"`
+	Tournament *TournamentSnapshot `json:"tournament,omitempty"`
+	Hands      []*HandHistory      `json:"hands"`
+	Straddlers []guid              `json:"straddlers"`
+	RunItTwice []guid              `json:"run_it_twice"`
+	Muckers    []guid              `json:"muckers"`
+}
+
+// TournamentSnapshot is the progress of a tournament when a Snapshot was
+// taken.
+type TournamentSnapshot struct {
+	Level     int        `json:"level"`
+	Started   time.Time  `json:"started"`
+	Entrants  int        `json:"entrants"`
+	Standings []Standing `json:"standings"`
+	Finished  bool       `json:"finished"`
+}
+
+// snapshotPath returns where the snapshots of the game logged at logPath
+// are kept.
+func snapshotPath(logPath string) string {
+	return strings.TrimSuffix(logPath, ".log") + ".snapshot"
+}
+
+// reseed seeds g's random number generator from itself, and returns the
+// new seed.
+func (g *Game) reseed() int64 {
+	seed := g.random.Int63()
+	g.random = rand.New(rand.NewSource(seed))
+	return seed
+}
+
+// checkpoint snapshots a logged game every SNAPSHOT_HANDS hands. The
+// snapshot is logged first, so a replay reseeds the game where it did.
+func (c *controller) checkpoint(g *Game) {
+	if c.replaying() {
+		if c.replay.snapshot(g.hands) {
+			g.reseed()
+		}
+		return
+	}
+	if c.log == nil || g.hands%SNAPSHOT_HANDS != 0 {
+		return
+	}
+	s := g.snapshot(g.reseed())
+	events, err := c.log.record(LogEvent{Hand: g.hands, Type: "snapshot"})
+	if err != nil {
+		log.Printf("Could not log snapshot of game %v: %v\n", g.gameID, err)
+	}
+	s.Events = events
+	err = writeSnapshot(snapshotPath(c.log.path), s)
+	if err != nil {
+		log.Printf("Could not snapshot game %v: %v\n", g.gameID, err)
+	}
+}
+
+// snapshot returns the state of g, which must be between hands.
+func (g *Game) snapshot(seed int64) *Snapshot {
+	s := &Snapshot{GameID: g.gameID, Hand: g.hands, Seed: seed, Rules: g.rules}
+	s.Table = make([]SeatRecord, 0)
+	for i, p := range g.table {
+		s.Table = append(s.Table, SeatRecord{Seat: i + 1, Player: p.guid, Stack: p.wealth})
+	}
+	s.SittingOut = make([]SeatRecord, 0)
+	for _, p := range g.sittingOut {
+		s.SittingOut = append(s.SittingOut, SeatRecord{Player: p.guid, Stack: p.wealth})
+	}
+	if t := g.tournament; t != nil {
+		s.Tournament = &TournamentSnapshot{Level: t.level, Started: t.started, Entrants: t.entrants, Standings: t.standings, Finished: t.finished}
+	}
+	s.Hands = g.controller.handHistories()
+	c := g.controller
+	c.Lock()
+	defer c.Unlock()
+	s.Straddlers, s.RunItTwice, s.Muckers = players(c.straddlers), players(c.runItTwice), players(c.muckers)
+	return s
+}
+
+func players(set map[guid]bool) []guid {
+	ids := make([]guid, 0)
+	for id := range set {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// restore puts g, fresh from its log, into the state s records.
+func (g *Game) restore(s *Snapshot) {
+	g.hands = s.Hand
+	g.rules = s.Rules
+	g.random = rand.New(rand.NewSource(s.Seed))
+	for _, seat := range s.Table {
+		g.table = append(g.table, NewPlayer(seat.Player, seat.Stack))
+	}
+	for _, seat := range s.SittingOut {
+		g.sittingOut = append(g.sittingOut, NewPlayer(seat.Player, seat.Stack))
+	}
+	if t := g.tournament; t != nil && s.Tournament != nil {
+		t.level, t.started, t.entrants = s.Tournament.Level, s.Tournament.Started, s.Tournament.Entrants
+		t.standings, t.finished = s.Tournament.Standings, s.Tournament.Finished
+	}
+	c := g.controller
+	c.updateSeats(g)
+	c.hands = s.Hands
+	c.entriesClosed = g.tournament != nil && g.tournament.entrants > 0
+	for _, id := range s.Straddlers {
+		c.straddlers[id] = true
+	}
+	for _, id := range s.RunItTwice {
+		c.runItTwice[id] = true
+	}
+	for _, id := range s.Muckers {
+		c.muckers[id] = true
+	}
+	if len(c.hands) > 0 {
+		c.public.LastShowdown = shownHands(c.hands[len(c.hands)-1])
+	}
+	c.refresh(g)
+}
+
+// writeSnapshot writes s to path, replacing any snapshot there only once
+// it is safely on disk.
+func writeSnapshot(path string, s *Snapshot) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(s)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// readSnapshot reads the snapshot at path, returning nil if there is none.
+func readSnapshot(path string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	s := new(Snapshot)
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Recover rebuilds every game logged in gc's log directory, as it was when
+// the server stopped. Each game is restored from its latest snapshot and
+// the events logged since are replayed; a hand left unfinished is played
+// on from where it stopped, with the players asked to act again. Games
+// that can't be recovered are logged, refunded and left out.
+func (gc *GameController) Recover() error {
+	paths, err := filepath.Glob(filepath.Join(gc.logDir, "*.log"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		g, err := gc.recoverGame(path)
+		if err != nil {
+			log.Printf("Could not recover game from %v: %v\n", path, err)
+			gc.refundGame(path)
+			continue
+		}
+		gc.Games[g.gameID] = g
+		log.Printf("Recovered game %v after %v hands\n", g.gameID, g.hands)
+	}
+	return nil
+}
+
+// refundGame gives back the money in play at the game logged at path,
+// which couldn't be recovered: a cash game's stacks, or the prize pool of
+// the multi-table tournament it is a table of, go back to the players who
+// paid them in. Its log is then set aside, so the game can't come back
+// after its players have been paid. A file that isn't a game's log is left
+// alone.
+func (gc *GameController) refundGame(path string) {
+	gameLog, err := readGameLog(path)
+	if err != nil || string(gameLog.GameID)+".log" != filepath.Base(path) {
+		return
+	}
+	if gc.ledger != nil {
+		accounts := []string{gameAccount(gameLog.GameID)}
+		if gameLog.Tournament != "" {
+			accounts = append(accounts, tournamentAccount(gameLog.Tournament))
+		}
+		for _, account := range accounts {
+			err = gc.ledger.refund(account, "refund of unrecoverable game")
+			if err != nil {
+				log.Printf("Could not refund %v after failing to recover game %v: %v\n", account, gameLog.GameID, err)
+				return
+			}
+		}
+	}
+	err = os.Rename(path, path+".failed")
+	if err != nil {
+		log.Printf("Could not set aside log of game %v: %v\n", gameLog.GameID, err)
+	}
+}
+
+// recoverGame rebuilds the game logged at path and sets it playing again.
+func (gc *GameController) recoverGame(path string) (*Game, error) {
+	gameLog, err := readGameLog(path)
+	if err != nil {
+		return nil, err
+	}
+	if gameLog.Tournament != "" {
+		return nil, fmt.Errorf("recovery: game is a table of multi-table tournament %v, which can't be recovered", gameLog.Tournament)
+	}
+	g := newLoggedGame(gc, gameLog)
+	events := gameLog.Events
+	s, err := readSnapshot(snapshotPath(path))
+	if err != nil {
+		log.Printf("Could not read snapshot of game %v; replaying it from the start: %v\n", gameLog.GameID, err)
+	} else if s != nil && s.GameID == gameLog.GameID && s.Events <= len(events) {
+		g.restore(s)
+		events = events[s.Events:]
+	}
+	l, err := openEventLog(path, gameLog)
+	if err != nil {
+		return nil, err
+	}
+	g.controller.log = l
+	r := newReplayer(events, gameLog.Rules.BuyIn)
+	r.resume = true
+	r.resumed = make(chan error, 1)
+	g.controller.replay = r
+	go g.resume(r)
+	err = <-r.resumed
+	if err != nil {
+		l.close()
+		return nil, err
+	}
+	return g, nil
+}
+
+// resume plays g on from its log, and then live. If the log doesn't fit the
+// game, the game stops where the replay halted.
+func (g *Game) resume(r *replayer) {
+	g.controller.replaying()
+	g.run()
+	r.finish(r.err)
+}
+
+// finish reports how resuming a game went, unless it already has been.
+func (r *replayer) finish(err error) {
+	if r.resumed == nil {
+		return
+	}
+	select {
+	case r.resumed <- err:
+	default:
+	}
+}
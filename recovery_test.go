@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecoverGame(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pokerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gc := NewGameController()
+	gc.logDir = dir
+	pg := gc.makeGame(42, mathShuffle, DefaultRules())
+	g := gc.Games[guid(pg.GameID)]
+	for _, id := range []guid{"player-1", "player-2", "player-3"} {
+		g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+	}
+	playHands(t, g, SNAPSHOT_HANDS+5)
+	// leave the game waiting on a player partway through a hand
+	for i := 0; g.controller.turn().Player == ""; i++ {
+		if i == 100 {
+			t.Fatal("game never asked anyone to act")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	crashed := g.controller.publicGame()
+	path := filepath.Join(dir, pg.GameID+".log")
+	if _, err := os.Stat(snapshotPath(path)); err != nil {
+		t.Fatalf("game wasn't snapshotted: %v", err)
+	}
+	// as if the server died writing an event
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"hand":`)
+	f.Close()
+
+	restarted := NewGameController()
+	restarted.logDir = dir
+	if err := restarted.Recover(); err != nil {
+		t.Fatalf("could not recover games: %v", err)
+	}
+	r, ok := restarted.Games[g.gameID]
+	if !ok {
+		t.Fatalf("game %v wasn't recovered", g.gameID)
+	}
+	resumed := r.controller.publicGame()
+	if resumed.Turn.Player != crashed.Turn.Player || resumed.Turn.BetToPlayer != crashed.Turn.BetToPlayer {
+		t.Errorf("recovered game waits on %+v, expected %+v", *resumed.Turn, *crashed.Turn)
+	}
+	if !reflect.DeepEqual(resumed.Table, crashed.Table) {
+		t.Errorf("recovered game has table %+v, expected %+v", resumed.Table, crashed.Table)
+	}
+	sameHands(t, "recovered", r.controller.handHistories(), g.controller.handHistories())
+
+	// the recovered game goes on logging where the old one left off
+	botAct(r, 0)
+	playHands(t, r, SNAPSHOT_HANDS+10)
+	gameLog, err := readGameLog(path)
+	if err != nil {
+		t.Fatalf("could not read game log: %v", err)
+	}
+	replayed, err := Replay(gameLog)
+	if err != nil {
+		t.Fatalf("could not replay recovered game: %v", err)
+	}
+	sameHands(t, "replayed", replayed.controller.handHistories()[:SNAPSHOT_HANDS+10], r.controller.handHistories()[:SNAPSHOT_HANDS+10])
+}
+
+// sameHands checks that got are the expected hands, started at any time.
+// Hands restored from a snapshot have been through JSON, so they are
+// compared as JSON.
+func sameHands(t *testing.T, name string, got, expected []*HandHistory) {
+	if len(got) != len(expected) {
+		t.Fatalf("%v game has %v hands, expected %v", name, len(got), len(expected))
+	}
+	for i, h := range expected {
+		g := *got[i]
+		g.Started = h.Started
+		want, _ := json.Marshal(h)
+		have, _ := json.Marshal(g)
+		if !bytes.Equal(want, have) {
+			t.Errorf("%v game differs at hand %v:\ngot      %s\nexpected %s", name, h.Number, have, want)
+		}
+	}
+}
+
+func TestEventLogRecordsConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pokerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "game.log")
+	l, err := createEventLog(path, NewGame(NewGameController(), 1, DefaultRules()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// shows are logged by requests while the game logs its own events
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := l.record(LogEvent{Hand: i, Type: "show", Player: "player-1"}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	events, err := l.record(LogEvent{Hand: 20, Type: "snapshot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.close()
+	gameLog, err := readGameLog(path)
+	if err != nil {
+		t.Fatalf("could not read game log: %v", err)
+	}
+	if events != 21 || len(gameLog.Events) != 21 {
+		t.Errorf("logged %v events and read back %v, expected 21", events, len(gameLog.Events))
+	}
+}
+
+func TestRecoverRefundsUnrecoverableGames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pokerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ledger := NewLedger()
+
+	// a cash game whose log doesn't fit it, crashed partway through a hand
+	gc := NewGameController()
+	gc.logDir = dir
+	gc.ledger = ledger
+	pg := gc.makeGame(42, mathShuffle, DefaultRules())
+	g := gc.Games[guid(pg.GameID)]
+	for _, id := range []guid{"player-1", "player-2"} {
+		g.controller.enqueuePlayer(g, NewPlayer(id, g.rules.BuyIn))
+	}
+	playHands(t, g, 3)
+	waitUntil(t, "a player was asked to act", func() bool { return g.controller.turn().Player != "" })
+	cashPath := filepath.Join(dir, pg.GameID+".log")
+	f, err := os.OpenFile(cashPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"hand":1,"type":"act","playerID":"player-3"}` + "\n")
+	f.Close()
+
+	// a table of a multi-table tournament, whose buy-ins are in its pool
+	rules := Rules{Tournament: &TournamentRules{HandsPerLevel: 2}}.withDefaults()
+	table := NewGame(NewGameController(), 1, rules)
+	table.tournament.multiTable = "mtt"
+	tablePath := filepath.Join(dir, string(table.gameID)+".log")
+	l, err := createEventLog(tablePath, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.close()
+	for _, id := range []guid{"player-3", "player-4"} {
+		if err := ledger.buyIn(id, tournamentAccount("mtt"), 100, "tournament buy-in"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restarted := NewGameController()
+	restarted.logDir = dir
+	restarted.ledger = ledger
+	if err := restarted.Recover(); err != nil {
+		t.Fatalf("could not recover games: %v", err)
+	}
+	if len(restarted.Games) != 0 {
+		t.Fatalf("recovered %v games, expected neither", len(restarted.Games))
+	}
+	for _, account := range []string{gameAccount(g.gameID), tournamentAccount("mtt")} {
+		if b := ledger.balance(account); b != 0 {
+			t.Errorf("%v still holds %v", account, b)
+		}
+	}
+	if b1, b2 := ledger.balance(userAccount("player-1")), ledger.balance(userAccount("player-2")); b1+b2 != 2*STARTING_BANKROLL {
+		t.Errorf("cash game players have %v and %v, expected %v between them", b1, b2, 2*STARTING_BANKROLL)
+	}
+	for _, id := range []guid{"player-3", "player-4"} {
+		if b := ledger.balance(userAccount(id)); b != STARTING_BANKROLL {
+			t.Errorf("%v has %v, expected their buy-in back", id, b)
+		}
+	}
+	for _, path := range []string{cashPath, tablePath} {
+		if _, err := os.Stat(path + ".failed"); err != nil {
+			t.Errorf("log %v wasn't set aside: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Rules are the table rules a game is played under, including the variant
+// of poker played. They are fixed when the game is made, except that a
+// tournament's blinds and ante rise through its levels.
+type Rules struct {
+	// Variant is the game played: holdem, omaha, omaha8, stud, stud8 or
+	// shortdeck.
+	Variant string `json:"variant"`
+	// Stud games have no blinds: the small blind is the bring-in, and the
+	// big blind is the smallest bet.
+	SmallBlind money `json:"small_blind"`
+	BigBlind   money `json:"big_blind"`
+	Ante       money `json:"ante"`
+	// BigBlindAnte has the big blind ante for the whole table, instead of
+	// every player anteing.
+	BigBlindAnte bool  `json:"big_blind_ante"`
+	BuyIn        money `json:"buy_in"`
+	MaxSeats     int   `json:"max_seats"`
+	// Straddles lets the player after the big blind straddle.
+	Straddles bool `json:"straddles"`
+	// RunItTwice lets players all in before the river agree to deal the
+	// rest of the board twice, with half of each pot going to each board.
+	RunItTwice bool `json:"run_it_twice"`
+	// Betting is the betting structure: no_limit, pot_limit or
+	// fixed_limit. RaiseCap is the most bets and raises a fixed limit
+	// street may have.
+	Betting  string `json:"betting"`
+	RaiseCap int    `json:"raise_cap,omitempty"`
+	// Timeout is the number of seconds a player has to act. A player who
+	// takes longer then draws on their time bank: TimeBank seconds, of
+	// which TimeBankRefill seconds are given back after every hand. They
+	// are left nil for the default, so that zero means no time bank.
+	Timeout        int  `json:"timeout"`
+	TimeBank       *int `json:"time_bank"`
+	TimeBankRefill *int `json:"time_bank_refill"`
+	// Tournament is set if the game is a sit-and-go tournament.
+	Tournament *TournamentRules `json:"tournament,omitempty"`
+}
+
+// MAX_SEATS is the most players a table can seat; any more and a hold'em
+// deal could run out of cards.
+const MAX_SEATS = 10
+
+// DefaultRules returns the rules of a game made without any: no limit
+// hold'em with blinds of 10/20, a buy-in of 10000 and ten seats.
+func DefaultRules() Rules {
+	return Rules{Variant: holdem, SmallBlind: 10, BigBlind: 20, BuyIn: 10000, MaxSeats: MAX_SEATS, Timeout: TIMEOUT,
+		TimeBank: seconds(TIME_BANK), TimeBankRefill: seconds(TIME_BANK_REFILL), Betting: noLimit}
+}
+
+// withDefaults fills in any rule left as zero, and the time bank if it is
+// left unset; a time bank of zero is kept. An unset big blind is twice the
+// small blind, stud is played fixed limit at as many seats as it can be,
+// and fixed limit streets are capped at a bet and three raises.
+func (r Rules) withDefaults() Rules {
+	d := DefaultRules()
+	if r.Variant == "" {
+		r.Variant = d.Variant
+	}
+	if r.SmallBlind == 0 {
+		r.SmallBlind = d.SmallBlind
+	}
+	if r.BigBlind == 0 {
+		r.BigBlind = 2 * r.SmallBlind
+	}
+	if r.BuyIn == 0 {
+		r.BuyIn = d.BuyIn
+	}
+	v := variantNamed(r.Variant)
+	if r.MaxSeats == 0 {
+		r.MaxSeats = v.maxSeats()
+	}
+	if r.Timeout == 0 {
+		r.Timeout = d.Timeout
+	}
+	if r.TimeBank == nil {
+		r.TimeBank = d.TimeBank
+	}
+	if r.TimeBankRefill == nil {
+		r.TimeBankRefill = d.TimeBankRefill
+	}
+	if r.Betting == "" && v.stud() {
+		r.Betting = fixedLimit
+	} else if r.Betting == "" {
+		r.Betting = d.Betting
+	}
+	if r.Betting == fixedLimit && r.RaiseCap == 0 {
+		r.RaiseCap = 4
+	}
+	if r.Tournament != nil {
+		tr := r.Tournament.withDefaults(r.SmallBlind, r.MaxSeats)
+		r.Tournament = &tr
+		r.SmallBlind = tr.Levels[0].SmallBlind
+		r.BigBlind = tr.Levels[0].BigBlind
+		r.Ante = tr.Levels[0].Ante
+	}
+	return r
+}
+
+// validate returns an error, fit to show to whoever asked for the rules,
+// if a game can't be played under them.
+func (r Rules) validate() error {
+	switch {
+	case variants[r.Variant] == nil:
+		return errors.New("Variant must be holdem, omaha, omaha8, stud, stud8 or shortdeck.")
+	case r.BigBlind < r.SmallBlind:
+		return errors.New("The big blind can't be smaller than the small blind.")
+	case r.BuyIn < r.BigBlind:
+		return errors.New("The buy-in must cover at least the big blind.")
+	case r.MaxSeats < 2 || r.MaxSeats > variants[r.Variant].maxSeats():
+		return fmt.Errorf("A %v table must have between 2 and %d seats.", r.Variant, variants[r.Variant].maxSeats())
+	case variants[r.Variant].stud() && (r.Straddles || r.BigBlindAnte):
+		return errors.New("Stud tables have no blinds to straddle or ante with.")
+	case variants[r.Variant].stud() && r.RunItTwice:
+		return errors.New("Stud tables have no board to run twice.")
+	case r.Timeout < 1:
+		return errors.New("Timeout must be at least one second.")
+	case r.timeBank() < 0 || r.timeBankRefill() < 0:
+		return errors.New("Time banks can't hold less than no time.")
+	case bettingStructures[r.Betting] == nil:
+		return errors.New("Betting must be no_limit, pot_limit or fixed_limit.")
+	case r.RaiseCap < 0:
+		return errors.New("The raise cap can't be negative.")
+	case r.Tournament != nil:
+		return r.Tournament.validate(r.MaxSeats)
+	}
+	return nil
+}
+
+func (r Rules) timeout() time.Duration {
+	return time.Duration(r.Timeout) * time.Second
+}
+
+// timeBank returns the time bank the rules give each player, none if they
+// leave it unset.
+func (r Rules) timeBank() time.Duration {
+	if r.TimeBank == nil {
+		return 0
+	}
+	return time.Duration(*r.TimeBank) * time.Second
+}
+
+func (r Rules) timeBankRefill() time.Duration {
+	if r.TimeBankRefill == nil {
+		return 0
+	}
+	return time.Duration(*r.TimeBankRefill) * time.Second
+}
+
+// seconds returns a pointer to n, for setting the time bank rules.
+func seconds(n int) *int {
+	return &n
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -12,41 +14,14 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 )
 
 func Router() *mux.Router {
-	UserMap := NewUserMap()
-	gc := NewGameController()
-	re := ExposeByREST(gc)
-	r := mux.NewRouter()
-
-	users := r.PathPrefix("/users").Subrouter()
-	users.HandleFunc("/", re.makeUser(UserMap)).Methods("POST")
-
-	//user := users.PathPrefix("/{UserID:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}").Subrouter()
-	//user.HandleFunc("/", protector(UserMap, re.updateUser)).Methods("PUT")
-	//user.HandleFunc("/", protector(UserMap, re.removeUser)).Methods("DELETE")
-
-	games := r.PathPrefix("/games").Subrouter()
-	games.HandleFunc("/", re.getGames).Methods("GET")
-	games.HandleFunc("/", protector(UserMap, re.makeGame)).Methods("POST") // consider not allowing users to make games
-
-	game := games.PathPrefix("/{GameID:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}").Subrouter()
-	game.HandleFunc("/", re.getGame).Methods("GET")
-
-	players := game.PathPrefix("/players").Subrouter()
-	players.HandleFunc("/", re.getPlayers).Methods("GET")
-	players.HandleFunc("/", protector(UserMap, re.playerJoinGame(UserMap))).Methods("POST")
-
-	player := players.PathPrefix("/{PlayerID:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}").Subrouter()
-	player.HandleFunc("/", protector(UserMap, re.quitPlayer)).Methods("DELETE")
-
-	acts := player.PathPrefix("/acts").Subrouter()
-	acts.HandleFunc("/", protector(UserMap, re.makeAct)).Methods("POST")
-
-	return r
+	return NewRouter(ExposeByREST(NewGameController()), NewUserMap())
 }
 
 var r *mux.Router
@@ -59,7 +34,7 @@ func init() {
 func WAndReq(method, path, user, pass string) (*httptest.ResponseRecorder, *http.Request) {
 	u := url.URL{Scheme: "http", Host: "localhost:8080", Path: path}
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest(strings.ToUpper(method), u.String(), nil)
+	req := httptest.NewRequest(strings.ToUpper(method), u.String(), nil)
 	req.SetBasicAuth(user, pass)
 	return w, req
 }
@@ -67,7 +42,7 @@ func WAndReq(method, path, user, pass string) (*httptest.ResponseRecorder, *http
 func WAndReqNoAuth(method, path string) (*httptest.ResponseRecorder, *http.Request) {
 	u := url.URL{Scheme: "http", Host: "localhost:8080", Path: path}
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest(strings.ToUpper(method), u.RequestURI(), nil)
+	req := httptest.NewRequest(strings.ToUpper(method), u.String(), nil)
 	return w, req
 }
 
@@ -102,7 +77,7 @@ func TestMakeUserBadHeaderTooManyFields(t *testing.T) {
 	re, UserMap := defaultRE(), NewUserMap()
 	u := url.URL{Scheme: "http", Host: "localhost:8080", Path: "users"}
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest(strings.ToUpper("POST"), u.RequestURI(), nil)
+	req := httptest.NewRequest(strings.ToUpper("POST"), u.String(), nil)
 	req.Header.Add("foo", "bar")
 	req.Header.Add("bar", "foo")
 	re.makeUser(UserMap)(w, req)
@@ -123,7 +98,7 @@ func TestMakeUserBadHeaderNotBasicAuth(t *testing.T) {
 	re, UserMap := defaultRE(), NewUserMap()
 	u := url.URL{Scheme: "http", Host: "localhost:8080", Path: "users"}
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest(strings.ToUpper("POST"), u.RequestURI(), nil)
+	req := httptest.NewRequest(strings.ToUpper("POST"), u.String(), nil)
 	var b bytes.Buffer
 	enc := base64.NewEncoder(base64.StdEncoding, &b)
 	enc.Write([]byte("brian:password"))
@@ -146,7 +121,7 @@ func TestMakeUserBadHeaderNotBase64Encoded(t *testing.T) {
 	re, UserMap := defaultRE(), NewUserMap()
 	u := url.URL{Scheme: "http", Host: "localhost:8080", Path: "users"}
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest(strings.ToUpper("POST"), u.RequestURI(), nil)
+	req := httptest.NewRequest(strings.ToUpper("POST"), u.String(), nil)
 	req.Header.Add("Authorization", "Basic brian:password")
 	re.makeUser(UserMap)(w, req)
 	code, gotMsg, err := parseResponse(w)
@@ -215,14 +190,79 @@ func TestMakeGame(t *testing.T) {
 	re.makeUser(UserMap)(w, req)
 	w, req = WAndReq("POST", "games", "brian", "password")
 	protector(UserMap, re.makeGame)(w, req)
-	if w.Code != http.StatusCreated {
-		t.Errorf("making a game without making a user account first; got %v, expected %v", w.Code, http.StatusCreated)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("making a game; got %v, expected %v", w.Code, http.StatusAccepted)
+	}
+}
+
+func TestMakeGameShuffle(t *testing.T) {
+	srv, _ := newTestServer()
+	defer srv.Close()
+	request(t, srv, "POST", "/users/", "brian", "", http.StatusCreated).Body.Close()
+
+	for query, expected := range map[string]string{"": cryptoShuffle, "?shuffle=math&seed=5": mathShuffle} {
+		resp := request(t, srv, "POST", "/games/"+query, "brian", "", http.StatusAccepted)
+		pg := new(PublicGame)
+		json.NewDecoder(resp.Body).Decode(pg)
+		resp.Body.Close()
+		if pg.Shuffle != expected {
+			t.Errorf("making a game with %q: got the %v shuffle, expected %v", query, pg.Shuffle, expected)
+		}
+	}
+	request(t, srv, "POST", "/games/?seed=5", "brian", "", http.StatusBadRequest).Body.Close()
+}
+
+func TestMakeGameFromForm(t *testing.T) {
+	srv, _ := newTestServer()
+	defer srv.Close()
+	request(t, srv, "POST", "/users/", "brian", "", http.StatusCreated).Body.Close()
+
+	req, err := http.NewRequest("POST", srv.URL+"/games/", strings.NewReader("shuffle=math&seed=5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("brian", "password")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("making a game from a form: got %v, expected %v", resp.StatusCode, http.StatusAccepted)
+	}
+	pg := new(PublicGame)
+	json.NewDecoder(resp.Body).Decode(pg)
+	if pg.Shuffle != mathShuffle {
+		t.Errorf("making a game from a form: got the %v shuffle, expected %v", pg.Shuffle, mathShuffle)
 	}
 }
 
+func TestCalculateEquityOverHTTP(t *testing.T) {
+	srv, _ := newTestServer()
+	defer srv.Close()
+
+	// only the two kings left in the deck beat the aces on the river
+	body := `{"variant":"holdem","players":[["AS","AH"],["KS","KH"]],"board":["2C","7D","9H","JC"]}`
+	resp := request(t, srv, "POST", "/equity/", "", body, http.StatusOK)
+	var result EquityResult
+	err := json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("could not read equities: %v", err)
+	}
+	if !result.Exhaustive || result.Boards != 44 || len(result.Players) != 2 ||
+		result.Players[0].Win != 42.0/44 || result.Players[1].Win != 2.0/44 {
+		t.Errorf("got %+v, expected aces to win on 42 of 44 rivers and kings on 2", result)
+	}
+
+	request(t, srv, "POST", "/equity/", "", `{"players":[["AS","AX"],["KS","KH"]]}`, http.StatusBadRequest).Body.Close()
+	request(t, srv, "POST", "/equity/", "", `{"players":[["AS","AH"]]}`, http.StatusBadRequest).Body.Close()
+}
+
 func TestPlayerJoinNonexistentGameNoAuth(t *testing.T) {
 	w, req, re, UserMap := defaults("POST", "games/fbbbbb44-bc4f-c3f6-6519-d81bd1a66d8a/players", "brian", "password")
-	protector(UserMap, re.playerJoinGame(UserMap))(w, req)
+	protector(UserMap, re.playerJoinGame)(w, req)
 	if w.Code != http.StatusUnauthorized {
 		t.Errorf("making a game without making a user account first; got %v, expected %v", w.Code, http.StatusUnauthorized)
 	}
@@ -232,7 +272,7 @@ func TestPlayerJoinNonexistentGameWrongAuth(t *testing.T) {
 	w, req, re, UserMap := defaults("POST", "users", "brian", "password")
 	re.makeUser(UserMap)(w, req)
 	w, req = WAndReq("POST", "games/fbbbbb44-bc4f-c3f6-6519-d81bd1a66d8a/players", "brian", "wrongpassword")
-	protector(UserMap, re.playerJoinGame(UserMap))(w, req)
+	protector(UserMap, re.playerJoinGame)(w, req)
 	if w.Code != http.StatusUnauthorized {
 		t.Errorf("making a game without making a user account first; got %v, expected %v", w.Code, http.StatusUnauthorized)
 	}
@@ -242,7 +282,7 @@ func TestPlayerJoinNonexistentGame(t *testing.T) {
 	w, req, re, UserMap := defaults("POST", "users", "brian", "password")
 	re.makeUser(UserMap)(w, req)
 	w, req = WAndReq("POST", "games/fbbbbb44-bc4f-c3f6-6519-d81bd1a66d8a/players", "brian", "password")
-	protector(UserMap, re.playerJoinGame(UserMap))(w, req)
+	protector(UserMap, re.playerJoinGame)(w, req)
 	if w.Code != http.StatusNotFound {
 		t.Errorf("making a game without making a user account first; got %v, expected %v", w.Code, http.StatusNotFound)
 	}
@@ -254,7 +294,7 @@ func TestPlayerJoinGameWithoutAuthenticating(t *testing.T) {
 	w, req = WAndReq("POST", "games", "brian", "password")
 	protector(UserMap, re.makeGame)(w, req)
 	w, req = WAndReqNoAuth("POST", "games/fbbbbb44-bc4f-c3f6-6519-d81bd1a66d8a/players")
-	protector(UserMap, re.playerJoinGame(UserMap))(w, req)
+	protector(UserMap, re.playerJoinGame)(w, req)
 	if w.Code != http.StatusBadRequest {
 		t.Errorf("making a game without making a user account first; got %v, expected %v", w.Code, http.StatusBadRequest)
 	}
@@ -266,59 +306,52 @@ func TestPlayerJoinGameBadAuth(t *testing.T) {
 	w, req = WAndReq("POST", "games", "brian", "password")
 	protector(UserMap, re.makeGame)(w, req)
 	w, req = WAndReq("POST", "games/fbbbbb44-bc4f-c3f6-6519-d81bd1a66d8a/players", "brian", "wrongpassword")
-	protector(UserMap, re.playerJoinGame(UserMap))(w, req)
+	protector(UserMap, re.playerJoinGame)(w, req)
 	if w.Code != http.StatusUnauthorized {
 		t.Errorf("making a game without making a user account first; got %v, expected %v", w.Code, http.StatusUnauthorized)
 	}
 }
 
 func TestPlayerJoinGame(t *testing.T) {
-	w, req := WAndReq("POST", "users", "brian", "password")
+	w, req := WAndReq("POST", "users/", "brian", "password")
 	r = Router()
 	r.ServeHTTP(w, req)
 	if w.Code != http.StatusCreated {
 		t.Errorf("got code %v, expected code %v", w.Code, http.StatusCreated)
 	}
-	w, req = WAndReq("POST", "games", "brian", "password")
+	w, req = WAndReq("POST", "games/", "brian", "password")
 	r.ServeHTTP(w, req)
-	if w.Code != http.StatusCreated {
-		t.Errorf("got code %v, expected code %v", w.Code, http.StatusCreated)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("got code %v, expected code %v", w.Code, http.StatusAccepted)
 	}
+	// making the game joined brian to it
 	gameID := extractGameID(w)
-	w, req = WAndReq("POST", "games/"+gameID+"/players", "brian", "password")
+	w, req = WAndReq("POST", "games/"+gameID+"/players/", "brian", "password")
 	r.ServeHTTP(w, req)
-	if w.Code != http.StatusCreated {
-		t.Errorf("making a game without making a user account first; got %v, expected %v", w.Code, http.StatusCreated)
+	if w.Code != http.StatusConflict {
+		t.Errorf("player joining game twice; got %v, expected %v", w.Code, http.StatusConflict)
 	}
 }
 
 func TestTwoPlayersJoinGame(t *testing.T) {
-	re, UserMap := defaultRE(), NewUserMap()
-	w, req := WAndReq("POST", "users", "brian", "password")
-	re.makeUser(UserMap)(w, req)
-	if w.Code != http.StatusCreated {
-		t.Errorf("making player correctly; got %v, expected %v", w.Code, http.StatusCreated)
-	}
-	w, req = WAndReq("POST", "users", "jake", "password")
-	re.makeUser(UserMap)(w, req)
-	if w.Code != http.StatusCreated {
-		t.Errorf("making player correctly; got %v, expected %v", w.Code, http.StatusCreated)
-	}
-	w, req = WAndReq("POST", "games", "brian", "password")
-	protector(UserMap, re.makeGame)(w, req)
-	if w.Code != http.StatusCreated {
-		t.Errorf("making game correctly; got %v, expected %v", w.Code, http.StatusCreated)
+	r := Router()
+	for _, handle := range []string{"brian", "jake"} {
+		w, req := WAndReq("POST", "users/", handle, "password")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Errorf("making player correctly; got %v, expected %v", w.Code, http.StatusCreated)
+		}
+	}
+	w, req := WAndReq("POST", "games/", "brian", "password")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("making game correctly; got %v, expected %v", w.Code, http.StatusAccepted)
 	}
 	gameID := extractGameID(w)
-	w, req = WAndReq("POST", "games/"+gameID+"/players", "brian", "password")
-	protector(UserMap, re.playerJoinGame(UserMap))(w, req)
-	if w.Code != http.StatusCreated {
-		t.Errorf("player joining game correctly; got %v, expected %v", w.Code, http.StatusCreated)
-	}
-	w, req = WAndReq("POST", "games/"+gameID+"/players", "jake", "password")
-	protector(UserMap, re.playerJoinGame(UserMap))(w, req)
-	if w.Code != http.StatusCreated {
-		t.Errorf("player joining game correctly; got %v, expected %v", w.Code, http.StatusCreated)
+	w, req = WAndReq("POST", "games/"+gameID+"/players/", "jake", "password")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("player joining game correctly; got %v, expected %v", w.Code, http.StatusAccepted)
 	}
 }
 
@@ -332,3 +365,258 @@ func TestGetGames(t *testing.T) {
 	fmt.Println("getting games...")
 	fmt.Println(w.Body.String())
 }
+
+// newTestServer serves the whole API from a fresh game controller.
+func newTestServer() (*httptest.Server, *GameController) {
+	gc := NewGameController()
+	return httptest.NewServer(NewRouter(ExposeByREST(gc), NewUserMap())), gc
+}
+
+// request makes a request of srv as handle, whose password is "password",
+// and fails the test unless it gets the expected status code.
+func request(t *testing.T, srv *httptest.Server, method, path, handle, body string, expected int) *http.Response {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(handle, "password")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%v %v: %v", method, path, err)
+	}
+	if resp.StatusCode != expected {
+		t.Fatalf("%v %v: got %v, expected %v", method, path, resp.StatusCode, expected)
+	}
+	return resp
+}
+
+// startHeadsUp registers two players with srv and seats them in a new game,
+// returning the game's ID and the players' IDs by handle.
+func startHeadsUp(t *testing.T, srv *httptest.Server) (string, map[string]guid) {
+	ids := make(map[string]guid)
+	for _, handle := range []string{"brian", "jake"} {
+		resp := request(t, srv, "POST", "/users/", handle, "", http.StatusCreated)
+		var body struct{ PlayerID guid }
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		ids[handle] = body.PlayerID
+	}
+	resp := request(t, srv, "POST", "/games/", "brian", "", http.StatusAccepted)
+	gameID := extractGameIDFrom(resp)
+	request(t, srv, "POST", "/games/"+gameID+"/players/", "jake", "", http.StatusAccepted).Body.Close()
+	return gameID, ids
+}
+
+func extractGameIDFrom(resp *http.Response) string {
+	defer resp.Body.Close()
+	pg := new(PublicGame)
+	json.NewDecoder(resp.Body).Decode(pg)
+	return pg.GameID
+}
+
+// handleOf returns the handle of the player with id.
+func handleOf(ids map[string]guid, id guid) string {
+	for handle, player := range ids {
+		if player == id {
+			return handle
+		}
+	}
+	return ""
+}
+
+// listening returns how many listeners g has.
+func listening(g *Game) int {
+	g.controller.Lock()
+	defer g.controller.Unlock()
+	return len(g.controller.listeners)
+}
+
+// waitUntil fails the test unless done returns true within a few seconds.
+func waitUntil(t *testing.T, what string, done func() bool) {
+	for i := 0; !done(); i++ {
+		if i == 500 {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamGame(t *testing.T) {
+	srv, gc := newTestServer()
+	defer srv.Close()
+	gameID, ids := startHeadsUp(t, srv)
+	g := gc.Games[guid(gameID)]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequest("GET", srv.URL+"/games/"+gameID+"/events/", nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("could not subscribe: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("got content type %q, expected text/event-stream", ct)
+	}
+	events := make(chan PublicGame)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			var pg PublicGame
+			if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &pg) == nil {
+				events <- pg
+			}
+		}
+	}()
+	next := func(what string, match func(pg PublicGame) bool) PublicGame {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case pg, ok := <-events:
+				if !ok {
+					t.Fatalf("stream closed waiting for %v", what)
+				}
+				if match(pg) {
+					return pg
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for %v", what)
+			}
+		}
+	}
+
+	// the first player to act calls, and the stream shows it's the other's turn
+	first := next("the first turn", func(pg PublicGame) bool { return pg.Turn.Player != "" })
+	actor := first.Turn.Player
+	act := fmt.Sprintf(`{"Action":%v,"BetAmount":%v}`, call, first.Turn.BetToPlayer-first.Turn.PlayerBet)
+	request(t, srv, "POST", "/games/"+gameID+"/players/"+string(actor)+"/acts/", handleOf(ids, actor), act, http.StatusCreated).Body.Close()
+	second := next("the second turn", func(pg PublicGame) bool { return pg.Turn.Player != "" && pg.Turn.Player != actor })
+	for _, p := range second.Table {
+		if p.GUID == actor && p.InFor != first.Turn.BetToPlayer {
+			t.Errorf("stream shows %v in for %v after calling, expected %v", actor, p.InFor, first.Turn.BetToPlayer)
+		}
+	}
+
+	// once the client goes away, so does the stream
+	before := listening(g)
+	cancel()
+	waitUntil(t, "the stream to close", func() bool { return listening(g) == before-1 })
+}
+
+func TestPlayerSocket(t *testing.T) {
+	srv, gc := newTestServer()
+	defer srv.Close()
+	gameID, ids := startHeadsUp(t, srv)
+	g := gc.Games[guid(gameID)]
+
+	// each player connects to their seat, and reads what they are sent
+	conns := make(map[guid]*websocket.Conn)
+	messages := make(chan SocketMessage)
+	done := make(chan struct{})
+	defer close(done)
+	for handle, id := range ids {
+		header := http.Header{}
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(handle+":password")))
+		u := "ws" + strings.TrimPrefix(srv.URL, "http") + "/games/" + gameID + "/players/" + string(id) + "/socket/"
+		conn, _, err := websocket.DefaultDialer.Dial(u, header)
+		if err != nil {
+			t.Fatalf("%v could not connect: %v", handle, err)
+		}
+		defer conn.Close()
+		conns[id] = conn
+		go func(conn *websocket.Conn) {
+			for {
+				var msg SocketMessage
+				if conn.ReadJSON(&msg) != nil {
+					return
+				}
+				select {
+				case messages <- msg:
+				case <-done:
+					return
+				}
+			}
+		}(conn)
+	}
+	next := func(what string, match func(msg SocketMessage) bool) SocketMessage {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case msg := <-messages:
+				if match(msg) {
+					return msg
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for %v", what)
+			}
+		}
+	}
+
+	// the first player to act calls over their socket, and the other is told
+	// it's their turn
+	first := next("the first turn", func(msg SocketMessage) bool { return msg.Type == "turn" })
+	actor := first.Game.Turn.Player
+	err := conns[actor].WriteJSON(Act{Action: call, BetAmount: first.Game.Turn.BetToPlayer - first.Game.Turn.PlayerBet})
+	if err != nil {
+		t.Fatalf("could not send act: %v", err)
+	}
+	next("the second turn", func(msg SocketMessage) bool { return msg.Type == "turn" && msg.Game.Turn.Player != actor })
+
+	// acting out of turn is refused over the socket
+	conns[actor].WriteJSON(Act{Action: fold})
+	refused := next("the refusal", func(msg SocketMessage) bool { return msg.Type == "error" })
+	if refused.Error.Code != notYourTurn {
+		t.Errorf("got error %+v, expected %v", *refused.Error, notYourTurn)
+	}
+
+	// once a client goes away, the server lets go of its seat's socket
+	before := listening(g)
+	conns[actor].Close()
+	waitUntil(t, "the socket to close", func() bool {
+		if listening(g) != before-1 {
+			return false
+		}
+		g.controller.Lock()
+		defer g.controller.Unlock()
+		_, connected := g.controller.sockets[actor]
+		return !connected
+	})
+}
+
+func TestSittingOutPlayerSeesOwnGame(t *testing.T) {
+	srv, gc := newTestServer()
+	defer srv.Close()
+	gameID, ids := startHeadsUp(t, srv)
+	g := gc.Games[guid(gameID)]
+	brian := string(ids["brian"])
+
+	// brian sits out, and whoever's turn it is folds until the hand is over
+	request(t, srv, "POST", "/games/"+gameID+"/players/"+brian+"/sit_out/", "brian", `{"sit_out":true}`, http.StatusNoContent).Body.Close()
+	waitUntil(t, "brian to sit out", func() bool {
+		if turn := gc.getGame(g.gameID).Turn; turn.Player != "" {
+			g.controller.registerPlayerAct(Act{Player: turn.Player, Action: fold})
+		}
+		return g.controller.hasSeat(ids["brian"]) && !g.controller.isSeated(ids["brian"])
+	})
+
+	// he can still see his own view of the game, and connect to his seat
+	request(t, srv, "GET", "/games/"+gameID+"/", "brian", "", http.StatusOK).Body.Close()
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("brian:password")))
+	u := "ws" + strings.TrimPrefix(srv.URL, "http") + "/games/" + gameID + "/players/" + brian + "/socket/"
+	conn, _, err := websocket.DefaultDialer.Dial(u, header)
+	if err != nil {
+		t.Fatalf("brian could not connect while sitting out: %v", err)
+	}
+	conn.Close()
+
+	// someone who never joined still can't
+	request(t, srv, "POST", "/users/", "dave", "", http.StatusCreated).Body.Close()
+	request(t, srv, "GET", "/games/"+gameID+"/", "dave", "", http.StatusForbidden).Body.Close()
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Shuffle modes. Every hand's deck is shuffled from a 32-byte hand seed.
+// In mathShuffle games the hand seeds come from the game's seeded random
+// number generator, so the whole game can be replayed from its seed. In
+// cryptoShuffle games they come from crypto/rand and cannot be predicted.
+const (
+	mathShuffle   = "math"
+	cryptoShuffle = "crypto"
+)
+
+// newHandSeed returns the seed the next hand's deck will be shuffled from.
+func (g *Game) newHandSeed() []byte {
+	seed := make([]byte, 32)
+	switch {
+	case g.shuffle == cryptoShuffle && g.controller.replaying():
+		seed = g.controller.replay.handSeed(g.hands)
+	case g.shuffle == cryptoShuffle:
+		_, err := cryptorand.Read(seed)
+		if err != nil {
+			panic(err)
+		}
+		g.controller.record(LogEvent{Hand: g.hands, Type: "shuffle", Seed: hex.EncodeToString(seed)})
+	default:
+		for i := 0; i < len(seed); i += 8 {
+			binary.BigEndian.PutUint64(seed[i:], g.random.Uint64())
+		}
+	}
+	return seed
+}
+
+// cardSalt returns the salt the card at position in a hand's shuffled
+// deck is committed with: HMAC-SHA256 of the position, as a big-endian
+// 64-bit number, keyed with the hand seed.
+func cardSalt(seed []byte, position int) []byte {
+	mac := hmac.New(sha256.New, seed)
+	binary.Write(mac, binary.BigEndian, uint64(position))
+	return mac.Sum(nil)
+}
+
+// cardCommitment returns the hex-encoded SHA-256 hash of salt followed by
+// the card's name.
+func cardCommitment(salt []byte, c Card) string {
+	sum := sha256.Sum256(append(append(make([]byte, 0), salt...), c.String()...))
+	return hex.EncodeToString(sum[:])
+}
+
+// deckCommitments returns the commitment to each card of deck shuffled
+// from seed, in the order the cards are dealt.
+func deckCommitments(seed []byte, deck []Card) []string {
+	commitments := make([]string, len(deck))
+	for position, i := range shuffleOrder(seed, len(deck)) {
+		commitments[position] = cardCommitment(cardSalt(seed, position), deck[i])
+	}
+	return commitments
+}
+
+// commitment returns the hex-encoded SHA-256 hash of the card commitments
+// of a deck, joined in order. It is published before the hand is played,
+// and the card commitments and the salts of the cards shown are revealed
+// after, so anyone can check that the cards shown were fixed before anyone
+// acted without learning anything about the cards that weren't.
+func commitment(cards []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(cards, "")))
+	return hex.EncodeToString(sum[:])
+}
+
+// RevealedCard opens the commitment to the card dealt from Position in a
+// hand's deck, counting from 0.
+type RevealedCard struct {
+	Position int    `json:"position"`
+	Card     Card   `json:"card"`
+	Salt     string `json:"salt"`
+}
+
+// reveal returns the commitment to every card of the hand's deck, and
+// opens those of the cards in visible, in the order they were dealt.
+func (h *HandHistory) reveal(visible []Card) ([]string, []RevealedCard) {
+	seed, err := hex.DecodeString(h.Seed)
+	if err != nil || len(seed) == 0 {
+		return nil, nil
+	}
+	deck := variantNamed(h.Variant).deck()
+	revealed := make([]RevealedCard, 0)
+	for position, i := range shuffleOrder(seed, len(deck)) {
+		for _, c := range visible {
+			if c == deck[i] {
+				revealed = append(revealed, RevealedCard{Position: position, Card: c, Salt: hex.EncodeToString(cardSalt(seed, position))})
+				break
+			}
+		}
+	}
+	return deckCommitments(seed, deck), revealed
+}
+
+// shuffleOrder returns a permutation of [0, n) determined by seed: a
+// Fisher-Yates shuffle, from the last position down, drawing from the
+// stream of SHA-256(seed || counter) blocks.
+func shuffleOrder(seed []byte, n int) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	stream := &hashStream{seed: seed}
+	for i := n - 1; i > 0; i-- {
+		j := stream.intn(i + 1)
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// hashStream is a stream of pseudo-random numbers made by hashing a seed
+// followed by a big-endian 64-bit counter.
+type hashStream struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+func (s *hashStream) uint64() uint64 {
+	if len(s.buf) < 8 {
+		block := make([]byte, len(s.seed)+8)
+		copy(block, s.seed)
+		binary.BigEndian.PutUint64(block[len(s.seed):], s.counter)
+		s.counter++
+		sum := sha256.Sum256(block)
+		s.buf = sum[:]
+	}
+	v := binary.BigEndian.Uint64(s.buf)
+	s.buf = s.buf[8:]
+	return v
+}
+
+// intn returns a uniformly distributed number in [0, n), discarding draws
+// that would bias the result.
+func (s *hashStream) intn(n int) int {
+	limit := math.MaxUint64 - math.MaxUint64%uint64(n)
+	for {
+		v := s.uint64()
+		if v < limit {
+			return int(v % uint64(n))
+		}
+	}
+}
+
+// Verification is the result of checking a finished hand against the
+// commitments revealed for it.
+type Verification struct {
+	Hand       int    `json:"hand"`
+	Commitment string `json:"commitment"`
+	Revealed   int    `json:"revealed"`
+	Valid      bool   `json:"valid"`
+	Problem    string `json:"problem,omitempty"`
+}
+
+// VerifyHand checks that the card commitments revealed for a hand match
+// the commitment published before it, that every card revealed matches its
+// commitment, and that the cards shown in the history were dealt from
+// where they were revealed. Only the cards shown can be checked: the
+// others stay hidden, so the randomness of the shuffle can't be.
+func VerifyHand(h *HandHistory) Verification {
+	v := Verification{Hand: h.Number, Commitment: h.Commitment}
+	if len(h.Cards) == 0 {
+		v.Problem = "the hand's card commitments have not been revealed"
+		return v
+	}
+	if commitment(h.Cards) != h.Commitment {
+		v.Problem = "the card commitments do not match the commitment"
+		return v
+	}
+	dealt := make(map[int]Card)
+	for _, r := range h.Revealed {
+		salt, err := hex.DecodeString(r.Salt)
+		if err != nil || r.Position < 0 || r.Position >= len(h.Cards) || cardCommitment(salt, r.Card) != h.Cards[r.Position] {
+			v.Problem = fmt.Sprintf("the card revealed at position %v does not match its commitment", r.Position)
+			return v
+		}
+		dealt[r.Position] = r.Card
+	}
+	v.Revealed = len(dealt)
+	// cards returns the cards revealed at positions [from, to), and whether
+	// they all were
+	cards := func(from, to int) ([]Card, bool) {
+		revealed := make([]Card, 0)
+		for i := from; i < to; i++ {
+			c, ok := dealt[i]
+			if !ok {
+				return nil, false
+			}
+			revealed = append(revealed, c)
+		}
+		return revealed, true
+	}
+
+	variant := variantNamed(h.Variant)
+	holeCards := variant.holeCards()
+	for i, s := range h.Seats {
+		if hole, ok := h.Hole[s.Player]; ok {
+			// players who fold in stud aren't dealt the rest
+			expected, ok := cards(i*holeCards, i*holeCards+len(hole))
+			// hands logged before decks were dealt in order show hole
+			// cards, and the flop, sorted by name
+			if !ok || len(hole) > holeCards || !sameCards(expected, hole) {
+				v.Problem = "the hole cards dealt to " + string(s.Player) + " are not the ones revealed"
+				return v
+			}
+		}
+		for _, c := range h.Upcards[s.Player] {
+			found := false
+			for n := 0; n < holeCards; n++ {
+				found = found || dealt[i*holeCards+n] == c
+			}
+			if !found {
+				v.Problem = "the cards dealt face up to " + string(s.Player) + " are not the ones revealed"
+				return v
+			}
+		}
+	}
+	n := len(h.Seats) * holeCards
+	board, valid := cards(n, n+len(h.Board))
+	valid = valid && sameCards(board, h.Board)
+	for i := 3; valid && i < len(board); i++ {
+		// the turn and river are dealt one at a time, after the flop
+		valid = board[i] == h.Board[i]
+	}
+	if !valid {
+		v.Problem = "the board dealt is not the one revealed"
+		return v
+	}
+	if len(h.SecondBoard) > 0 {
+		// the second run shares the board dealt before the players were
+		// all in, and is dealt the rest from after the first board
+		shared := 0
+		for shared < len(h.Board) && shared < len(h.SecondBoard) && h.Board[shared] == h.SecondBoard[shared] {
+			shared++
+		}
+		from := n + variant.boardCards()
+		second, ok := cards(from, from+len(h.SecondBoard)-shared)
+		valid = ok && len(h.SecondBoard) == len(h.Board)
+		for i, card := range h.SecondBoard[shared:] {
+			valid = valid && second[i] == card
+		}
+		if !valid {
+			v.Problem = "the second board dealt is not the one revealed"
+			return v
+		}
+	}
+	v.Valid = true
+	return v
+}
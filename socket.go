@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	mux "github.com/gorilla/mux"
+	websocket "github.com/gorilla/websocket"
+)
+
+// SocketMessage is what the server sends over a player's socket. Type is
+// "turn" when the game is waiting on this player, "game" for any other
+// change of state, and "error" when an act was refused or the player
+// couldn't buy in to take their seat.
+type SocketMessage struct {
+	Type  string      `json:"type"`
+	Game  *PublicGame `json:"game,omitempty"`
+	Error *ActError   `json:"error,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+
+// playerSocket upgrades the request to a WebSocket over which the player is
+// sent the game every time it changes and may submit acts.
+func (re RestExposer) playerSocket(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
+	vars := mux.Vars(r)
+	g, ok := re.gc.Games[guid(vars["GameID"])]
+	if !ok {
+		http.Error(w, "Game not found.", http.StatusNotFound)
+		return
+	}
+	playerID := guid(vars["PlayerID"])
+	if playerID != verifiedPlayerID {
+		http.Error(w, "You may only connect to your own seat.", http.StatusForbidden)
+		return
+	}
+	if !g.controller.hasJoined(playerID) {
+		http.Error(w, "This player hasn't joined this game. Join game before connecting.", http.StatusForbidden)
+		return
+	}
+	rejections, err := g.controller.connectSocket(playerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	defer g.controller.disconnectSocket(playerID)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client.
+		return
+	}
+	defer conn.Close()
+
+	updates := g.controller.listen()
+	defer g.controller.unlisten(updates)
+	replies := make(chan *ActError)
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go readActs(conn, g, playerID, replies, closed, done)
+
+	err = re.sendGame(conn, g, playerID)
+	for err == nil {
+		select {
+		case <-updates:
+			err = re.sendGame(conn, g, playerID)
+		case e := <-replies:
+			err = conn.WriteJSON(SocketMessage{Type: "error", Error: e})
+		case e := <-rejections:
+			err = conn.WriteJSON(SocketMessage{Type: "error", Error: e})
+		case <-closed:
+			return
+		}
+	}
+}
+
+// sendGame writes the player's view of the game to the socket.
+func (re RestExposer) sendGame(conn *websocket.Conn, g *Game, player guid) error {
+	pg := re.gc.getGamePrivate(g.gameID, player)
+	msg := SocketMessage{Type: "game", Game: &pg}
+	if pg.Turn.Player == player {
+		msg.Type = "turn"
+	}
+	return conn.WriteJSON(msg)
+}
+
+// readActs reads acts from the socket and hands them to the game until the
+// connection closes. Refused acts are passed back on replies so that only
+// one goroutine ever writes to the connection.
+func readActs(conn *websocket.Conn, g *Game, player guid, replies chan<- *ActError, closed chan<- struct{}, done <-chan struct{}) {
+	defer close(closed)
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		act := &Act{}
+		err = json.Unmarshal(data, act)
+		if err != nil {
+			err = &ActError{Code: badRequest, Message: "Couldn't read act: " + err.Error()}
+		} else {
+			act.Player = player
+			err = g.controller.registerPlayerAct(*act)
+		}
+		e, ok := err.(*ActError)
+		if !ok {
+			if err != nil {
+				log.Printf("Unexpected error registering act from socket: %v\n", err)
+			}
+			continue
+		}
+		select {
+		case replies <- e:
+		case <-done:
+			return
+		}
+	}
+}
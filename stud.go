@@ -0,0 +1,182 @@
+package main
+
+// STUD_MAX_SEATS is the most players a Seven Card Stud table can seat;
+// any more and the deck could run out before seventh street.
+const STUD_MAX_SEATS = 7
+
+var studStreets = []string{"THIRD", "FOURTH", "FIFTH", "SIXTH", "SEVENTH"}
+
+// studVariant is Seven Card Stud. There is no board and there are no
+// blinds. Each player is dealt two cards down and one up on third street,
+// one up on each of fourth, fifth and sixth streets, and a last one down
+// on seventh street, and plays the best five of their seven. The player
+// showing the lowest card brings in for the small blind, and after that
+// the player showing the best hand acts first on each street.
+type studVariant struct{}
+
+func (studVariant) name() string      { return "7 Card Stud" }
+func (studVariant) holeCards() int    { return 7 }
+func (studVariant) boardCards() int   { return 0 }
+func (studVariant) streets() []string { return studStreets }
+func (studVariant) stud() bool        { return true }
+func (studVariant) hiLo() bool        { return false }
+func (studVariant) maxSeats() int     { return STUD_MAX_SEATS }
+func (studVariant) deck() []Card      { return UNSHUFFLED[:] }
+
+func (studVariant) ranking() handRanking { return standardRanking }
+
+func (studVariant) value(hole, board []Card) handValue {
+	return standardRanking.tables.evaluate(evalCards(hole))
+}
+
+func (v studVariant) best(hole, board []Card) Hand {
+	return standardRanking.madeHand(hole, v.value(hole, board))
+}
+
+func (studVariant) hands(hole, board []Card) []Hand {
+	return nChooseK(hole, 5)
+}
+
+// studHiLoVariant is Seven Card Stud Hi/Lo, eight or better: each pot is
+// split between the best high hand and the best low, each made from any
+// five of a player's seven cards.
+type studHiLoVariant struct {
+	studVariant
+}
+
+func (studHiLoVariant) name() string { return "7 Card Stud Hi/Lo" }
+func (studHiLoVariant) hiLo() bool   { return true }
+
+// studDeal returns the street, counting third street as 0, and face of
+// the n'th card dealt to a player.
+func studDeal(n int) (street int, up bool) {
+	switch {
+	case n < 2:
+		return 0, false
+	case n < 6:
+		return n - 2, true
+	default:
+		return 4, false
+	}
+}
+
+// dealStud deals each player seven cards from deck in the shuffled order,
+// the i'th player getting the i'th seven. They are only turned over to
+// players as each street is reached.
+func (g *Game) dealStud(deck []Card, order []int) {
+	for i, p := range g.table {
+		for n := 0; n < 7; n++ {
+			street, up := studDeal(n)
+			g.deck.deal(deck[order[i*7+n]], location{place: hole, player: p.guid, street: street, up: up})
+		}
+	}
+}
+
+// studCards returns the cards dealt to player on street, down cards first.
+func (g *Game) studCards(player guid, street int) (cards, up []Card) {
+	up = g.deck.at(location{place: hole, player: player, street: street, up: true})
+	return append(g.deck.at(location{place: hole, player: player, street: street}), up...), up
+}
+
+// betBringIn makes the player showing the lowest card bring in for the
+// small blind, or all in if they can't cover it. Aces are high, and ties
+// go by suit: clubs, diamonds, hearts, then spades.
+func (g *Game) betBringIn() {
+	var lowest Card
+	for _, p := range g.table {
+		_, up := g.studCards(p.guid, 0)
+		if lowest == 0 || cardLess(up[0], lowest) {
+			lowest = up[0]
+			g.bringer = p
+		}
+	}
+	player := g.bringer
+	bringIn := g.rules.SmallBlind
+	if player.wealth < bringIn {
+		bringIn = player.wealth
+	}
+	g.history.act(0, player.guid, "bring_in", bringIn, bringIn, bringIn == player.wealth)
+	g.pot.commitBet(player, bringIn)
+	// completing the bring-in to the big blind is the first bet
+	g.pot.raises = 0
+}
+
+// cardLess returns true if card a is lower than card b for the bring-in.
+func cardLess(a, b Card) bool {
+	if a.rank() != b.rank() {
+		return a.rank() < b.rank()
+	}
+	return a.suit() < b.suit()
+}
+
+// studFirstToAct returns the index of the player who acts first this
+// street: after the bring-in on third street, and after that whoever still
+// in the hand shows the best hand, the first of them if they tie.
+func (g *Game) studFirstToAct() int {
+	if g.round == 0 {
+		for i, p := range g.table {
+			if p == g.bringer {
+				return (i + 1) % len(g.table)
+			}
+		}
+		return 0
+	}
+	best := -1
+	var bestRank uint
+	var bestCounts []int
+	for i, p := range g.table {
+		if p.state == folded {
+			continue
+		}
+		rank, counts := showingRank(g.history.Upcards[p.guid])
+		if best == -1 || rank > bestRank || (rank == bestRank && len(counts) == len(bestCounts) && gt(counts, bestCounts)) {
+			best, bestRank, bestCounts = i, rank, counts
+		}
+	}
+	if best == -1 {
+		return 0
+	}
+	return best
+}
+
+// showingRank ranks upcards as handRank would rank a hand of them, though
+// with fewer than five cards only pairs, two pair, trips and quads count.
+func showingRank(cards []Card) (handRank uint, countRanks []int) {
+	var hash [15]int
+	for _, card := range cards {
+		hash[card.rank()]++
+	}
+	counts := make([]int, 0)
+	for q := 4; q >= 1; q-- {
+		for i := len(hash) - 1; i >= 2; i-- {
+			if hash[i] == q {
+				counts = append(counts, q)
+				countRanks = append(countRanks, i)
+			}
+		}
+	}
+	switch {
+	case len(counts) == 0:
+		handRank = highCard
+	case counts[0] == 4:
+		handRank = fourOfAKind
+	case counts[0] == 3:
+		handRank = threeOfAKind
+	case counts[0] == 2 && len(counts) > 1 && counts[1] == 2:
+		handRank = twoPair
+	case counts[0] == 2:
+		handRank = onePair
+	}
+	return handRank, countRanks
+}
+
+// holeCards returns the cards player has been dealt so far this hand.
+func (g *Game) holeCards(player guid) []Card {
+	if !g.variant().stud() {
+		return g.deck.holeCards(player)
+	}
+	if g.history == nil {
+		return make([]Card, 0)
+	}
+	return append(make([]Card, 0), g.history.Hole[player]...)
+}
@@ -4,14 +4,16 @@ import "fmt"
 
 type Table []*Player
 
-func (t *Table) addPlayer(p guid) (err error) {
-	var newPlayer *Player = &Player{state: active, guid: p, wealth: 10000}
-	if len(*t) >= 10 {
+// addPlayer seats p, with the chips it already has, unless the table's
+// maxSeats are taken.
+func (t *Table) addPlayer(p *Player, maxSeats int) (err error) {
+	if len(*t) >= maxSeats {
 		err = fmt.Errorf("Table full!")
 		return err
 	}
 
-	*t = append(*t, newPlayer)
+	p.state = active
+	*t = append(*t, p)
 	return err
 
 }
@@ -53,17 +55,23 @@ func (t Table) getPlayers(guids []guid) []*Player {
 }
 
 // assignBestHands assigns to each player
-// her best hand from the current deal.
-func (t Table) assignBestHands(deck Deck) {
+// her best hand from the current deal, on the board of the given run.
+func (t Table) assignBestHands(deck Deck, v variant, run int) {
 	for _, p := range t {
-		allHands := generateAllHands(deck, p.guid)
-		p.bestHand = bestHand(allHands)
+		hole, board := dealtCards(deck, p.guid, v, run)
+		p.bestHand = v.best(hole, board)
+		p.bestLow = nil
+		if v.hiLo() {
+			if lows := findLowHands(v.hands(hole, board)); len(lows) > 0 {
+				p.bestLow = lows[0]
+			}
+		}
 	}
 }
 
 // bestHand returns the best Hand from a slice of Hands.
-func bestHand(hands []Hand) Hand {
-	return findWinningHands(hands)[0]
+func bestHand(hands []Hand, ranking handRanking) Hand {
+	return findWinningHands(hands, ranking)[0]
 }
 
 func (t Table) contains(id guid) bool {
@@ -75,16 +83,13 @@ func (t Table) contains(id guid) bool {
 	return false
 }
 
-func (t Table) remove(id guid) {
-	var index int
-	for i, player := range t {
-		if player.guid == id {
-			index = i
+// without returns the players of t other than id, leaving t as it is.
+func (t Table) without(id guid) Table {
+	rest := make(Table, 0, len(t))
+	for _, player := range t {
+		if player.guid != id {
+			rest = append(rest, player)
 		}
 	}
-	if index == len(t)-1 {
-		t = t[:index]
-	} else {
-		t = append(t[:index], t[index+1:]...)
-	}
+	return rest
 }
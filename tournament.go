@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TournamentRules make a game a sit-and-go tournament. It starts once
+// every seat is taken and nobody may join or buy more chips after that.
+// Blinds rise through Levels, and the game ends when one player has every
+// chip.
+type TournamentRules struct {
+	Levels []BlindLevel `json:"levels"`
+	// Blinds go up every HandsPerLevel hands or, if it is zero, every
+	// LevelMinutes minutes.
+	HandsPerLevel int `json:"hands_per_level"`
+	LevelMinutes  int `json:"level_minutes"`
+	// Payouts are the percentages of the prize pool paid to each place,
+	// first place first.
+	Payouts []int `json:"payouts"`
+}
+
+// BlindLevel is one step of a tournament's blind schedule.
+type BlindLevel struct {
+	SmallBlind money `json:"small_blind"`
+	BigBlind   money `json:"big_blind"`
+	Ante       money `json:"ante"`
+}
+
+// Standing is the place a player finished a tournament in.
+type Standing struct {
+	Place  int  `json:"place"`
+	Player guid `json:"playerID"`
+	Payout int  `json:"payout"`
+}
+
+// TournamentStatus is the public view of a tournament. MultiTable is set
+// if the game is one table of a multi-table tournament; the standings of
+// the whole tournament are kept there.
+type TournamentStatus struct {
+	MultiTable guid       `json:"tournamentID,omitempty"`
+	Level      int        `json:"level"`
+	SmallBlind money      `json:"small_blind"`
+	BigBlind   money      `json:"big_blind"`
+	Ante       money      `json:"ante"`
+	Entrants   int        `json:"entrants"`
+	Finished   bool       `json:"finished"`
+	Standings  []Standing `json:"standings"`
+}
+
+var errTournamentFull = errors.New("controller: tournament is full or has already started")
+
+// levelSteps are the blinds of the default schedule, in tenths of the
+// starting small blind.
+var levelSteps = []money{10, 15, 25, 50, 75, 100, 150, 200, 300, 500, 750, 1000}
+
+// defaultLevels returns a schedule starting at smallBlind that doubles
+// roughly every other level, with antes of a tenth of the big blind from
+// the fourth level on.
+func defaultLevels(smallBlind money) []BlindLevel {
+	levels := make([]BlindLevel, 0)
+	for i, step := range levelSteps {
+		l := BlindLevel{SmallBlind: smallBlind * step / 10, BigBlind: smallBlind * step / 5}
+		if i >= 3 {
+			l.Ante = l.BigBlind / 10
+		}
+		levels = append(levels, l)
+	}
+	return levels
+}
+
+// defaultPayouts returns the usual payouts for a table of seats players.
+func defaultPayouts(seats int) []int {
+	switch {
+	case seats >= 6:
+		return []int{50, 30, 20}
+	case seats >= 4:
+		return []int{65, 35}
+	default:
+		return []int{100}
+	}
+}
+
+func (tr TournamentRules) withDefaults(smallBlind money, seats int) TournamentRules {
+	if len(tr.Levels) == 0 {
+		tr.Levels = defaultLevels(smallBlind)
+	}
+	if tr.HandsPerLevel == 0 && tr.LevelMinutes == 0 {
+		tr.HandsPerLevel = 10
+	}
+	if len(tr.Payouts) == 0 {
+		tr.Payouts = defaultPayouts(seats)
+	}
+	return tr
+}
+
+func (tr TournamentRules) validate(seats int) error {
+	for i, l := range tr.Levels {
+		if l.BigBlind == 0 || l.BigBlind < l.SmallBlind {
+			return fmt.Errorf("Level %d must have a big blind at least as big as its small blind.", i+1)
+		}
+	}
+	if tr.HandsPerLevel < 0 || tr.LevelMinutes < 0 {
+		return errors.New("Levels can't last less than no time.")
+	}
+	if len(tr.Payouts) > seats {
+		return errors.New("A tournament can't pay more places than it has players.")
+	}
+	total := 0
+	for _, p := range tr.Payouts {
+		if p < 0 {
+			return errors.New("Payouts can't be negative.")
+		}
+		total += p
+	}
+	if total != 100 {
+		return errors.New("Payouts must add up to 100 percent.")
+	}
+	return nil
+}
+
+// tournament tracks the progress of a sit-and-go, or of one table of a
+// multi-table tournament.
+type tournament struct {
+	rules     TournamentRules
+	level     int
+	started   time.Time
+	entrants  int
+	standings []Standing
+	finished  bool
+	// multiTable is the ID of the multi-table tournament the game is a
+	// table of. mtt is that tournament, unless the game is being replayed.
+	multiTable guid
+	mtt        *MultiTable
+}
+
+func newTournament(rules TournamentRules) *tournament {
+	t := new(tournament)
+	t.rules = rules
+	t.standings = make([]Standing, 0)
+	return t
+}
+
+// ready returns true once the tournament has started, starting it if
+// every seat at g is taken. Tables of a multi-table tournament start with
+// the players they are given.
+func (t *tournament) ready(g *Game) bool {
+	if t.entrants > 0 {
+		return true
+	}
+	if len(g.table) < g.rules.MaxSeats && t.multiTable == "" {
+		return false
+	}
+	t.entrants = len(g.table)
+	t.started = time.Now()
+	g.controller.closeEntries()
+	return true
+}
+
+// setLevel moves g to the blind level the hand about to be played is at.
+func (t *tournament) setLevel(g *Game) {
+	level := 0
+	if t.mtt != nil {
+		level = t.mtt.blindLevel(g)
+	} else if t.rules.HandsPerLevel > 0 {
+		level = (g.hands - 1) / t.rules.HandsPerLevel
+	} else {
+		level = int(time.Since(t.started) / (time.Duration(t.rules.LevelMinutes) * time.Minute))
+	}
+	if level >= len(t.rules.Levels) {
+		level = len(t.rules.Levels) - 1
+	}
+	t.level = g.controller.blindLevel(g, t.level, level)
+	l := t.rules.Levels[t.level]
+	g.rules.SmallBlind = l.SmallBlind
+	g.rules.BigBlind = l.BigBlind
+	g.rules.Ante = l.Ante
+}
+
+// eliminate records the places of the players g's last hand busted. Of
+// players busted in the same hand, the one who started it with fewer chips
+// finishes lower. When only one player has chips left, they win. At a
+// table of a multi-table tournament, places are left to the tournament,
+// which may also move players to other tables.
+func (t *tournament) eliminate(g *Game) {
+	busted := make([]SeatRecord, 0)
+	remaining := make([]guid, 0)
+	for _, s := range g.history.Seats {
+		p := g.table.getPlayers([]guid{s.Player})
+		if len(p) == 0 || p[0].wealth == 0 {
+			busted = append(busted, s)
+		} else {
+			remaining = append(remaining, s.Player)
+		}
+	}
+	sort.Stable(byStack(busted))
+	if t.multiTable != "" {
+		ids := make([]guid, 0)
+		for _, s := range busted {
+			ids = append(ids, s.Player)
+		}
+		if t.mtt != nil {
+			t.mtt.handOver(g, ids)
+		} else {
+			g.controller.replayMoves(g)
+		}
+		return
+	}
+	for _, s := range busted {
+		g.controller.payPrize(g, t.place(s.Player))
+	}
+	if len(remaining) == 1 {
+		g.controller.payPrize(g, t.place(remaining[0]))
+		t.finished = true
+	}
+}
+
+// place gives player the best place not yet taken.
+func (t *tournament) place(player guid) Standing {
+	place := t.entrants - len(t.standings)
+	s := Standing{Place: place, Player: player}
+	if place <= len(t.rules.Payouts) {
+		s.Payout = t.rules.Payouts[place-1]
+	}
+	t.standings = append(t.standings, s)
+	return s
+}
+
+func (t *tournament) status() *TournamentStatus {
+	l := t.rules.Levels[t.level]
+	ts := &TournamentStatus{MultiTable: t.multiTable, Level: t.level + 1, SmallBlind: l.SmallBlind, BigBlind: l.BigBlind, Ante: l.Ante}
+	ts.Entrants = t.entrants
+	ts.Finished = t.finished
+	ts.Standings = make([]Standing, 0)
+	for i := len(t.standings) - 1; i >= 0; i-- {
+		ts.Standings = append(ts.Standings, t.standings[i])
+	}
+	return ts
+}
+
+type byStack []SeatRecord
+
+func (s byStack) Len() int           { return len(s) }
+func (s byStack) Less(i, j int) bool { return s[i].Stack < s[j].Stack }
+func (s byStack) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	bcrypt "golang.org/x/crypto/bcrypt"
+)
+
+// UserStore keeps the accounts that players authenticate with.
+type UserStore interface {
+	// Register creates an account for handle and returns its player ID.
+	// Registering an existing handle with its password returns the
+	// existing ID; with any other password it returns errUserExists.
+	Register(handle, password string) (guid, error)
+	// Authenticate returns the player ID for handle if password is correct.
+	Authenticate(handle, password string) (guid, error)
+}
+
+var (
+	errUserExists    = errors.New("users: handle already exists")
+	errUnknownUser   = errors.New("users: no such handle")
+	errWrongPassword = errors.New("users: wrong password")
+)
+
+// UserMap is a UserStore that lives only in memory and keeps passwords in
+// the clear. It is meant for tests.
+type UserMap struct {
+	handles   map[string]guid
+	passwords map[guid]string
+	sync.RWMutex
+}
+
+func NewUserMap() (um *UserMap) {
+	um = new(UserMap)
+	um.handles = make(map[string]guid)
+	um.passwords = make(map[guid]string)
+	return um
+}
+
+func (um *UserMap) Register(handle, password string) (guid, error) {
+	um.Lock()
+	defer um.Unlock()
+	playerID, ok := um.handles[handle]
+	if ok {
+		if um.passwords[playerID] != password {
+			return "", errUserExists
+		}
+		return playerID, nil
+	}
+	playerID = guid(createGuid())
+	um.handles[handle] = playerID
+	um.passwords[playerID] = password
+	return playerID, nil
+}
+
+func (um *UserMap) Authenticate(handle, password string) (guid, error) {
+	um.RLock()
+	defer um.RUnlock()
+	playerID, ok := um.handles[handle]
+	if !ok {
+		return "", errUnknownUser
+	}
+	if um.passwords[playerID] != password {
+		return "", errWrongPassword
+	}
+	return playerID, nil
+}
+
+// FileUserStore is a UserStore kept in a JSON file. Passwords are stored as
+// bcrypt hashes and the file is replaced atomically on every change.
+type FileUserStore struct {
+	path  string
+	users map[string]userRecord
+	// verified remembers a digest of the last password that passed bcrypt
+	// for each handle, so that bots authenticating on every request do not
+	// pay for a bcrypt comparison each time. The digests are HMACs under
+	// key, which is drawn afresh each time the store is opened and never
+	// leaves the process, so they can't be brute-forced any faster than
+	// bcrypt without it.
+	verified map[string][]byte
+	key      []byte
+	sync.Mutex
+}
+
+type userRecord struct {
+	PlayerID guid   `json:"playerID"`
+	Hash     string `json:"hash"`
+}
+
+// OpenFileUserStore loads the users kept at path. The file is created on
+// the first registration if it does not exist.
+func OpenFileUserStore(path string) (*FileUserStore, error) {
+	fs := new(FileUserStore)
+	fs.path = path
+	fs.users = make(map[string]userRecord)
+	fs.verified = make(map[string][]byte)
+	fs.key = make([]byte, sha256.Size)
+	_, err := rand.Read(fs.key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &fs.users)
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *FileUserStore) Register(handle, password string) (guid, error) {
+	playerID, err := fs.Authenticate(handle, password)
+	switch err {
+	case nil:
+		return playerID, nil
+	case errWrongPassword:
+		return "", errUserExists
+	case errUnknownUser:
+	default:
+		return "", err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	if _, ok := fs.users[handle]; ok {
+		// registered by someone else while we were hashing
+		return "", errUserExists
+	}
+	playerID = guid(createGuid())
+	fs.users[handle] = userRecord{PlayerID: playerID, Hash: string(hash)}
+	err = fs.save()
+	if err != nil {
+		delete(fs.users, handle)
+		return "", err
+	}
+	fs.verified[handle] = fs.digest(password)
+	return playerID, nil
+}
+
+func (fs *FileUserStore) Authenticate(handle, password string) (guid, error) {
+	digest := fs.digest(password)
+	fs.Lock()
+	user, ok := fs.users[handle]
+	known, verified := fs.verified[handle]
+	fs.Unlock()
+	if !ok {
+		return "", errUnknownUser
+	}
+	if verified && hmac.Equal(known, digest) {
+		return user.PlayerID, nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(password)) != nil {
+		return "", errWrongPassword
+	}
+	fs.Lock()
+	fs.verified[handle] = digest
+	fs.Unlock()
+	return user.PlayerID, nil
+}
+
+// digest returns the HMAC of password under the store's key.
+func (fs *FileUserStore) digest(password string) []byte {
+	mac := hmac.New(sha256.New, fs.key)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+// save writes every user to a temporary file and renames it over the
+// store, so a crash never leaves a half-written file behind.
+func (fs *FileUserStore) save() error {
+	return writeFileAtomic(fs.path, fs.users)
+}
+
+// writeFileAtomic encodes v as JSON into a temporary file next to path,
+// syncs it, and renames it over path.
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileUserStoreSurvivesReopening(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pokerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.json")
+
+	fs, err := OpenFileUserStore(path)
+	if err != nil {
+		t.Fatalf("could not open empty store: %v", err)
+	}
+	playerID, err := fs.Register("brian", "password")
+	if err != nil {
+		t.Fatalf("could not register user: %v", err)
+	}
+	if again, err := fs.Register("brian", "password"); err != nil || again != playerID {
+		t.Errorf("re-registering with same password: got %v, %v; expected %v, nil", again, err, playerID)
+	}
+	if _, err := fs.Register("brian", "wrongpassword"); err != errUserExists {
+		t.Errorf("registering taken handle: got err == %v, expected %v", err, errUserExists)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "password") {
+		t.Errorf("store contains plaintext password: %s", data)
+	}
+
+	fs, err = OpenFileUserStore(path)
+	if err != nil {
+		t.Fatalf("could not reopen store: %v", err)
+	}
+	if got, err := fs.Authenticate("brian", "password"); err != nil || got != playerID {
+		t.Errorf("authenticating after reopen: got %v, %v; expected %v, nil", got, err, playerID)
+	}
+	if _, err := fs.Authenticate("brian", "wrongpassword"); err != errWrongPassword {
+		t.Errorf("authenticating with wrong password: got err == %v, expected %v", err, errWrongPassword)
+	}
+	if _, err := fs.Authenticate("jake", "password"); err != errUnknownUser {
+		t.Errorf("authenticating unknown user: got err == %v, expected %v", err, errUnknownUser)
+	}
+}
+
+func TestFileUserStoreCacheIsKeyed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pokerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.json")
+
+	fs, err := OpenFileUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Register("brian", "password"); err != nil {
+		t.Fatalf("could not register user: %v", err)
+	}
+	reopened, err := OpenFileUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Authenticate("brian", "password"); err != nil {
+		t.Fatalf("could not authenticate: %v", err)
+	}
+	// the remembered digests are neither a plain hash of the password, nor
+	// the same from one opening of the store to the next
+	plain := sha256.Sum256([]byte("password"))
+	first, second := fs.verified["brian"], reopened.verified["brian"]
+	if bytes.Equal(first, plain[:]) || bytes.Equal(second, plain[:]) {
+		t.Error("store remembers an unkeyed hash of the password")
+	}
+	if bytes.Equal(first, second) {
+		t.Error("store remembers the same digest under different keys")
+	}
+	if _, err := reopened.Authenticate("brian", "wrongpassword"); err != errWrongPassword {
+		t.Errorf("authenticating with wrong password once verified: got err == %v, expected %v", err, errWrongPassword)
+	}
+}
@@ -14,7 +14,7 @@ func gamePrinter(g *Game) {
 	//Print Basics
 	fmt.Fprintf(f, "####GAME %v####\n", g.gameID)
 	fmt.Fprintf(f, "round: %v\n", g.round)
-	fmt.Fprintf(f, "small blind: %v\n", g.smallBlind)
+	fmt.Fprintf(f, "blinds: %v/%v\n", g.rules.SmallBlind, g.rules.BigBlind)
 	fmt.Fprintf(f, "deck : %s\n", g.deck)
 
 	//Print players
@@ -48,18 +48,3 @@ func s4() string {
 	}
 	return s
 }
-
-// generateCardNames returns a slice of strings, each 2 characters in length representing
-//  the 52 cards in a standard playing card deck
-func generateCardNames() (deck [52]string) {
-	suits := []string{"S", "C", "D", "H"}
-	ranks := []string{"2", "3", "4", "5", "6", "7", "8", "9", "T", "J", "Q", "K", "A"}
-	i := 0
-	for _, suit := range suits {
-		for _, rank := range ranks {
-			deck[i] = rank + suit
-			i++
-		}
-	}
-	return deck
-}
@@ -0,0 +1,166 @@
+package main
+
+// The games a table can play.
+const (
+	holdem    = "holdem"
+	omaha     = "omaha"
+	omahaHiLo = "omaha8"
+	stud      = "stud"
+	studHiLo  = "stud8"
+	shortDeck = "shortdeck"
+)
+
+var boardStreets = []string{"PREFLOP", "FLOP", "TURN", "RIVER"}
+
+// variant is a game of poker: how many cards each player is dealt, and the
+// hands they can make from them and the board.
+type variant interface {
+	// name is the game's name in hand histories.
+	name() string
+	holeCards() int
+	boardCards() int
+	// streets are the names of the hand's betting rounds, in order.
+	streets() []string
+	// stud is true for games without a board or blinds, where players are
+	// dealt cards face up as well as down.
+	stud() bool
+	maxSeats() int
+	// hiLo is true if pots are split between the best high hand and the
+	// best eight-or-better low.
+	hiLo() bool
+	// hands returns every five-card hand a player may play, high or low.
+	hands(hole, board []Card) []Hand
+	// deck is the cards the game is dealt from, in the order a shuffle
+	// permutes.
+	deck() []Card
+	// ranking is how the game ranks high hands.
+	ranking() handRanking
+	// value returns the value of the best high hand hands would make
+	// from hole and board.
+	value(hole, board []Card) handValue
+	// best returns that best high hand.
+	best(hole, board []Card) Hand
+}
+
+var variants = map[string]variant{
+	holdem:    holdemVariant{},
+	omaha:     omahaVariant{},
+	omahaHiLo: omahaHiLoVariant{},
+	stud:      studVariant{},
+	studHiLo:  studHiLoVariant{},
+	shortDeck: shortDeckVariant{},
+}
+
+// variantNamed returns the variant called name. Games logged before tables
+// had a choice are hold'em.
+func variantNamed(name string) variant {
+	if v, ok := variants[name]; ok {
+		return v
+	}
+	return holdemVariant{}
+}
+
+func (g *Game) variant() variant {
+	return variantNamed(g.rules.Variant)
+}
+
+// holdemVariant is Texas Hold'em: two hole cards, and any five of them and
+// the board.
+type holdemVariant struct{}
+
+func (holdemVariant) name() string      { return "Hold'em" }
+func (holdemVariant) holeCards() int    { return 2 }
+func (holdemVariant) boardCards() int   { return 5 }
+func (holdemVariant) streets() []string { return boardStreets }
+func (holdemVariant) stud() bool        { return false }
+func (holdemVariant) hiLo() bool        { return false }
+func (holdemVariant) maxSeats() int     { return MAX_SEATS }
+func (holdemVariant) deck() []Card      { return UNSHUFFLED[:] }
+
+func (holdemVariant) ranking() handRanking { return standardRanking }
+
+func (holdemVariant) value(hole, board []Card) handValue {
+	return standardRanking.tables.evaluate(evalCards(append(append([]Card{}, hole...), board...)))
+}
+
+func (v holdemVariant) best(hole, board []Card) Hand {
+	return standardRanking.madeHand(append(append([]Card{}, hole...), board...), v.value(hole, board))
+}
+
+func (holdemVariant) hands(hole, board []Card) []Hand {
+	return nChooseK(append(append([]Card{}, hole...), board...), 5)
+}
+
+// omahaVariant is Omaha Hold'em: four hole cards, of which a hand must use
+// exactly two, with exactly three from the board.
+type omahaVariant struct {
+	holdemVariant
+}
+
+func (omahaVariant) name() string   { return "Omaha" }
+func (omahaVariant) holeCards() int { return 4 }
+
+func (omahaVariant) hands(hole, board []Card) []Hand {
+	hands := make([]Hand, 0)
+	for _, h := range nChooseK(hole, 2) {
+		for _, b := range nChooseK(board, 3) {
+			hands = append(hands, append(append(Hand{}, h...), b...))
+		}
+	}
+	return hands
+}
+
+func (v omahaVariant) value(hole, board []Card) (best handValue) {
+	for _, h := range v.hands(hole, board) {
+		if value := h.value(standardRanking); value > best {
+			best = value
+		}
+	}
+	return best
+}
+
+func (v omahaVariant) best(hole, board []Card) Hand {
+	return bestHand(v.hands(hole, board), standardRanking)
+}
+
+// omahaHiLoVariant is Omaha Hi/Lo, eight or better: Omaha with each pot
+// split between the best high hand and the best low, if anyone has one.
+// A low, like a high, uses exactly two hole cards.
+type omahaHiLoVariant struct {
+	omahaVariant
+}
+
+func (omahaHiLoVariant) name() string { return "Omaha Hi/Lo" }
+func (omahaHiLoVariant) hiLo() bool   { return true }
+
+// shortDeckVariant is Short Deck, or 6+, Hold'em: hold'em dealt from a
+// deck without the twos to fives. A flush beats a full house, and an ace
+// plays low in A-6-7-8-9.
+type shortDeckVariant struct {
+	holdemVariant
+}
+
+// SHORT_DECK is the 36 cards Short Deck is dealt from.
+var SHORT_DECK = generateShortDeck()
+
+func generateShortDeck() []Card {
+	deck := make([]Card, 0, 36)
+	for _, card := range generateCards() {
+		if card.rank() >= 6 {
+			deck = append(deck, card)
+		}
+	}
+	return deck
+}
+
+func (shortDeckVariant) name() string         { return "Short Deck Hold'em" }
+func (shortDeckVariant) deck() []Card         { return SHORT_DECK }
+func (shortDeckVariant) ranking() handRanking { return shortDeckRanking }
+
+func (shortDeckVariant) value(hole, board []Card) handValue {
+	return shortDeckRanking.tables.evaluate(evalCards(append(append([]Card{}, hole...), board...)))
+}
+
+func (v shortDeckVariant) best(hole, board []Card) Hand {
+	return shortDeckRanking.madeHand(append(append([]Card{}, hole...), board...), v.value(hole, board))
+}
@@ -4,17 +4,19 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	mux "github.com/gorilla/mux"
@@ -42,7 +44,7 @@ func parseAuthHeader(header string) (credentials []string, err error) {
 	return credentials, nil
 }
 
-func protector(um *UserMap, restricted func(http.ResponseWriter, *http.Request, guid)) http.HandlerFunc {
+func protector(users UserStore, restricted func(http.ResponseWriter, *http.Request, guid)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		credentials, err := parseAuthHeader(r.Header.Get("Authorization"))
 		if err != nil {
@@ -51,15 +53,19 @@ func protector(um *UserMap, restricted func(http.ResponseWriter, *http.Request,
 		}
 		username := credentials[0]
 		submitted := credentials[1]
-		playerID := um.handles[username]
-		password, ok := um.passwords[playerID]
-		if !ok {
+		playerID, err := users.Authenticate(username, submitted)
+		switch err {
+		case nil:
+		case errUnknownUser:
 			http.Error(w, "Invalid credentials [1].", http.StatusUnauthorized)
 			return
-		}
-		if submitted != password {
+		case errWrongPassword:
 			http.Error(w, "Invalid credentials [2].", http.StatusUnauthorized)
 			return
+		default:
+			log.Printf("Problem authenticating user: %v", err)
+			http.Error(w, "There's been a server error. It's probably programming-related. We're sorry. WS-65.", http.StatusInternalServerError)
+			return
 		}
 		// we have successfully authenticated the user; she is who she says she is.
 		restricted(w, r, playerID)
@@ -83,12 +89,156 @@ func (re RestExposer) getGames(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// readRules reads the JSON rules document in the body of r into rules.
+// An empty body leaves rules as they are. The form is parsed first, so a
+// form-encoded body is read as the request's parameters, not as rules.
+func readRules(r *http.Request, rules interface{}) error {
+	if err := r.ParseForm(); err != nil {
+		return errors.New("Couldn't read request.")
+	}
+	limited := &io.LimitedReader{R: r.Body, N: 1048576}
+	data, err := ioutil.ReadAll(limited)
+	if err != nil {
+		return errors.New("Couldn't read request.")
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return nil
+	}
+	err = json.Unmarshal(data, rules)
+	if err != nil {
+		return errors.New("Couldn't read rules: " + err.Error())
+	}
+	return nil
+}
+
+// readShuffle returns the shuffle asked for by r, cryptoShuffle by
+// default.
+func readShuffle(r *http.Request) (string, error) {
+	switch r.FormValue("shuffle") {
+	case mathShuffle:
+		return mathShuffle, nil
+	case "", cryptoShuffle:
+		return cryptoShuffle, nil
+	}
+	return "", errors.New("Shuffle must be \"math\" or \"crypto\".")
+}
+
 func (re RestExposer) makeGame(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
-	pg := re.gc.makeGame()
+	var rules Rules
+	err := readRules(r, &rules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rules = rules.withDefaults()
+	err = rules.validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	shuffle, err := readShuffle(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	seed := time.Now().UnixNano()
+	if s := r.FormValue("seed"); s != "" {
+		if shuffle == cryptoShuffle {
+			http.Error(w, "A game shuffled with crypto/rand can't be given a seed. Ask for the math shuffle to seed a game.", http.StatusBadRequest)
+			return
+		}
+		seed, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			http.Error(w, "Seed must be a whole number.", http.StatusBadRequest)
+			return
+		}
+	}
+	pg := re.gc.makeGame(seed, shuffle, rules)
 	g := re.gc.Games[guid(pg.GameID)]
 	joinGame(w, g, verifiedPlayerID)
 }
 
+func (re RestExposer) getTournaments(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]*MultiTableStatus, 0)
+	for _, m := range re.gc.Tournaments {
+		statuses = append(statuses, m.status())
+	}
+	enc := json.NewEncoder(w)
+	err := enc.Encode(statuses)
+	if err != nil {
+		log.Printf("Error in getTournaments when encoding tournaments: %v\n", err)
+	}
+}
+
+func (re RestExposer) makeTournament(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
+	var rules MultiTableRules
+	err := readRules(r, &rules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rules = rules.withDefaults()
+	err = rules.validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	shuffle, err := readShuffle(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m := re.gc.makeTournament(rules, shuffle)
+	registerTournament(w, re.gc, m, verifiedPlayerID)
+}
+
+func (re RestExposer) getTournament(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	m, ok := re.gc.Tournaments[guid(vars["TournamentID"])]
+	if !ok {
+		http.Error(w, "Tournament not found.", http.StatusNotFound)
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.Encode(m.status())
+}
+
+func (re RestExposer) playerRegisterTournament(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
+	vars := mux.Vars(r)
+	m, ok := re.gc.Tournaments[guid(vars["TournamentID"])]
+	if !ok {
+		http.Error(w, "Tournament not found.", http.StatusNotFound)
+		return
+	}
+	registerTournament(w, re.gc, m, verifiedPlayerID)
+}
+
+func registerTournament(w http.ResponseWriter, gc *GameController, m *MultiTable, verifiedPlayerID guid) {
+	err := m.register(gc, verifiedPlayerID)
+	switch err {
+	case nil:
+	case errAlreadyRegistered:
+		http.Error(w, "This player has already registered for this tournament.", http.StatusConflict)
+		return
+	case errTournamentFull:
+		http.Error(w, "This tournament is full or has already started.", http.StatusConflict)
+		return
+	case errInsufficientFunds:
+		http.Error(w, "This player's bankroll can't cover the buy-in.", http.StatusPaymentRequired)
+		return
+	default:
+		log.Printf("Problem registering for tournament: %v", err)
+		http.Error(w, "There's been a server error. It's probably programming-related. We're sorry. WS-440.", http.StatusInternalServerError)
+		return
+	}
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusAccepted)
+	err = enc.Encode(m.status())
+	if err != nil {
+		log.Printf("Error in registerTournament when encoding tournament: %v\n", err)
+	}
+}
+
 func (re RestExposer) getGame(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	if _, ok := re.gc.Games[guid(vars["GameID"])]; !ok {
@@ -107,7 +257,7 @@ func (re RestExposer) getGameAuthenticated(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "Game not found.", http.StatusNotFound)
 		return
 	}
-	if !g.table.contains(verifiedPlayerID) {
+	if !g.controller.hasJoined(verifiedPlayerID) {
 		http.Error(w, "The authenticated player has not joined this game.", http.StatusForbidden)
 		return
 	}
@@ -116,6 +266,146 @@ func (re RestExposer) getGameAuthenticated(w http.ResponseWriter, r *http.Reques
 	enc.Encode(pg)
 }
 
+func (re RestExposer) streamGame(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gameID := guid(vars["GameID"])
+	g, ok := re.gc.Games[gameID]
+	if !ok {
+		http.Error(w, "Game not found.", http.StatusNotFound)
+		return
+	}
+	streamEvents(w, r, g, func() PublicGame { return re.gc.getGame(gameID) })
+}
+
+func (re RestExposer) streamGameAuthenticated(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
+	vars := mux.Vars(r)
+	gameID := guid(vars["GameID"])
+	g, ok := re.gc.Games[gameID]
+	if !ok {
+		http.Error(w, "Game not found.", http.StatusNotFound)
+		return
+	}
+	if !g.controller.hasJoined(verifiedPlayerID) {
+		http.Error(w, "The authenticated player has not joined this game.", http.StatusForbidden)
+		return
+	}
+	streamEvents(w, r, g, func() PublicGame { return re.gc.getGamePrivate(gameID, verifiedPlayerID) })
+}
+
+// streamEvents sends view() to the client as a Server-Sent Event every time
+// the game's public state changes, until the client disconnects.
+func streamEvents(w http.ResponseWriter, r *http.Request, g *Game, view func() PublicGame) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported by this connection.", http.StatusInternalServerError)
+		return
+	}
+	updates := g.controller.listen()
+	defer g.controller.unlisten(updates)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	for {
+		data, err := json.Marshal(view())
+		if err != nil {
+			log.Printf("Error in streamEvents when encoding public game: %v\n", err)
+			return
+		}
+		_, err = fmt.Fprintf(w, "event: game\ndata: %s\n\n", data)
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-updates:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (re RestExposer) getHands(w http.ResponseWriter, r *http.Request) {
+	re.serveHands(w, r, "")
+}
+
+func (re RestExposer) getHandsAuthenticated(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
+	re.serveHands(w, r, verifiedPlayerID)
+}
+
+// serveHands writes the game's hand histories as seen by viewer, as JSON or,
+// if the format parameter is "text", in the PokerStars hand history format.
+func (re RestExposer) serveHands(w http.ResponseWriter, r *http.Request, viewer guid) {
+	vars := mux.Vars(r)
+	g, ok := re.gc.Games[guid(vars["GameID"])]
+	if !ok {
+		http.Error(w, "Game not found.", http.StatusNotFound)
+		return
+	}
+	hands := g.controller.handHistories()
+	for i, h := range hands {
+		hands[i] = h.viewFor(viewer)
+	}
+	if r.FormValue("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, h := range hands {
+			h.WriteText(w, viewer)
+			fmt.Fprint(w, "\n\n")
+		}
+		return
+	}
+	enc := json.NewEncoder(w)
+	err := enc.Encode(hands)
+	if err != nil {
+		log.Printf("Error in serveHands when encoding hands: %v\n", err)
+	}
+}
+
+func (re RestExposer) verifyHand(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	g, ok := re.gc.Games[guid(vars["GameID"])]
+	if !ok {
+		http.Error(w, "Game not found.", http.StatusNotFound)
+		return
+	}
+	number, err := strconv.Atoi(vars["Hand"])
+	if err != nil {
+		http.Error(w, "Hand not found.", http.StatusNotFound)
+		return
+	}
+	for _, h := range g.controller.handHistories() {
+		if h.Number == number {
+			enc := json.NewEncoder(w)
+			enc.Encode(VerifyHand(h.viewFor("")))
+			return
+		}
+	}
+	http.Error(w, "Hand not found. It may not have finished yet, or may be too old to remember.", http.StatusNotFound)
+}
+
+// calculateEquity returns each player's equity given their hole cards and
+// the board so far.
+func (re RestExposer) calculateEquity(w http.ResponseWriter, r *http.Request) {
+	limited := &io.LimitedReader{R: r.Body, N: 1048576}
+	data, err := ioutil.ReadAll(limited)
+	if err != nil {
+		http.Error(w, "Couldn't read request.", http.StatusBadRequest)
+		return
+	}
+	var req EquityRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		http.Error(w, "Couldn't read request. Cards are named like AS or TD.", http.StatusBadRequest)
+		return
+	}
+	result, err := CalculateEquity(req, rand.New(rand.NewSource(time.Now().UnixNano())))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.Encode(result)
+}
+
 func (re RestExposer) getPlayers(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	g, ok := re.gc.Games[guid(vars["GameID"])]
@@ -141,24 +431,60 @@ func (re RestExposer) playerJoinGame(w http.ResponseWriter, r *http.Request, ver
 
 func (re RestExposer) quitPlayer(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
 	vars := mux.Vars(r)
-	err := r.ParseForm()
-	if err != nil {
-		log.Fatalf("couldn't parse form")
+	playerID := guid(vars["PlayerID"])
+	if playerID != verifiedPlayerID {
+		http.Error(w, "You can only leave a game yourself.", http.StatusForbidden)
+		return
 	}
 	g, ok := re.gc.Games[guid(vars["GameID"])]
 	if !ok {
-		// TODO: handle errors
 		http.Error(w, "Game not found.", http.StatusNotFound)
 		return
 	}
+	if g.tournament != nil {
+		http.Error(w, "Tournament players can't leave before they are knocked out.", http.StatusConflict)
+		return
+	}
+	g.controller.requestLeave(playerID)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (re RestExposer) setSitOut(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
+	vars := mux.Vars(r)
 	playerID := guid(vars["PlayerID"])
-	if !g.table.contains(playerID) {
-		// TODO: handle errors
+	if playerID != verifiedPlayerID {
+		http.Error(w, "You can only choose whether you sit out.", http.StatusForbidden)
+		return
+	}
+	g, ok := re.gc.Games[guid(vars["GameID"])]
+	if !ok {
 		http.Error(w, "Game not found.", http.StatusNotFound)
 		return
 	}
-	g.table.remove(playerID) // does not exist
-	//send confirmation of kill
+	if g.tournament != nil {
+		http.Error(w, "Tournament players can't sit out.", http.StatusConflict)
+		return
+	}
+	if !g.controller.hasSeat(playerID) {
+		http.Error(w, "This player isn't seated at this game.", http.StatusNotFound)
+		return
+	}
+	limited := &io.LimitedReader{R: r.Body, N: 1048576}
+	data, err := ioutil.ReadAll(limited)
+	if err != nil {
+		http.Error(w, "Couldn't read request.", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		SitOut bool `json:"sit_out"`
+	}
+	err = json.Unmarshal(data, &body)
+	if err != nil {
+		http.Error(w, "Couldn't read request.", http.StatusBadRequest)
+		return
+	}
+	g.controller.setSitOut(playerID, body.SitOut)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (re RestExposer) makeAct(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
@@ -180,7 +506,7 @@ func (re RestExposer) makeAct(w http.ResponseWriter, r *http.Request, verifiedPl
 	act.Player = playerID
 	g := re.gc.Games[gameID]
 
-	if !g.table.contains(playerID) {
+	if !g.controller.isSeated(playerID) {
 		http.Error(w, "This player isn't seated at this game. Join game before trying to make a turn.", http.StatusUnauthorized)
 	}
 	err = g.controller.registerPlayerAct(*act)
@@ -191,7 +517,230 @@ func (re RestExposer) makeAct(w http.ResponseWriter, r *http.Request, verifiedPl
 	w.WriteHeader(http.StatusCreated)
 }
 
-func (re RestExposer) makeUser(um *UserMap) func(http.ResponseWriter, *http.Request) {
+// setPreAction queues a pre-action for a player to take when their turn
+// comes, from a body such as {"action": "check_fold"} or
+// {"action": "call", "amount": 200}.
+func (re RestExposer) setPreAction(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
+	vars := mux.Vars(r)
+	playerID := guid(vars["PlayerID"])
+	if playerID != verifiedPlayerID {
+		http.Error(w, "You can only queue your own pre-actions.", http.StatusForbidden)
+		return
+	}
+	g, ok := re.gc.Games[guid(vars["GameID"])]
+	if !ok {
+		http.Error(w, "Game not found.", http.StatusNotFound)
+		return
+	}
+	if !g.controller.isSeated(playerID) {
+		http.Error(w, "This player isn't seated at this game.", http.StatusNotFound)
+		return
+	}
+	limited := &io.LimitedReader{R: r.Body, N: 1048576}
+	data, err := ioutil.ReadAll(limited)
+	if err != nil {
+		http.Error(w, "Couldn't read request.", http.StatusBadRequest)
+		return
+	}
+	var pre PreAction
+	err = json.Unmarshal(data, &pre)
+	if err != nil {
+		http.Error(w, "Couldn't read request.", http.StatusBadRequest)
+		return
+	}
+	if !pre.valid() {
+		http.Error(w, "Pre-action must be fold, check_fold, call or call_any.", http.StatusBadRequest)
+		return
+	}
+	err = g.controller.setPreAction(playerID, pre)
+	if err != nil {
+		http.Error(w, "It's already your turn, so act instead.", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clearPreAction drops any pre-action a player has queued.
+func (re RestExposer) clearPreAction(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
+	vars := mux.Vars(r)
+	playerID := guid(vars["PlayerID"])
+	if playerID != verifiedPlayerID {
+		http.Error(w, "You can only clear your own pre-actions.", http.StatusForbidden)
+		return
+	}
+	g, ok := re.gc.Games[guid(vars["GameID"])]
+	if !ok {
+		http.Error(w, "Game not found.", http.StatusNotFound)
+		return
+	}
+	g.controller.clearPreAction(playerID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setStraddle sets whether a player straddles whenever they are after the
+// big blind, from a body of {"straddle": true} or {"straddle": false}.
+func (re RestExposer) setStraddle(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
+	vars := mux.Vars(r)
+	playerID := guid(vars["PlayerID"])
+	if playerID != verifiedPlayerID {
+		http.Error(w, "You can only choose whether you straddle.", http.StatusForbidden)
+		return
+	}
+	g, ok := re.gc.Games[guid(vars["GameID"])]
+	if !ok {
+		http.Error(w, "Game not found.", http.StatusNotFound)
+		return
+	}
+	if !g.rules.Straddles {
+		http.Error(w, "This table doesn't allow straddles.", http.StatusBadRequest)
+		return
+	}
+	if !g.controller.isSeated(playerID) {
+		http.Error(w, "This player isn't seated at this game.", http.StatusNotFound)
+		return
+	}
+	limited := &io.LimitedReader{R: r.Body, N: 1048576}
+	data, err := ioutil.ReadAll(limited)
+	if err != nil {
+		http.Error(w, "Couldn't read request.", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Straddle bool `json:"straddle"`
+	}
+	err = json.Unmarshal(data, &body)
+	if err != nil {
+		http.Error(w, "Couldn't read request.", http.StatusBadRequest)
+		return
+	}
+	g.controller.setStraddle(playerID, body.Straddle)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setRunItTwice sets whether a player agrees to run the board twice when
+// they are all in, from a body of {"run_it_twice": true} or
+// {"run_it_twice": false}. The board is only run twice if every player
+// all in agrees.
+func (re RestExposer) setRunItTwice(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
+	vars := mux.Vars(r)
+	playerID := guid(vars["PlayerID"])
+	if playerID != verifiedPlayerID {
+		http.Error(w, "You can only choose whether you run it twice.", http.StatusForbidden)
+		return
+	}
+	g, ok := re.gc.Games[guid(vars["GameID"])]
+	if !ok {
+		http.Error(w, "Game not found.", http.StatusNotFound)
+		return
+	}
+	if !g.rules.RunItTwice {
+		http.Error(w, "This table doesn't allow running it twice.", http.StatusBadRequest)
+		return
+	}
+	if !g.controller.isSeated(playerID) {
+		http.Error(w, "This player isn't seated at this game.", http.StatusNotFound)
+		return
+	}
+	limited := &io.LimitedReader{R: r.Body, N: 1048576}
+	data, err := ioutil.ReadAll(limited)
+	if err != nil {
+		http.Error(w, "Couldn't read request.", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		RunItTwice bool `json:"run_it_twice"`
+	}
+	err = json.Unmarshal(data, &body)
+	if err != nil {
+		http.Error(w, "Couldn't read request.", http.StatusBadRequest)
+		return
+	}
+	g.controller.setRunItTwice(playerID, body.RunItTwice)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setMuck sets whether a player mucks hands that can't win at showdown,
+// from a body of {"muck": true} or {"muck": false}.
+func (re RestExposer) setMuck(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
+	vars := mux.Vars(r)
+	playerID := guid(vars["PlayerID"])
+	if playerID != verifiedPlayerID {
+		http.Error(w, "You can only choose whether you muck.", http.StatusForbidden)
+		return
+	}
+	g, ok := re.gc.Games[guid(vars["GameID"])]
+	if !ok {
+		http.Error(w, "Game not found.", http.StatusNotFound)
+		return
+	}
+	if !g.controller.isSeated(playerID) {
+		http.Error(w, "This player isn't seated at this game.", http.StatusNotFound)
+		return
+	}
+	limited := &io.LimitedReader{R: r.Body, N: 1048576}
+	data, err := ioutil.ReadAll(limited)
+	if err != nil {
+		http.Error(w, "Couldn't read request.", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Muck bool `json:"muck"`
+	}
+	err = json.Unmarshal(data, &body)
+	if err != nil {
+		http.Error(w, "Couldn't read request.", http.StatusBadRequest)
+		return
+	}
+	g.controller.setMuck(playerID, body.Muck)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// showHand shows a player's hand from the last hand, which they won
+// without a showdown.
+func (re RestExposer) showHand(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
+	vars := mux.Vars(r)
+	playerID := guid(vars["PlayerID"])
+	if playerID != verifiedPlayerID {
+		http.Error(w, "You can only show your own hand.", http.StatusForbidden)
+		return
+	}
+	g, ok := re.gc.Games[guid(vars["GameID"])]
+	if !ok {
+		http.Error(w, "Game not found.", http.StatusNotFound)
+		return
+	}
+	err := g.controller.show(playerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (re RestExposer) getBalance(w http.ResponseWriter, r *http.Request, verifiedPlayerID guid) {
+	vars := mux.Vars(r)
+	if guid(vars["PlayerID"]) != verifiedPlayerID {
+		http.Error(w, "You can only see your own bankroll.", http.StatusForbidden)
+		return
+	}
+	if re.gc.ledger == nil {
+		http.Error(w, "This server doesn't keep bankrolls.", http.StatusNotFound)
+		return
+	}
+	s, err := re.gc.ledger.statement(verifiedPlayerID)
+	if err != nil {
+		log.Printf("Problem getting statement for player %v: %v", verifiedPlayerID, err)
+		http.Error(w, "There's been a server error. It's probably programming-related. We're sorry. WS-450.", http.StatusInternalServerError)
+		return
+	}
+	enc := json.NewEncoder(w)
+	err = enc.Encode(s)
+	if err != nil {
+		log.Printf("Error in getBalance when encoding statement: %v\n", err)
+	}
+}
+
+func (re RestExposer) makeUser(users UserStore) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		credentials, err := parseAuthHeader(r.Header.Get("Authorization"))
 		if err != nil {
@@ -201,24 +750,18 @@ func (re RestExposer) makeUser(um *UserMap) func(http.ResponseWriter, *http.Requ
 		username := credentials[0]
 		if len(username) == 0 {
 			http.Error(w, "Username cannot be zero-length; choose another username.", http.StatusBadRequest)
+			return
 		}
 		submitted := credentials[1]
-		um.Lock()
-		defer um.Unlock()
-		playerID, ok := um.handles[username]
-		if ok {
-			if um.passwords[playerID] != submitted {
-				http.Error(w, "Username already exists; choose another username.", http.StatusForbidden)
-				return
-			}
-		} else {
-			playerID = guid(createGuid())
-			um.handles[username] = playerID
-			um.passwords[playerID] = submitted
-			if err != nil {
-				log.Printf("Problem generating password: %v", err)
-				http.Error(w, "There's been a server error. It's probably programming-related. We're sorry. WS-210.", http.StatusInternalServerError)
-			}
+		playerID, err := users.Register(username, submitted)
+		if err == errUserExists {
+			http.Error(w, "Username already exists; choose another username.", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Printf("Problem registering user: %v", err)
+			http.Error(w, "There's been a server error. It's probably programming-related. We're sorry. WS-210.", http.StatusInternalServerError)
+			return
 		}
 		w.WriteHeader(http.StatusCreated)
 		enc := json.NewEncoder(w)
@@ -231,7 +774,17 @@ func (re RestExposer) makeUser(um *UserMap) func(http.ResponseWriter, *http.Requ
 
 }
 
+var usersPath = flag.String("users", "users.json", "file in which user accounts are kept")
+var ledgerPath = flag.String("ledger", "ledger.log", "file in which players' bankrolls are kept")
+var logDir = flag.String("logs", "logs", "directory in which to log games for replay; empty to disable")
+var replayPath = flag.String("replay", "", "replay the game log at this path, print its hand histories, and exit")
+
 func main() {
+	flag.Parse()
+	if *replayPath != "" {
+		replayLog(*replayPath)
+		return
+	}
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	f, err := os.Create("profile1.prof")
 	if err != nil {
@@ -244,44 +797,108 @@ func main() {
 		fmt.Println("stopped profile...")
 	})
 
-	UserMap := NewUserMap()
+	users, err := OpenFileUserStore(*usersPath)
+	if err != nil {
+		log.Fatalf("Could not open user store: %v", err)
+	}
 	gc := NewGameController()
-	re := ExposeByREST(gc)
+	gc.ledger, err = OpenLedger(*ledgerPath)
+	if err != nil {
+		log.Fatalf("Could not open ledger: %v", err)
+	}
+	if *logDir != "" {
+		err = os.MkdirAll(*logDir, 0755)
+		if err != nil {
+			log.Fatalf("Could not create log directory: %v", err)
+		}
+		gc.logDir = *logDir
+		err = gc.Recover()
+		if err != nil {
+			log.Fatalf("Could not recover games: %v", err)
+		}
+	}
+	r := NewRouter(ExposeByREST(gc), users)
+
+	/*	r.HandleFunc("/blah/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hi")
+	}) */
+	http.Handle("/", r)
+	http.ListenAndServe(":8080", nil)
+	//http.ListenAndServeTLS(":10443", "cert.pem", "key.pem", nil)
+
+}
+
+// NewRouter routes every endpoint of the API to re, authenticating players
+// against users.
+func NewRouter(re RestExposer, users UserStore) *mux.Router {
 	r := mux.NewRouter().StrictSlash(true)
 	r.Handle("/demo/cards/{rest}", http.StripPrefix("/demo/cards/", http.FileServer(http.Dir("./demo/cards/"))))
 
 	r.HandleFunc("/demo/", re.serveDemo)
 
-	r.HandleFunc("/users/", re.makeUser(UserMap)).Methods("POST")
+	r.HandleFunc("/users/", re.makeUser(users)).Methods("POST")
+	r.HandleFunc("/users/{PlayerID:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}/balance/", protector(users, re.getBalance)).Methods("GET")
 
 	//user := users.PathPrefix("/{UserID:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}").Subrouter()
-	//user.HandleFunc("/", protector(UserMap, re.updateUser)).Methods("PUT")
-	//user.HandleFunc("/", protector(UserMap, re.removeUser)).Methods("DELETE")
+	//user.HandleFunc("/", protector(users, re.updateUser)).Methods("PUT")
+	//user.HandleFunc("/", protector(users, re.removeUser)).Methods("DELETE")
 
 	r.HandleFunc("/games/", re.getGames).Methods("GET")
-	r.HandleFunc("/games/", protector(UserMap, re.makeGame)).Methods("POST") // consider not allowing users to make games
+	r.HandleFunc("/games/", protector(users, re.makeGame)).Methods("POST") // consider not allowing users to make games
+
+	r.HandleFunc("/equity/", re.calculateEquity).Methods("POST")
+
+	r.HandleFunc("/tournaments/", re.getTournaments).Methods("GET")
+	r.HandleFunc("/tournaments/", protector(users, re.makeTournament)).Methods("POST")
+
+	tournaments := r.PathPrefix("/tournaments").Subrouter()
+	tournaments.HandleFunc("/{TournamentID:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}/", re.getTournament).Methods("GET")
+	tournaments.HandleFunc("/{TournamentID:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}/players/", protector(users, re.playerRegisterTournament)).Methods("POST")
 
 	games := r.PathPrefix("/games").Subrouter()
-	games.HandleFunc("/{GameID:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}/", protector(UserMap, re.getGameAuthenticated)).Methods("GET").Headers("Authorization", "")
+	games.HandleFunc("/{GameID:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}/", protector(users, re.getGameAuthenticated)).Methods("GET").Headers("Authorization", "")
 	games.HandleFunc("/{GameID:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}/", re.getGame).Methods("GET")
 
 	game := games.PathPrefix("/{GameID:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}").Subrouter()
+	game.HandleFunc("/events/", protector(users, re.streamGameAuthenticated)).Methods("GET").Headers("Authorization", "")
+	game.HandleFunc("/events/", re.streamGame).Methods("GET")
+	game.HandleFunc("/hands/", protector(users, re.getHandsAuthenticated)).Methods("GET").Headers("Authorization", "")
+	game.HandleFunc("/hands/", re.getHands).Methods("GET")
+	game.HandleFunc("/hands/{Hand:[0-9]+}/verify/", re.verifyHand).Methods("GET")
 	game.HandleFunc("/players/", re.getPlayers).Methods("GET")
-	game.HandleFunc("/players/", protector(UserMap, re.playerJoinGame)).Methods("POST")
+	game.HandleFunc("/players/", protector(users, re.playerJoinGame)).Methods("POST")
 
 	players := game.PathPrefix("/players").Subrouter()
-	players.HandleFunc("/{PlayerID:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}/", protector(UserMap, re.quitPlayer)).Methods("DELETE")
+	players.HandleFunc("/{PlayerID:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}/", protector(users, re.quitPlayer)).Methods("DELETE")
 
 	player := players.PathPrefix("/{PlayerID:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}").Subrouter()
-	player.HandleFunc("/acts/", protector(UserMap, re.makeAct)).Methods("POST")
-
-	/*	r.HandleFunc("/blah/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, "Hi")
-	}) */
-	http.Handle("/", r)
-	http.ListenAndServe(":8080", nil)
-	//http.ListenAndServeTLS(":10443", "cert.pem", "key.pem", nil)
+	player.HandleFunc("/acts/", protector(users, re.makeAct)).Methods("POST")
+	player.HandleFunc("/straddle/", protector(users, re.setStraddle)).Methods("POST")
+	player.HandleFunc("/run_it_twice/", protector(users, re.setRunItTwice)).Methods("POST")
+	player.HandleFunc("/muck/", protector(users, re.setMuck)).Methods("POST")
+	player.HandleFunc("/show/", protector(users, re.showHand)).Methods("POST")
+	player.HandleFunc("/sit_out/", protector(users, re.setSitOut)).Methods("POST")
+	player.HandleFunc("/pre_action/", protector(users, re.setPreAction)).Methods("POST")
+	player.HandleFunc("/pre_action/", protector(users, re.clearPreAction)).Methods("DELETE")
+	player.HandleFunc("/socket/", protector(users, re.playerSocket)).Methods("GET")
+	return r
+}
 
+// replayLog replays the game logged at path and prints the history of
+// every hand to standard out.
+func replayLog(path string) {
+	gameLog, err := readGameLog(path)
+	if err != nil {
+		log.Fatalf("Could not read game log: %v", err)
+	}
+	g, err := Replay(gameLog)
+	for _, h := range g.controller.handHistories() {
+		h.WriteText(os.Stdout, "")
+		fmt.Print("\n\n")
+	}
+	if err != nil {
+		log.Fatalf("Replay stopped early: %v", err)
+	}
 }
 
 type RestExposer struct {
@@ -294,22 +911,17 @@ func ExposeByREST(gc *GameController) (re RestExposer) {
 	return re
 }
 
-type UserMap struct {
-	handles   map[string]guid
-	passwords map[guid]string
-	sync.RWMutex
-}
-
-func NewUserMap() (um *UserMap) {
-	um = new(UserMap)
-	um.handles = make(map[string]guid)
-	um.passwords = make(map[guid]string)
-	return um
-}
-
 func joinGame(w http.ResponseWriter, g *Game, verifiedPlayerID guid) {
-	p := NewPlayer(verifiedPlayerID)
+	p := NewPlayer(verifiedPlayerID, g.rules.BuyIn)
 	err := g.controller.enqueuePlayer(g, p)
+	if err == errTournamentFull {
+		http.Error(w, "This tournament is full or has already started.", http.StatusConflict)
+		return
+	}
+	if err == errInsufficientFunds {
+		http.Error(w, "This player's bankroll can't cover the buy-in.", http.StatusPaymentRequired)
+		return
+	}
 	if err != nil {
 		// TODO: make error type to marshal errors into for sending to clients
 		http.Error(w, "This player has already joined this game.", http.StatusConflict)
@@ -317,7 +929,7 @@ func joinGame(w http.ResponseWriter, g *Game, verifiedPlayerID guid) {
 	}
 	enc := json.NewEncoder(w)
 	w.WriteHeader(http.StatusAccepted)
-	pg := MakePublicGame(g)
+	pg := g.controller.publicGame()
 	err = enc.Encode(pg)
 	if err != nil {
 		log.Printf("Error in joinGame when encoding public game: %v\n", err)